@@ -29,6 +29,7 @@ module "s3_bucket" {
 
   enable_versioning = var.enable_versioning
   mfa_delete        = var.mfa_delete
+  lifecycle_rules   = var.lifecycle_rules
 
   # Grant read and write access to the current IAM user running this module
   bucket_policy_statements = {
@@ -32,3 +32,8 @@ output "replica_bucket_name" {
   description = "The name of the replica S3 bucket."
   value       = module.s3_bucket.replica_bucket_name
 }
+
+output "replica_kms_key_arn" {
+  description = "The ARN of the KMS key used to encrypt objects replicated to the replica S3 bucket."
+  value       = aws_kms_key.replica.arn
+}
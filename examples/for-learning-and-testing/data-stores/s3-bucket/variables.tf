@@ -43,3 +43,9 @@ variable "mfa_delete" {
   type        = bool
   default     = false
 }
+
+variable "lifecycle_rules" {
+  description = "The lifecycle rules for the primary S3 bucket. See the lifecycle_rules variable in the s3-bucket module for details."
+  type        = any
+  default     = {}
+}
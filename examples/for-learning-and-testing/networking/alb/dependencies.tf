@@ -41,6 +41,7 @@ data "aws_ami" "ubuntu" {
 
 locals {
   alb_entry_port    = 443
+  alb_http_port     = 80
   default_http_port = 8080
 
   user_data = <<EOF
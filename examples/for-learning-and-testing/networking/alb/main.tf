@@ -31,12 +31,18 @@ module "alb" {
 
   is_internal_alb                = false
   allow_inbound_from_cidr_blocks = ["0.0.0.0/0"]
+  http_listener_ports            = [local.alb_http_port]
   https_listener_ports_and_acm_ssl_certs = [
     {
       port            = local.alb_entry_port
       tls_domain_name = "*.${data.aws_route53_zone.alb.name}"
     },
   ]
+  ssl_policy = var.ssl_policy
+
+  # Requests that don't match any of the listener rules get this fixed response
+  default_action_body        = var.default_action_body
+  default_action_status_code = var.default_action_status_code
 
   # Configure a domain name for the ALB
   create_route53_entry = true
@@ -97,6 +103,28 @@ resource "aws_lb_listener_rule" "host_based_routing" {
   }
 }
 
+# Redirect all HTTP requests to the HTTPS listener
+resource "aws_lb_listener_rule" "redirect_http_to_https" {
+  listener_arn = module.alb.listener_arns[local.alb_http_port]
+  priority     = 100
+
+  action {
+    type = "redirect"
+
+    redirect {
+      port        = local.alb_entry_port
+      protocol    = "HTTPS"
+      status_code = var.http_to_https_redirect_status_code
+    }
+  }
+
+  condition {
+    path_pattern {
+      values = ["*"]
+    }
+  }
+}
+
 resource "aws_security_group" "webserver" {
   vpc_id = data.aws_vpc.default.id
 
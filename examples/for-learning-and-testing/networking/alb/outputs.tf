@@ -7,3 +7,13 @@ output "alb_access_logs_bucket" {
   description = "The name of the S3 bucket containing the ALB access logs"
   value       = module.alb.alb_access_logs_bucket
 }
+
+output "original_alb_dns_name" {
+  description = "The AWS-managed DNS name assigned to the ALB."
+  value       = module.alb.original_alb_dns_name
+}
+
+output "https_listener_acm_cert_arns" {
+  description = "The map of HTTPS listener ports to ARNs of the listeners that use ACM certificates."
+  value       = module.alb.https_listener_acm_cert_arns
+}
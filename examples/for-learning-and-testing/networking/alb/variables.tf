@@ -35,3 +35,27 @@ variable "base_domain_name_tags" {
   type        = map(string)
   default     = {}
 }
+
+variable "ssl_policy" {
+  description = "The AWS predefined TLS/SSL policy for the HTTPS listener of the ALB."
+  type        = string
+  default     = "ELBSecurityPolicy-TLS-1-2-2017-01"
+}
+
+variable "http_to_https_redirect_status_code" {
+  description = "The HTTP status code the HTTP listener responds with when redirecting requests to HTTPS. Must be HTTP_301 or HTTP_302."
+  type        = string
+  default     = "HTTP_301"
+}
+
+variable "default_action_body" {
+  description = "The body of the fixed response the ALB returns for requests that don't match any of the listener rules."
+  type        = string
+  default     = "Not found"
+}
+
+variable "default_action_status_code" {
+  description = "The status code of the fixed response the ALB returns for requests that don't match any of the listener rules."
+  type        = number
+  default     = 404
+}
@@ -27,3 +27,8 @@ output "public_hosted_zones_name_servers" {
   description = "The name servers associated with the public Route 53 Hosted Zones"
   value       = module.route53.public_hosted_zones_name_servers
 }
+
+output "public_hosted_zone_map" {
+  description = "A map of domains to the IDs of the public Route 53 Hosted Zones that hold their records"
+  value       = module.route53.public_hosted_zone_map
+}
@@ -20,5 +20,8 @@ module "sns_topic" {
 
   create_resources = var.create_resources
 
-  name = var.name
-}
\ No newline at end of file
+  name                   = var.name
+  allow_publish_accounts = var.allow_publish_accounts
+  allow_publish_services = var.allow_publish_services
+  kms_master_key_id      = var.kms_master_key_id
+}
@@ -33,3 +33,15 @@ variable "allow_publish_services" {
     "cloudwatch.amazonaws.com"
   ]
 }
+
+variable "allow_publish_accounts" {
+  description = "A list of IAM ARNs that will be given the rights to publish to the SNS topic."
+  type        = list(string)
+  default     = []
+}
+
+variable "kms_master_key_id" {
+  description = "The ID of an AWS-managed customer master key (CMK) for Amazon SNS or a custom CMK"
+  type        = string
+  default     = "alias/aws/sns"
+}
@@ -24,6 +24,11 @@ module "vpc" {
   # Providing an existing key avoids creating a new one every run.
   # This is good to avoid since each costs $1/month.
   kms_key_arn = data.aws_kms_key.kms_key.arn
+
+  # Strip the rules from the default security group, so that nothing can use it by mistake.
+  enable_default_security_group        = var.enable_default_security_group
+  default_security_group_ingress_rules = var.default_security_group_ingress_rules
+  default_security_group_egress_rules  = var.default_security_group_egress_rules
 }
 
 # ----------------------------------------------------------------------------------------------------------------------
@@ -43,6 +48,27 @@ resource "aws_security_group" "example" {
     # (e.g., solely a bastion host or VPN server).
     cidr_blocks = ["0.0.0.0/0"]
   }
+
+  # Allow SSH access to the instance if a Key Pair is provided. This is used in tests to run commands (e.g., checking
+  # connectivity to peered VPCs) from the instance.
+  dynamic "ingress" {
+    # The contents of the list do not matter as it is only used to determine whether or not to include the subblock.
+    for_each = var.keypair_name != null ? ["once"] : []
+    content {
+      from_port   = 22
+      to_port     = 22
+      protocol    = "tcp"
+      cidr_blocks = ["0.0.0.0/0"]
+    }
+  }
+
+  # Allow all outbound traffic from the instance, so that it can reach other systems (e.g., in peered VPCs).
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = "-1"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
 }
 
 resource "aws_instance" "example" {
@@ -51,6 +77,7 @@ resource "aws_instance" "example" {
   subnet_id                   = element(module.vpc.public_subnet_ids, 0)
   vpc_security_group_ids      = [aws_security_group.example.id]
   associate_public_ip_address = true
+  key_name                    = var.keypair_name
 
   user_data = <<-EOF
               #!/bin/bash
@@ -30,3 +30,23 @@ output "instance_ip" {
   description = "The IP of the instance that runs inside the VPC"
   value       = aws_instance.example.public_ip
 }
+
+output "public_subnet_cidr_blocks" {
+  description = "The CIDR blocks of the public subnets of the VPC."
+  value       = module.vpc.public_subnet_cidr_blocks
+}
+
+output "private_subnet_cidr_blocks" {
+  description = "The CIDR blocks of the private subnets of the VPC."
+  value       = module.vpc.private_subnet_cidr_blocks
+}
+
+output "private_subnet_ids" {
+  description = "The IDs of the private subnets from the VPC"
+  value       = module.vpc.private_subnet_ids
+}
+
+output "num_availability_zones" {
+  description = "The number of availability zones that the VPC spreads its subnets across."
+  value       = module.vpc.num_availability_zones
+}
@@ -51,4 +51,26 @@ variable "kms_key_id" {
   default     = "alias/dedicated-test-key"
 }
 
+variable "keypair_name" {
+  description = "The name of an EC2 Key Pair to associate with the example EC2 instance. If set, SSH access to the instance is allowed. Set to null to disable SSH access."
+  type        = string
+  default     = null
+}
+
+variable "enable_default_security_group" {
+  description = "If set to false, the default security group of the VPC will not be managed by Terraform."
+  type        = bool
+  default     = true
+}
+
+variable "default_security_group_ingress_rules" {
+  description = "The ingress rules to apply to the default security group in the VPC. Defaults to no rules, which strips the rules AWS adds to the default security group, so that resources that end up in it by mistake can't receive any traffic."
+  type        = any
+  default     = {}
+}
 
+variable "default_security_group_egress_rules" {
+  description = "The egress rules to apply to the default security group in the VPC. Defaults to no rules, which strips the rules AWS adds to the default security group, so that resources that end up in it by mistake can't send any traffic."
+  type        = any
+  default     = {}
+}
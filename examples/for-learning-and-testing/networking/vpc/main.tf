@@ -22,8 +22,22 @@ module "vpc" {
 
   # Providing an existing key avoids to create a new one every run, this is good to avoid since each costs $1/month
   # NOTE: This is only used if create_flow_logs is true.
-  kms_key_arn      = length(data.aws_kms_key.kms_key) > 0 ? data.aws_kms_key.kms_key[0].arn : null
-  create_flow_logs = var.create_flow_logs
+  kms_key_arn            = length(data.aws_kms_key.kms_key) > 0 ? data.aws_kms_key.kms_key[0].arn : null
+  create_flow_logs       = var.create_flow_logs
+  flow_logs_traffic_type = var.flow_logs_traffic_type
+
+  # Strip the rules from the default security group, so that nothing can use it by mistake.
+  enable_default_security_group        = var.enable_default_security_group
+  default_security_group_ingress_rules = var.default_security_group_ingress_rules
+  default_security_group_egress_rules  = var.default_security_group_egress_rules
+
+  # Optionally peer this VPC with another (e.g., Mgmt) VPC.
+  create_peering_connection    = var.create_peering_connection
+  origin_vpc_id                = var.origin_vpc_id
+  origin_vpc_name              = var.origin_vpc_name
+  origin_vpc_cidr_block        = var.origin_vpc_cidr_block
+  origin_vpc_route_table_ids   = var.origin_vpc_route_table_ids
+  origin_vpc_public_subnet_ids = var.origin_vpc_public_subnet_ids
 }
 
 # ----------------------------------------------------------------------------------------------------------------------
@@ -12,3 +12,43 @@ output "instance_ip" {
   description = "The IP of the instance that runs inside the VPC"
   value       = aws_instance.example.public_ip
 }
+
+output "vpc_cidr_block" {
+  description = "The CIDR block of the VPC."
+  value       = module.vpc.vpc_cidr_block
+}
+
+output "instance_private_ip" {
+  description = "The private IP of the instance that runs inside the VPC"
+  value       = aws_instance.example.private_ip
+}
+
+output "public_subnet_cidr_blocks" {
+  description = "The CIDR blocks of the public subnets of the VPC."
+  value       = module.vpc.public_subnet_cidr_blocks
+}
+
+output "private_app_subnet_cidr_blocks" {
+  description = "The CIDR blocks of the private app subnets of the VPC."
+  value       = module.vpc.private_app_subnet_cidr_blocks
+}
+
+output "private_persistence_subnet_cidr_blocks" {
+  description = "The CIDR blocks of the private persistence subnets of the VPC."
+  value       = module.vpc.private_persistence_subnet_cidr_blocks
+}
+
+output "private_app_subnet_ids" {
+  description = "The IDs of the private app subnets from the VPC"
+  value       = module.vpc.private_app_subnet_ids
+}
+
+output "private_persistence_subnet_ids" {
+  description = "The IDs of the private persistence subnets from the VPC"
+  value       = module.vpc.private_persistence_subnet_ids
+}
+
+output "num_availability_zones" {
+  description = "The number of availability zones that the VPC spreads its subnets across."
+  value       = module.vpc.num_availability_zones
+}
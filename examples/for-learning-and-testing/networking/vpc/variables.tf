@@ -45,6 +45,12 @@ variable "create_flow_logs" {
   default     = false
 }
 
+variable "flow_logs_traffic_type" {
+  description = "The type of traffic to capture in the VPC flow log. Valid values include ACCEPT, REJECT, or ALL. Only used if create_flow_logs is true."
+  type        = string
+  default     = "REJECT"
+}
+
 variable "instance_types" {
   description = "A list of instance types to look up in the current AWS region."
   type        = list(string)
@@ -86,3 +92,21 @@ variable "origin_vpc_public_subnet_ids" {
   type        = list(string)
   default     = null
 }
+
+variable "enable_default_security_group" {
+  description = "If set to false, the default security group of the VPC will not be managed by Terraform."
+  type        = bool
+  default     = true
+}
+
+variable "default_security_group_ingress_rules" {
+  description = "The ingress rules to apply to the default security group in the VPC. Defaults to no rules, which strips the rules AWS adds to the default security group, so that resources that end up in it by mistake can't receive any traffic."
+  type        = any
+  default     = {}
+}
+
+variable "default_security_group_egress_rules" {
+  description = "The egress rules to apply to the default security group in the VPC. Defaults to no rules, which strips the rules AWS adds to the default security group, so that resources that end up in it by mistake can't send any traffic."
+  type        = any
+  default     = {}
+}
@@ -54,6 +54,24 @@ module "eks_cluster" {
   worker_vpc_subnet_ids            = module.vpc.private_app_subnet_ids
   num_worker_vpc_subnet_ids        = module.vpc.num_availability_zones
 
+  # The worker groups are defined in locals below, so that they can be output as well.
+  autoscaling_group_configurations  = local.autoscaling_group_configurations
+  managed_node_group_configurations = local.managed_node_group_configurations
+
+  # To keep this example simple, we make the Control Plane public and allow incoming API calls and SSH connections from
+  # anywhere. In production, you'll want to make the Control Plane private and limit access to trusted servers only
+  # (e.g., solely a bastion host or VPN server).
+  endpoint_public_access                       = true
+  cluster_instance_associate_public_ip_address = true
+  allow_inbound_api_access_from_cidr_blocks    = ["0.0.0.0/0"]
+  allow_inbound_ssh_from_cidr_blocks           = ["0.0.0.0/0"]
+
+  # Configuration variables for the aws-auth-merger
+  enable_aws_auth_merger = var.enable_aws_auth_merger
+  aws_auth_merger_image  = var.aws_auth_merger_image
+}
+
+locals {
   # Due to localization limitations for EKS, it is recommended to have separate ASGs per availability zones. Here we
   # deploy one ASG in one public subnet. We use public subnets so we can SSH into the node for testing.
   autoscaling_group_configurations = (
@@ -81,18 +99,6 @@ module "eks_cluster" {
       }
     }
   )
-
-  # To keep this example simple, we make the Control Plane public and allow incoming API calls and SSH connections from
-  # anywhere. In production, you'll want to make the Control Plane private and limit access to trusted servers only
-  # (e.g., solely a bastion host or VPN server).
-  endpoint_public_access                       = true
-  cluster_instance_associate_public_ip_address = true
-  allow_inbound_api_access_from_cidr_blocks    = ["0.0.0.0/0"]
-  allow_inbound_ssh_from_cidr_blocks           = ["0.0.0.0/0"]
-
-  # Configuration variables for the aws-auth-merger
-  enable_aws_auth_merger = var.enable_aws_auth_merger
-  aws_auth_merger_image  = var.aws_auth_merger_image
 }
 
 # ----------------------------------------------------------------------------------------------------------------------
@@ -105,7 +111,7 @@ module "vpc" {
   source = "../../../../modules/networking/vpc"
 
   aws_region           = var.aws_region
-  cidr_block           = "10.0.0.0/16"
+  cidr_block           = var.vpc_cidr_block
   num_nat_gateways     = 1
   vpc_name             = var.cluster_name
   create_flow_logs     = false
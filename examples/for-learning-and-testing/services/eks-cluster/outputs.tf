@@ -57,3 +57,18 @@ output "eks_default_fargate_execution_role_arn" {
   description = "A basic IAM Role ARN that has the minimal permissions to pull images from ECR that can be used for most Pods as Fargate Execution Role that do not need to interact with AWS."
   value       = module.eks_cluster.eks_default_fargate_execution_role_arn
 }
+
+output "aws_auth_merger_namespace" {
+  description = "The namespace name for the aws-auth-merger add on, if created."
+  value       = module.eks_cluster.aws_auth_merger_namespace
+}
+
+output "autoscaling_group_configurations" {
+  description = "The configurations of the ASGs of the self-managed EKS workers, as passed to the eks-cluster module."
+  value       = local.autoscaling_group_configurations
+}
+
+output "managed_node_group_configurations" {
+  description = "The configurations of the Managed Node Groups of the EKS workers, as passed to the eks-cluster module."
+  value       = local.managed_node_group_configurations
+}
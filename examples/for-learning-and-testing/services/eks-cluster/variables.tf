@@ -51,3 +51,9 @@ variable "fargate_only" {
   type        = bool
   default     = false
 }
+
+variable "vpc_cidr_block" {
+  description = "The IP address range of the VPC that is created for the cluster, in CIDR notation."
+  type        = string
+  default     = "10.0.0.0/16"
+}
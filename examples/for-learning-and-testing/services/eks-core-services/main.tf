@@ -56,4 +56,7 @@ module "eks_core_services" {
   enable_alb_ingress_controller = var.enable_alb_ingress_controller
   enable_external_dns           = var.enable_external_dns
   enable_cluster_autoscaler     = var.enable_cluster_autoscaler
+
+  # Fargate pods ship their logs with the permissions of their Pod execution role.
+  fargate_fluent_bit_execution_iam_role_arns = var.fargate_fluent_bit_execution_iam_role_arns
 }
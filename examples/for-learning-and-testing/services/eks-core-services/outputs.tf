@@ -0,0 +1,4 @@
+output "container_logs_cloudwatch_log_group_name" {
+  description = "Name of the CloudWatch Log Group used to store the container logs."
+  value       = module.eks_core_services.container_logs_cloudwatch_log_group_name
+}
@@ -100,6 +100,12 @@ variable "enable_fargate_fluent_bit" {
   default     = true
 }
 
+variable "fargate_fluent_bit_execution_iam_role_arns" {
+  description = "List of ARNs of Fargate execution IAM Roles that should get permissions to ship logs using fluent-bit. Required if enable_fargate_fluent_bit is true."
+  type        = list(string)
+  default     = []
+}
+
 variable "enable_aws_cloudwatch_agent" {
   description = "Whether to enable the AWS CloudWatch Agent DaemonSet for collecting container and node metrics from worker nodes (self-managed ASG or managed node groups)."
   type        = bool
@@ -70,7 +70,10 @@ module "application" {
   expose_type            = var.expose_type
   ingress_path           = var.ingress_path
   ingress_group          = var.ingress_group
-  desired_number_of_pods = 1
+  desired_number_of_pods = var.desired_number_of_pods
+  wait_timeout           = var.wait_timeout
+
+  min_number_of_pods_available = var.min_number_of_pods_available
 
   domain_name = var.domain_name
 
@@ -165,3 +165,21 @@ variable "ingress_access_logs_s3_prefix" {
   type        = string
   default     = null
 }
+
+variable "desired_number_of_pods" {
+  description = "The number of Pods to run for the app."
+  type        = number
+  default     = 1
+}
+
+variable "min_number_of_pods_available" {
+  description = "The minimum number of Pods that should be available at any given point in time, enforced with a PodDisruptionBudget (e.g., while the worker nodes are drained). Set to 0 to disable the PodDisruptionBudget."
+  type        = number
+  default     = 0
+}
+
+variable "wait_timeout" {
+  description = "Number of seconds to wait for the Pods of a rollout to become healthy before terraform marks the deployment as a failure."
+  type        = number
+  default     = 300
+}
@@ -0,0 +1,179 @@
+// Package alblogs parses the access log files that an Application Load Balancer writes to S3, so that tests can check
+// that the logs are well formed and record the requests the test made. The format is documented at
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#access-log-entry-format
+package alblogs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The number of fields every log entry has. AWS appends new fields to the end of the entries from time to time, so
+// entries can have more fields than this, which are ignored.
+const minFields = 25
+
+// The request types the ALB logs.
+var validTypes = map[string]bool{
+	"http":  true,
+	"https": true,
+	"h2":    true,
+	"grpcs": true,
+	"ws":    true,
+	"wss":   true,
+}
+
+// Entry is a parsed access log entry. Only the fields that tests assert on are broken out; the rest are kept in Fields.
+type Entry struct {
+	Type            string
+	Time            time.Time
+	LoadBalancer    string
+	ClientAddress   string
+	ELBStatusCode   int
+	Method          string
+	URL             string
+	Protocol        string
+	UserAgent       string
+	SSLCipher       string
+	SSLProtocol     string
+	DomainName      string
+	ChosenCertArn   string
+	ActionsExecuted []string
+	RedirectURL     string
+	Fields          []string
+}
+
+// ParseFile parses an access log file, as read from S3. The ALB gzips the files it writes, so the reader is expected to
+// produce gzipped data.
+func ParseFile(reader io.Reader) ([]Entry, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(gzipReader)
+	// Entries with long URLs or user agents can exceed the default buffer size of the scanner.
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		entry, err := ParseLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ParseLine parses a single access log entry, and returns an error if it is not a valid ALB log line.
+func ParseLine(line string) (Entry, error) {
+	fields, err := splitFields(line)
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(fields) < minFields {
+		return Entry{}, fmt.Errorf("Expected at least %d fields, but got %d", minFields, len(fields))
+	}
+
+	entry := Entry{
+		Type:          fields[0],
+		LoadBalancer:  fields[2],
+		ClientAddress: fields[3],
+		UserAgent:     fields[13],
+		SSLCipher:     fields[14],
+		SSLProtocol:   fields[15],
+		DomainName:    fields[18],
+		ChosenCertArn: fields[19],
+		RedirectURL:   fields[23],
+		Fields:        fields,
+	}
+
+	if !validTypes[entry.Type] {
+		return Entry{}, fmt.Errorf("Unknown request type %q", entry.Type)
+	}
+	if entry.Time, err = time.Parse(time.RFC3339Nano, fields[1]); err != nil {
+		return Entry{}, fmt.Errorf("Invalid time %q: %s", fields[1], err)
+	}
+	if !strings.HasPrefix(entry.LoadBalancer, "app/") {
+		return Entry{}, fmt.Errorf("Invalid load balancer ID %q", entry.LoadBalancer)
+	}
+	if _, _, err := net.SplitHostPort(entry.ClientAddress); err != nil {
+		return Entry{}, fmt.Errorf("Invalid client address %q: %s", entry.ClientAddress, err)
+	}
+	for _, index := range []int{5, 6, 7} {
+		if _, err := strconv.ParseFloat(fields[index], 64); err != nil {
+			return Entry{}, fmt.Errorf("Invalid processing time %q: %s", fields[index], err)
+		}
+	}
+	if entry.ELBStatusCode, err = strconv.Atoi(fields[8]); err != nil {
+		return Entry{}, fmt.Errorf("Invalid ELB status code %q: %s", fields[8], err)
+	}
+	for _, index := range []int{10, 11} {
+		if _, err := strconv.ParseInt(fields[index], 10, 64); err != nil {
+			return Entry{}, fmt.Errorf("Invalid byte count %q: %s", fields[index], err)
+		}
+	}
+
+	request := strings.SplitN(fields[12], " ", 3)
+	if len(request) != 3 {
+		return Entry{}, fmt.Errorf("Invalid request %q", fields[12])
+	}
+	entry.Method, entry.URL, entry.Protocol = request[0], request[1], request[2]
+
+	if fields[22] != "-" {
+		entry.ActionsExecuted = strings.Split(fields[22], ",")
+	}
+	return entry, nil
+}
+
+// splitFields splits a log entry into its fields. Fields are separated by spaces, and fields that can contain spaces
+// are wrapped in double quotes, with any double quotes inside them escaped with a backslash.
+func splitFields(line string) ([]string, error) {
+	fields := []string{}
+	for i := 0; i < len(line); {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+
+		if line[i] != '"' {
+			end := strings.IndexByte(line[i:], ' ')
+			if end < 0 {
+				end = len(line) - i
+			}
+			fields = append(fields, line[i:i+end])
+			i += end
+			continue
+		}
+
+		var field strings.Builder
+		closed := false
+		for i++; i < len(line); i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+				field.WriteByte(line[i])
+				continue
+			}
+			if line[i] == '"' {
+				closed = true
+				i++
+				break
+			}
+			field.WriteByte(line[i])
+		}
+		if !closed {
+			return nil, fmt.Errorf("Unterminated quoted field")
+		}
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
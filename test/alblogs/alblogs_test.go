@@ -0,0 +1,88 @@
+package alblogs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Example entries from the ALB documentation.
+const (
+	httpsEntry    = `https 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.086 0.048 0.037 200 200 0 57 "GET https://www.example.com:443/ HTTP/1.1" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337281-1d84f3d73c47ec4e58577259" "www.example.com" "arn:aws:acm:us-east-2:123456789012:certificate/12345678-1234-1234-1234-123456789012" 1 2018-07-02T22:22:48.364000Z "authenticate,forward" "-" "-" "10.0.0.1:80" "200" "-" "-"`
+	redirectEntry = `http 2018-11-30T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 - 0.000 0.001 0.000 301 - 0 57 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.46.0" - - - "Root=1-58337364-23a8c76965a2ef7629b185e3" "-" "-" 0 2018-11-30T22:22:48.364000Z "redirect" "https://www.example.com:443/" "-" "-" "-" "-" "-"`
+)
+
+func TestParseLine(t *testing.T) {
+	t.Parallel()
+
+	entry, err := ParseLine(httpsEntry)
+	require.NoError(t, err)
+	assert.Equal(t, "https", entry.Type)
+	assert.Equal(t, "app/my-loadbalancer/50dc6c495c0c9188", entry.LoadBalancer)
+	assert.Equal(t, 200, entry.ELBStatusCode)
+	assert.Equal(t, "GET", entry.Method)
+	assert.Equal(t, "https://www.example.com:443/", entry.URL)
+	assert.Equal(t, "HTTP/1.1", entry.Protocol)
+	assert.Equal(t, "curl/7.46.0", entry.UserAgent)
+	assert.Equal(t, "ECDHE-RSA-AES128-GCM-SHA256", entry.SSLCipher)
+	assert.Equal(t, "TLSv1.2", entry.SSLProtocol)
+	assert.Equal(t, "www.example.com", entry.DomainName)
+	assert.Equal(t, []string{"authenticate", "forward"}, entry.ActionsExecuted)
+	assert.Equal(t, 2018, entry.Time.Year())
+
+	entry, err = ParseLine(redirectEntry)
+	require.NoError(t, err)
+	assert.Equal(t, 301, entry.ELBStatusCode)
+	assert.Equal(t, []string{"redirect"}, entry.ActionsExecuted)
+	assert.Equal(t, "https://www.example.com:443/", entry.RedirectURL)
+
+	// Quoted fields can contain escaped quotes
+	entry, err = ParseLine(replaceOnce(httpsEntry, `"curl/7.46.0"`, `"agent \"with quotes\""`))
+	require.NoError(t, err)
+	assert.Equal(t, `agent "with quotes"`, entry.UserAgent)
+}
+
+func TestParseLineInvalid(t *testing.T) {
+	t.Parallel()
+
+	invalidLines := map[string]string{
+		"too few fields":      `https 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188`,
+		"unknown type":        replaceOnce(httpsEntry, "https ", "ftp "),
+		"invalid time":        replaceOnce(httpsEntry, "2018-07-02T22:23:00.186641Z", "yesterday"),
+		"not an ALB":          replaceOnce(httpsEntry, "app/my-loadbalancer", "net/my-loadbalancer"),
+		"invalid status code": replaceOnce(httpsEntry, " 200 200 ", " OK 200 "),
+		"invalid request":     replaceOnce(httpsEntry, `"GET https://www.example.com:443/ HTTP/1.1"`, `"GET"`),
+		"unterminated quote":  httpsEntry[:len(httpsEntry)-1],
+	}
+	for name, line := range invalidLines {
+		_, err := ParseLine(line)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write([]byte(httpsEntry + "\n" + redirectEntry + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	entries, err := ParseFile(bytes.NewReader(buffer.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "https", entries[0].Type)
+	assert.Equal(t, "http", entries[1].Type)
+
+	_, err = ParseFile(bytes.NewReader([]byte(httpsEntry)))
+	assert.Error(t, err)
+}
+
+func replaceOnce(s string, old string, new string) string {
+	return strings.Replace(s, old, new, 1)
+}
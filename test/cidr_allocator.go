@@ -0,0 +1,286 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/stretchr/testify/require"
+)
+
+// The pool of addresses that test CIDR blocks are allocated from. This does not overlap with the default CIDR blocks of
+// the examples (e.g., 10.0.0.0/16 for the mgmt VPC), so tests that use the defaults can still peer with allocated
+// blocks. Override it with the TEST_CIDR_POOL environment variable.
+const defaultTestCIDRPool = "10.128.0.0/9"
+
+// Settings for the lock that guards the allocations file. Go runs the tests of each package in a separate process, so
+// an in-memory mutex is not enough.
+const (
+	cidrLockMaxWait    = 2 * time.Minute
+	cidrLockRetryDelay = 100 * time.Millisecond
+	cidrLockStaleAfter = 1 * time.Minute
+)
+
+// The file that tracks which CIDR blocks are in use by tests running on this machine. It is a variable so that it can
+// be pointed at a temp file in unit tests.
+var cidrAllocationsFile = filepath.Join(os.TempDir(), "aws-service-catalog-test-cidr-allocations.json")
+
+// cidrAllocation is a CIDR block that is in use by a test.
+type cidrAllocation struct {
+	CIDRBlock string `json:"cidr_block"`
+	TestName  string `json:"test_name"`
+	PID       int    `json:"pid"`
+}
+
+// GetTestCIDRPool returns the pool of addresses that AllocateCIDRBlock hands out CIDR blocks from.
+func GetTestCIDRPool() string {
+	if pool := os.Getenv("TEST_CIDR_POOL"); pool != "" {
+		return pool
+	}
+	return defaultTestCIDRPool
+}
+
+// AllocateCIDRBlock reserves a CIDR block with the given prefix length (e.g., 16 for a /16) that does not overlap with
+// any other block allocated by tests running on this machine, and returns it. The block is released when the test and
+// all its subtests complete. Use this for the CIDR blocks of VPCs in tests that peer or share VPCs, so that parallel
+// tests never collide.
+func AllocateCIDRBlock(t *testing.T, prefixLength int) string {
+	cidrBlock, err := AllocateCIDRBlockE(t, prefixLength)
+	require.NoError(t, err)
+	return cidrBlock
+}
+
+// AllocateCIDRBlockE is like AllocateCIDRBlock, but returns an error instead of failing the test.
+func AllocateCIDRBlockE(t *testing.T, prefixLength int) (string, error) {
+	_, pool, err := net.ParseCIDR(GetTestCIDRPool())
+	if err != nil {
+		return "", fmt.Errorf("Invalid test CIDR pool %s: %s", GetTestCIDRPool(), err)
+	}
+
+	var cidrBlock string
+	err = updateCIDRAllocations(func(allocations []cidrAllocation) ([]cidrAllocation, error) {
+		allocations = liveCIDRAllocations(allocations)
+		block, err := findFreeCIDRBlock(pool, prefixLength, allocations)
+		if err != nil {
+			return nil, err
+		}
+		cidrBlock = block
+		return append(allocations, cidrAllocation{CIDRBlock: block, TestName: t.Name(), PID: os.Getpid()}), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	logger.Logf(t, "Allocated CIDR block %s for test %s", cidrBlock, t.Name())
+	releaseCIDRBlockOnCleanup(t, cidrBlock)
+	return cidrBlock, nil
+}
+
+// ReserveCIDRBlock reserves the given CIDR block, which was allocated with AllocateCIDRBlock by an earlier run of the
+// test, and returns it. Use this when a test stage that allocated the block is skipped and the block is loaded from the
+// test data instead, so that tests running in parallel are not handed a block that is still in use. Reserving a block
+// that this process already holds does nothing, so it is safe to call after the allocating stage as well. The block is
+// released when the test and all its subtests complete.
+func ReserveCIDRBlock(t *testing.T, cidrBlock string) string {
+	require.NoError(t, ReserveCIDRBlockE(t, cidrBlock))
+	return cidrBlock
+}
+
+// ReserveCIDRBlockE is like ReserveCIDRBlock, but returns an error instead of failing the test. Returns an error if the
+// block overlaps with a block that is in use by another test.
+func ReserveCIDRBlockE(t *testing.T, cidrBlock string) error {
+	_, block, err := net.ParseCIDR(cidrBlock)
+	if err != nil {
+		return fmt.Errorf("Invalid CIDR block %s: %s", cidrBlock, err)
+	}
+
+	alreadyReserved := false
+	err = updateCIDRAllocations(func(allocations []cidrAllocation) ([]cidrAllocation, error) {
+		allocations = liveCIDRAllocations(allocations)
+		for _, allocation := range allocations {
+			if allocation.CIDRBlock == block.String() && allocation.PID == os.Getpid() {
+				alreadyReserved = true
+				return allocations, nil
+			}
+			if _, network, err := net.ParseCIDR(allocation.CIDRBlock); err == nil && overlapsAny(block, []*net.IPNet{network}) {
+				return nil, fmt.Errorf("CIDR block %s overlaps with %s, which is in use by test %s", block, allocation.CIDRBlock, allocation.TestName)
+			}
+		}
+		return append(allocations, cidrAllocation{CIDRBlock: block.String(), TestName: t.Name(), PID: os.Getpid()}), nil
+	})
+	if err != nil || alreadyReserved {
+		return err
+	}
+
+	logger.Logf(t, "Reserved CIDR block %s for test %s", block, t.Name())
+	releaseCIDRBlockOnCleanup(t, block.String())
+	return nil
+}
+
+// releaseCIDRBlockOnCleanup releases the given CIDR block held by this process when the test and all its subtests
+// complete.
+func releaseCIDRBlockOnCleanup(t *testing.T, cidrBlock string) {
+	t.Cleanup(func() {
+		releaseErr := updateCIDRAllocations(func(allocations []cidrAllocation) ([]cidrAllocation, error) {
+			remaining := []cidrAllocation{}
+			for _, allocation := range allocations {
+				if allocation.CIDRBlock != cidrBlock || allocation.PID != os.Getpid() {
+					remaining = append(remaining, allocation)
+				}
+			}
+			return remaining, nil
+		})
+		if releaseErr != nil {
+			// Not fatal: the allocation is cleaned up automatically once this process exits.
+			logger.Logf(t, "WARNING: failed to release CIDR block %s: %s", cidrBlock, releaseErr)
+		}
+	})
+}
+
+// findFreeCIDRBlock returns the first block with the given prefix length in the pool that does not overlap with any of
+// the allocations.
+func findFreeCIDRBlock(pool *net.IPNet, prefixLength int, allocations []cidrAllocation) (string, error) {
+	poolPrefixLength, bits := pool.Mask.Size()
+	if bits != 32 {
+		return "", fmt.Errorf("Test CIDR pool %s is not an IPv4 CIDR block", pool)
+	}
+	if prefixLength < poolPrefixLength || prefixLength > 32 {
+		return "", fmt.Errorf("Can not allocate a /%d CIDR block from the test CIDR pool %s", prefixLength, pool)
+	}
+
+	allocated := []*net.IPNet{}
+	for _, allocation := range allocations {
+		if _, network, err := net.ParseCIDR(allocation.CIDRBlock); err == nil {
+			allocated = append(allocated, network)
+		}
+	}
+
+	poolStart := ipv4ToUint32(pool.IP)
+	blockSize := uint64(1) << uint(32-prefixLength)
+	numBlocks := uint64(1) << uint(prefixLength-poolPrefixLength)
+	for i := uint64(0); i < numBlocks; i++ {
+		candidate := &net.IPNet{
+			IP:   uint32ToIPv4(uint32(uint64(poolStart) + i*blockSize)),
+			Mask: net.CIDRMask(prefixLength, 32),
+		}
+		if !overlapsAny(candidate, allocated) {
+			return candidate.String(), nil
+		}
+	}
+	return "", fmt.Errorf("No free /%d CIDR block left in the test CIDR pool %s", prefixLength, pool)
+}
+
+func overlapsAny(candidate *net.IPNet, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(candidate.IP) || candidate.Contains(network.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// liveCIDRAllocations filters out the allocations of test processes that are no longer running, e.g. because they
+// crashed or were killed before they could clean up.
+func liveCIDRAllocations(allocations []cidrAllocation) []cidrAllocation {
+	live := []cidrAllocation{}
+	for _, allocation := range allocations {
+		if isProcessRunning(allocation.PID) {
+			live = append(live, allocation)
+		}
+	}
+	return live
+}
+
+func isProcessRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// Signal 0 does not send a signal, but still checks whether the process exists.
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// updateCIDRAllocations reads the allocations file, passes the allocations to the given function, and writes back the
+// allocations it returns, all while holding the lock on the file.
+func updateCIDRAllocations(update func([]cidrAllocation) ([]cidrAllocation, error)) error {
+	unlock, err := lockCIDRAllocations()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	allocations := []cidrAllocation{}
+	contents, err := ioutil.ReadFile(cidrAllocationsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(contents) > 0 {
+		if err := json.Unmarshal(contents, &allocations); err != nil {
+			return fmt.Errorf("Failed to parse CIDR allocations file %s: %s", cidrAllocationsFile, err)
+		}
+	}
+
+	allocations, err = update(allocations)
+	if err != nil {
+		return err
+	}
+
+	contents, err = json.MarshalIndent(allocations, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temp file and rename it, so that the allocations file is never left half written.
+	tmpFile := cidrAllocationsFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, contents, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, cidrAllocationsFile)
+}
+
+// lockCIDRAllocations takes the lock on the allocations file by exclusively creating a lock file, and returns a
+// function that releases it. A lock file that is older than cidrLockStaleAfter is assumed to be left behind by a
+// process that died while holding it, and is removed.
+func lockCIDRAllocations() (func(), error) {
+	lockFile := cidrAllocationsFile + ".lock"
+	deadline := time.Now().Add(cidrLockMaxWait)
+	for {
+		file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lockFile) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if info, statErr := os.Stat(lockFile); statErr == nil && time.Since(info.ModTime()) > cidrLockStaleAfter {
+			os.Remove(lockFile)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for lock file %s", lockFile)
+		}
+		time.Sleep(cidrLockRetryDelay)
+	}
+}
+
+func ipv4ToUint32(ip net.IP) uint32 {
+	ip = ip.To4()
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func uint32ToIPv4(addr uint32) net.IP {
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr)).To4()
+}
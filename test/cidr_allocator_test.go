@@ -0,0 +1,96 @@
+package test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindFreeCIDRBlock(t *testing.T) {
+	t.Parallel()
+
+	_, pool, err := net.ParseCIDR("10.128.0.0/14")
+	require.NoError(t, err)
+
+	allocations := []cidrAllocation{
+		{CIDRBlock: "10.128.0.0/16"},
+		{CIDRBlock: "10.129.4.0/24"},
+	}
+
+	block, err := findFreeCIDRBlock(pool, 16, allocations)
+	require.NoError(t, err)
+	assert.Equal(t, "10.130.0.0/16", block)
+
+	block, err = findFreeCIDRBlock(pool, 22, allocations)
+	require.NoError(t, err)
+	assert.Equal(t, "10.129.0.0/22", block)
+
+	block, err = findFreeCIDRBlock(pool, 24, allocations)
+	require.NoError(t, err)
+	assert.Equal(t, "10.129.0.0/24", block)
+
+	_, err = findFreeCIDRBlock(pool, 13, allocations)
+	assert.Error(t, err)
+
+	_, err = findFreeCIDRBlock(pool, 14, allocations)
+	assert.Error(t, err)
+}
+
+// This test changes the package level allocations file, so it must not run in parallel with other tests that allocate
+// CIDR blocks.
+func TestAllocateCIDRBlock(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cidr-allocator")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	originalFile := cidrAllocationsFile
+	cidrAllocationsFile = filepath.Join(tmpDir, "allocations.json")
+	defer func() { cidrAllocationsFile = originalFile }()
+
+	originalPool, hasPool := os.LookupEnv("TEST_CIDR_POOL")
+	os.Setenv("TEST_CIDR_POOL", defaultTestCIDRPool)
+	defer func() {
+		if hasPool {
+			os.Setenv("TEST_CIDR_POOL", originalPool)
+		} else {
+			os.Unsetenv("TEST_CIDR_POOL")
+		}
+	}()
+
+	// Allocations of a process that is no longer running are ignored.
+	require.NoError(t, updateCIDRAllocations(func([]cidrAllocation) ([]cidrAllocation, error) {
+		return []cidrAllocation{{CIDRBlock: "10.128.0.0/16", TestName: "TestDead", PID: -1}}, nil
+	}))
+
+	t.Run("allocate", func(t *testing.T) {
+		assert.Equal(t, "10.128.0.0/16", AllocateCIDRBlock(t, 16))
+		assert.Equal(t, "10.129.0.0/16", AllocateCIDRBlock(t, 16))
+
+		// Reserving a block this process already holds does nothing.
+		assert.NoError(t, ReserveCIDRBlockE(t, "10.128.0.0/16"))
+	})
+
+	t.Run("reserve", func(t *testing.T) {
+		// A block loaded from an earlier run is reserved again, so it is not handed out to other tests.
+		assert.Equal(t, "10.129.0.0/16", ReserveCIDRBlock(t, "10.129.0.0/16"))
+		assert.Equal(t, "10.128.0.0/16", AllocateCIDRBlock(t, 16))
+		assert.Equal(t, "10.130.0.0/16", AllocateCIDRBlock(t, 16))
+	})
+
+	// The blocks are released once the subtests complete.
+	require.NoError(t, updateCIDRAllocations(func(allocations []cidrAllocation) ([]cidrAllocation, error) {
+		assert.Empty(t, liveCIDRAllocations(allocations))
+		return allocations, nil
+	}))
+
+	// A block that overlaps with a block that is in use by another test can not be reserved.
+	require.NoError(t, updateCIDRAllocations(func([]cidrAllocation) ([]cidrAllocation, error) {
+		return []cidrAllocation{{CIDRBlock: "10.128.0.0/16", TestName: "TestOther", PID: os.Getppid()}}, nil
+	}))
+	assert.Error(t, ReserveCIDRBlockE(t, "10.128.4.0/24"))
+}
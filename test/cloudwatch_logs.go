@@ -0,0 +1,72 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/retry"
+)
+
+// GetLogStreamNamesWithPrefix returns the names of all the log streams in the given CloudWatch Logs group that start
+// with the given prefix.
+func GetLogStreamNamesWithPrefix(t *testing.T, awsRegion, logGroupName, logStreamPrefix string) ([]string, error) {
+	svc := aws.NewCloudWatchLogsClient(t, awsRegion)
+
+	input := cloudwatchlogs.DescribeLogStreamsInput{LogGroupName: awsgo.String(logGroupName)}
+	logStreamNames := []string{}
+	err := svc.DescribeLogStreamsPages(&input, func(page *cloudwatchlogs.DescribeLogStreamsOutput, lastPage bool) bool {
+		for _, logStream := range page.LogStreams {
+			logStreamName := awsgo.StringValue(logStream.LogStreamName)
+			if strings.HasPrefix(logStreamName, logStreamPrefix) {
+				logStreamNames = append(logStreamNames, logStreamName)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return logStreamNames, nil
+}
+
+// WaitForCloudWatchLogEntry searches the log streams in the given CloudWatch Logs group that start with the given prefix
+// for an entry that contains expectedLogEntry, until it finds one or runs out of retries. Returns the entry it found.
+func WaitForCloudWatchLogEntry(
+	t *testing.T,
+	awsRegion string,
+	logGroupName string,
+	logStreamPrefix string,
+	expectedLogEntry string,
+	maxRetries int,
+	timeBetweenRetries time.Duration,
+) string {
+	description := fmt.Sprintf("Looking in CloudWatch Logs group %s for '%s'", logGroupName, expectedLogEntry)
+
+	return retry.DoWithRetry(t, description, maxRetries, timeBetweenRetries, func() (string, error) {
+		logStreamNames, err := GetLogStreamNamesWithPrefix(t, awsRegion, logGroupName, logStreamPrefix)
+		if err != nil {
+			return "", err
+		}
+
+		for _, logStreamName := range logStreamNames {
+			entries, err := aws.GetCloudWatchLogEntriesE(t, awsRegion, logStreamName, logGroupName)
+			if err != nil {
+				return "", err
+			}
+
+			for _, entry := range entries {
+				if strings.Contains(entry, expectedLogEntry) {
+					return entry, nil
+				}
+			}
+		}
+
+		return "", fmt.Errorf("Did not find entry '%s' in CloudWatch Logs", expectedLogEntry)
+	})
+}
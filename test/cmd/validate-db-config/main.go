@@ -0,0 +1,85 @@
+// validate-db-config checks that an AWS Secrets Manager secret (or a local JSON file) contains database configuration
+// in the format that the rds and aurora modules expect to read via db_config_secrets_manager_id. Run it before a deploy
+// to catch malformed secrets early:
+//
+//	go run ./cmd/validate-db-config --secret-id my-db-config --region us-east-1
+//	go run ./cmd/validate-db-config --file db-config.json
+//
+// The command exits with a non-zero status and lists every problem found if the configuration is invalid. Note that the
+// password is never printed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+
+	"github.com/gruntwork-io/aws-service-catalog/test/dbconfig"
+)
+
+func main() {
+	secretID := flag.String("secret-id", "", "The friendly name or ARN of the Secrets Manager secret to validate.")
+	region := flag.String("region", "", "The AWS region of the secret. Defaults to the region configured in the environment.")
+	file := flag.String("file", "", "Path to a local JSON file to validate instead of a Secrets Manager secret.")
+	flag.Parse()
+
+	if err := run(*secretID, *region, *file); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(secretID string, region string, file string) error {
+	if (secretID == "") == (file == "") {
+		return fmt.Errorf("Exactly one of --secret-id or --file must be set.")
+	}
+
+	var secretString string
+	var source string
+	if file != "" {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		secretString = string(contents)
+		source = file
+	} else {
+		var err error
+		secretString, err = getSecretString(secretID, region)
+		if err != nil {
+			return err
+		}
+		source = secretID
+	}
+
+	config, err := dbconfig.Parse(secretString)
+	if err != nil {
+		return fmt.Errorf("%s: %s", source, err)
+	}
+	fmt.Printf("%s: valid %s configuration for database %s on port %s\n", source, config.Engine, config.Dbname, config.Port)
+	return nil
+}
+
+func getSecretString(secretID string, region string) (string, error) {
+	sessOpts := session.Options{SharedConfigState: session.SharedConfigEnable}
+	if region != "" {
+		sessOpts.Config.Region = awsgo.String(region)
+	}
+	sess, err := session.NewSessionWithOptions(sessOpts)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := secretsmanager.New(sess).GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: awsgo.String(secretID),
+	})
+	if err != nil {
+		return "", err
+	}
+	return awsgo.StringValue(output.SecretString), nil
+}
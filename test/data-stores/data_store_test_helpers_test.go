@@ -0,0 +1,17 @@
+package data_stores
+
+import (
+	"testing"
+
+	"github.com/gruntwork-io/aws-service-catalog/test/localdb"
+)
+
+// The following tests exercise the data store helpers against local containers, so that they can be run quickly
+// without deploying anything to AWS.
+
+func TestSmokeTestMysqlLocal(t *testing.T) {
+	t.Parallel()
+
+	info := localdb.StartMySQL(t)
+	SmokeTestMysql(t, RDSInfo(info))
+}
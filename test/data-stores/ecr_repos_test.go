@@ -7,12 +7,16 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gruntwork-io/aws-service-catalog/test"
 
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	"github.com/gruntwork-io/terratest/modules/docker"
 	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
 	"github.com/gruntwork-io/terratest/modules/shell"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
@@ -29,6 +33,9 @@ func TestEcrRepos(t *testing.T) {
 	//os.Setenv("SKIP_deploy_terraform", "true")
 	//os.Setenv("SKIP_build_and_push_docker_image", "true")
 	//os.Setenv("SKIP_validate_image", "true")
+	//os.Setenv("SKIP_push_lifecycle_images", "true")
+	//os.Setenv("SKIP_validate_image_scanning", "true")
+	//os.Setenv("SKIP_validate_lifecycle_policy", "true")
 	//os.Setenv("SKIP_cleanup", "true")
 
 	testFolder := test_structure.CopyTerraformFolderToTemp(t, "../../", "examples/for-learning-and-testing/data-stores/ecr-repos")
@@ -60,6 +67,7 @@ func TestEcrRepos(t *testing.T) {
 				"external_account_ids_with_write_access": []string{},
 				"tags":                                   map[string]string{"Organization": "Gruntwork"},
 				"enable_automatic_image_scanning":        true,
+				"lifecycle_policy_rules":                 lifecyclePolicyRulesForTest,
 			},
 		}
 
@@ -69,6 +77,30 @@ func TestEcrRepos(t *testing.T) {
 	})
 
 	validateECRRepo(t, testFolder)
+	validateECRRepoScanningAndLifecycle(t, testFolder)
+}
+
+// The number of images with the lifecycleTestTagPrefix that the lifecycle policy used in the test retains. Any older
+// images with the prefix are expired.
+const lifecycleTestTagPrefix = "release-"
+const lifecycleTestImagesToKeep = 2
+
+var lifecyclePolicyRulesForTest = map[string]interface{}{
+	"rules": []map[string]interface{}{
+		{
+			"rulePriority": 1,
+			"description":  "Keep only the most recent release images",
+			"selection": map[string]interface{}{
+				"tagStatus":     "tagged",
+				"tagPrefixList": []string{lifecycleTestTagPrefix},
+				"countType":     "imageCountMoreThan",
+				"countNumber":   lifecycleTestImagesToKeep,
+			},
+			"action": map[string]interface{}{
+				"type": "expire",
+			},
+		},
+	},
 }
 
 func TestEcrReposWithEncryption(t *testing.T) {
@@ -122,6 +154,166 @@ func TestEcrReposWithEncryption(t *testing.T) {
 	validateECRRepo(t, testFolder)
 }
 
+// TestEcrReposCrossAccountAccess verifies that the repository policies actually grant (or deny) pull and push access
+// to an external account, and that the per repo overrides take precedence over the defaults. To do so, we deploy a
+// single set of repos where the default read access is granted to the external account, and then override it on a
+// per repo basis:
+// - default: No override, so the external account should be able to pull, but not push.
+// - noaccess: Read access overridden to an empty list, so the external account should not be able to pull or push.
+// - write: Write access overridden to the external account, so the external account should be able to pull and push.
+func TestEcrReposCrossAccountAccess(t *testing.T) {
+	t.Parallel()
+
+	// Uncomment the items below to skip certain parts of the test
+	//os.Setenv("TERRATEST_REGION", "eu-west-1")
+	//os.Setenv("SKIP_setup", "true")
+	//os.Setenv("SKIP_deploy_terraform", "true")
+	//os.Setenv("SKIP_build_and_push_docker_image", "true")
+	//os.Setenv("SKIP_validate_cross_account_access", "true")
+	//os.Setenv("SKIP_cleanup", "true")
+
+	test.RequireEnvVar(t, "TEST_EXTERNAL_ACCOUNT_ID")
+	externalAccountID := test.GetExternalAccountId()
+
+	testFolder := test_structure.CopyTerraformFolderToTemp(t, "../../", "examples/for-learning-and-testing/data-stores/ecr-repos")
+
+	defer test_structure.RunTestStage(t, "cleanup", func() {
+		terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
+		terraform.Destroy(t, terraformOptions)
+		for _, varFile := range terraformOptions.VarFiles {
+			os.Remove(varFile)
+		}
+	})
+
+	test_structure.RunTestStage(t, "setup", func() {
+		awsRegion := aws.GetRandomStableRegion(t, nil, nil)
+		test_structure.SaveString(t, testFolder, "region", awsRegion)
+
+		uniqueID := strings.ToLower(random.UniqueId())
+		test_structure.SaveString(t, testFolder, "uniqueID", uniqueID)
+	})
+
+	test_structure.RunTestStage(t, "deploy_terraform", func() {
+		awsRegion := test_structure.LoadString(t, testFolder, "region")
+		uniqueID := test_structure.LoadString(t, testFolder, "uniqueID")
+
+		tfvars := map[string]interface{}{
+			"aws_region": awsRegion,
+			"repositories": map[string]interface{}{
+				crossAccountRepoName(uniqueID, "default"): map[string]interface{}{
+					// A nil list is passed through as null, which means the default should be used.
+					"external_account_ids_with_read_access":  nil,
+					"external_account_ids_with_write_access": nil,
+				},
+				crossAccountRepoName(uniqueID, "noaccess"): map[string]interface{}{
+					"external_account_ids_with_read_access":  []string{},
+					"external_account_ids_with_write_access": nil,
+				},
+				crossAccountRepoName(uniqueID, "write"): map[string]interface{}{
+					"external_account_ids_with_read_access":  []string{},
+					"external_account_ids_with_write_access": []string{externalAccountID},
+				},
+			},
+			"default_external_account_ids_with_read_access":  []string{externalAccountID},
+			"default_external_account_ids_with_write_access": []string{},
+		}
+		// We work around a terraform bug where we can't pass in null values to terraform on the CLI by using tfvars
+		// files. The tfvars file is removed in the cleanup stage.
+		terraformOptions, _ := constructTerraformOptionsWithVarFiles(t, testFolder, tfvars)
+		test_structure.SaveTerraformOptions(t, testFolder, terraformOptions)
+
+		terraform.InitAndApply(t, terraformOptions)
+	})
+
+	// Push an image to each repo from the current account so that there is something for the external account to pull.
+	test_structure.RunTestStage(t, "build_and_push_docker_image", func() {
+		awsRegion := test_structure.LoadString(t, testFolder, "region")
+		uniqueID := test_structure.LoadString(t, testFolder, "uniqueID")
+		terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
+		repoUrls := terraform.OutputMap(t, terraformOptions, "ecr_repo_urls")
+
+		imgTags := []string{}
+		for _, suffix := range []string{"default", "noaccess", "write"} {
+			imgTags = append(imgTags, fmt.Sprintf("%s:v1", repoUrls[crossAccountRepoName(uniqueID, suffix)]))
+		}
+		defer removeLocalDockerImages(t, imgTags)
+
+		buildOpts := &docker.BuildOptions{
+			Tags:         imgTags,
+			OtherOptions: []string{"--no-cache"},
+		}
+		docker.Build(t, "../fixtures/simple-docker-img", buildOpts)
+
+		test.AuthECRAndPushImages(t, awsRegion, imgTags)
+	})
+
+	test_structure.RunTestStage(t, "validate_cross_account_access", func() {
+		awsRegion := test_structure.LoadString(t, testFolder, "region")
+		uniqueID := test_structure.LoadString(t, testFolder, "uniqueID")
+		terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
+		repoUrls := terraform.OutputMap(t, terraformOptions, "ecr_repo_urls")
+
+		testCases := []struct {
+			suffix  string
+			canPull bool
+			canPush bool
+		}{
+			{"default", true, false},
+			{"noaccess", false, false},
+			{"write", true, true},
+		}
+
+		test.AuthECRWithRoleAndCallFn(t, awsRegion, aws.GetAccountId(t), test.GetExternalAccountRoleArn(), func() {
+			for _, testCase := range testCases {
+				repoUrl := repoUrls[crossAccountRepoName(uniqueID, testCase.suffix)]
+				pullTag := fmt.Sprintf("%s:v1", repoUrl)
+				pushTag := fmt.Sprintf("%s:external", repoUrl)
+
+				_, pullErr := runDockerE(t, "pull", pullTag)
+				if testCase.canPull {
+					assert.NoErrorf(t, pullErr, "Expected external account to be able to pull from %s", repoUrl)
+				} else {
+					assert.Errorf(t, pullErr, "Expected external account to be denied pulling from %s", repoUrl)
+				}
+
+				// We push the fixture image built locally under a new tag, so that the push is not a noop on an
+				// existing tag.
+				docker.Build(t, "../fixtures/simple-docker-img", &docker.BuildOptions{Tags: []string{pushTag}})
+				_, pushErr := runDockerE(t, "push", pushTag)
+				if testCase.canPush {
+					assert.NoErrorf(t, pushErr, "Expected external account to be able to push to %s", repoUrl)
+				} else {
+					assert.Errorf(t, pushErr, "Expected external account to be denied pushing to %s", repoUrl)
+				}
+
+				removeLocalDockerImages(t, []string{pullTag, pushTag})
+			}
+		})
+	})
+}
+
+func crossAccountRepoName(uniqueID string, suffix string) string {
+	return fmt.Sprintf("sample-app-%s-%s", uniqueID, suffix)
+}
+
+// runDockerE runs the docker command with the given args, returning an error instead of failing the test if the command
+// fails.
+func runDockerE(t *testing.T, args ...string) (string, error) {
+	cmd := shell.Command{
+		Command: "docker",
+		Args:    args,
+	}
+	return shell.RunCommandAndGetOutputE(t, cmd)
+}
+
+// removeLocalDockerImages removes the given image tags from the local docker daemon, ignoring any tags that do not
+// exist.
+func removeLocalDockerImages(t *testing.T, imgTags []string) {
+	for _, imgTag := range imgTags {
+		runDockerE(t, "rmi", imgTag)
+	}
+}
+
 // TODO: Once terratest has support for testing with plan files, update with plan testing for the various merge
 // functionalities. In lieu of that, we do the more brittle count based tests here for now.
 
@@ -310,3 +502,136 @@ func validateECRRepo(t *testing.T, testFolder string) {
 	})
 
 }
+
+// validateECRRepoScanningAndLifecycle pushes the fixture image under several tags and verifies that each one is scanned
+// on push, and that the lifecycle policy would expire all but the most recent release images.
+func validateECRRepoScanningAndLifecycle(t *testing.T, testFolder string) {
+	name := test_structure.LoadString(t, testFolder, "repoName")
+	awsRegion := test_structure.LoadString(t, testFolder, "region")
+	terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
+	repoUrl := terraform.OutputMap(t, terraformOptions, "ecr_repo_urls")[name]
+
+	releaseTags := []string{}
+	for i := 1; i <= lifecycleTestImagesToKeep+2; i++ {
+		releaseTags = append(releaseTags, fmt.Sprintf("%s%d", lifecycleTestTagPrefix, i))
+	}
+
+	test_structure.RunTestStage(t, "push_lifecycle_images", func() {
+		// Each tag is built with a unique label so that it results in a distinct image in ECR. Otherwise, all the tags
+		// would point to the same image digest, and the lifecycle policy would only see a single image.
+		imgTags := []string{}
+		for _, releaseTag := range releaseTags {
+			imgTag := fmt.Sprintf("%s:%s", repoUrl, releaseTag)
+			buildOpts := &docker.BuildOptions{
+				Tags:         []string{imgTag},
+				OtherOptions: []string{"--label", fmt.Sprintf("release=%s", releaseTag)},
+			}
+			docker.Build(t, "../fixtures/simple-docker-img", buildOpts)
+			imgTags = append(imgTags, imgTag)
+		}
+		defer removeLocalDockerImages(t, imgTags)
+
+		// Push the images one at a time (AuthECRAndPushImages pushes sequentially) so that the push timestamps are
+		// ordered by release number.
+		test.AuthECRAndPushImages(t, awsRegion, imgTags)
+	})
+
+	test_structure.RunTestStage(t, "validate_image_scanning", func() {
+		client := aws.NewECRClient(t, awsRegion)
+		for _, releaseTag := range releaseTags {
+			status := waitForECRImageScan(t, client, name, releaseTag)
+
+			// The fixture is based on the hello-world image, which has no OS packages, so basic scanning may report it
+			// as unsupported rather than complete. Either way, this shows that the image was scanned on push.
+			assert.Contains(t, []string{ecr.ScanStatusComplete, ecr.ScanStatusUnsupportedImage}, status)
+		}
+	})
+
+	test_structure.RunTestStage(t, "validate_lifecycle_policy", func() {
+		client := aws.NewECRClient(t, awsRegion)
+		expiredTags := getECRLifecyclePreviewExpiredTags(t, client, name)
+
+		// The oldest images beyond the number to keep should be expired, and nothing else (in particular, not the v1
+		// image pushed in validateECRRepo, which does not match the tag prefix).
+		expectedExpiredTags := releaseTags[:len(releaseTags)-lifecycleTestImagesToKeep]
+		assert.ElementsMatch(t, expectedExpiredTags, expiredTags)
+	})
+}
+
+// waitForECRImageScan waits until the scan of the image with the given tag reaches a terminal state, and returns the
+// final scan status.
+func waitForECRImageScan(t *testing.T, client *ecr.ECR, repoName string, imageTag string) string {
+	return retry.DoWithRetry(
+		t,
+		fmt.Sprintf("wait for scan of image %s:%s", repoName, imageTag),
+		// Try for up to 10 minutes
+		60,
+		10*time.Second,
+		func() (string, error) {
+			output, err := client.DescribeImageScanFindings(&ecr.DescribeImageScanFindingsInput{
+				RepositoryName: awsgo.String(repoName),
+				ImageId:        &ecr.ImageIdentifier{ImageTag: awsgo.String(imageTag)},
+			})
+			if err != nil {
+				// The scan findings are not available until the scan has been started, which can take a few moments
+				// after the push.
+				return "", err
+			}
+			status := awsgo.StringValue(output.ImageScanStatus.Status)
+			if status == ecr.ScanStatusInProgress || status == ecr.ScanStatusPending {
+				return "", fmt.Errorf("Image scan is still %s", status)
+			}
+			if status == ecr.ScanStatusFailed {
+				return "", retry.FatalError{Underlying: fmt.Errorf("Image scan failed: %s", awsgo.StringValue(output.ImageScanStatus.Description))}
+			}
+			return status, nil
+		},
+	)
+}
+
+// getECRLifecyclePreviewExpiredTags runs a lifecycle policy preview on the given repo and returns all the image tags
+// that the policy would expire.
+func getECRLifecyclePreviewExpiredTags(t *testing.T, client *ecr.ECR, repoName string) []string {
+	_, err := client.StartLifecyclePolicyPreview(&ecr.StartLifecyclePolicyPreviewInput{
+		RepositoryName: awsgo.String(repoName),
+	})
+	require.NoError(t, err)
+
+	var previewResults []*ecr.LifecyclePolicyPreviewResult
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("wait for lifecycle policy preview of %s", repoName),
+		30,
+		10*time.Second,
+		func() (string, error) {
+			previewResults = []*ecr.LifecyclePolicyPreviewResult{}
+			var status string
+			err := client.GetLifecyclePolicyPreviewPages(
+				&ecr.GetLifecyclePolicyPreviewInput{RepositoryName: awsgo.String(repoName)},
+				func(page *ecr.GetLifecyclePolicyPreviewOutput, lastPage bool) bool {
+					status = awsgo.StringValue(page.Status)
+					previewResults = append(previewResults, page.PreviewResults...)
+					return true
+				},
+			)
+			if err != nil {
+				return "", err
+			}
+			if status == ecr.LifecyclePolicyPreviewStatusInProgress {
+				return "", fmt.Errorf("Lifecycle policy preview is still in progress")
+			}
+			if status != ecr.LifecyclePolicyPreviewStatusComplete {
+				return "", retry.FatalError{Underlying: fmt.Errorf("Lifecycle policy preview finished with status %s", status)}
+			}
+			return status, nil
+		},
+	)
+
+	expiredTags := []string{}
+	for _, result := range previewResults {
+		if result.Action != nil && awsgo.StringValue(result.Action.Type) == ecr.ImageActionTypeExpire {
+			expiredTags = append(expiredTags, awsgo.StringValueSlice(result.ImageTags)...)
+		}
+	}
+	return expiredTags
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/gruntwork-io/aws-service-catalog/test"
+	"github.com/gruntwork-io/aws-service-catalog/test/dbconfig"
 
 	"github.com/gruntwork-io/terratest/modules/aws"
 	"github.com/gruntwork-io/terratest/modules/random"
@@ -130,22 +131,17 @@ func createRDSTerraformOptions(
 	return terraformOptions
 }
 
+// getDbConfigJSON returns the JSON for the database configuration secret that the rds and aurora modules read via
+// db_config_secrets_manager_id. The config is validated against the schema the modules expect before it is returned.
 func getDbConfigJSON(t *testing.T, dbName, username, password, engine string) string {
-	type DbConfig struct {
-		Engine   string `json:"engine"`
-		Username string `json:"username"`
-		Password string `json:"password"`
-		Dbname   string `json:"dbname"`
-		Port     string `json:"port"`
-	}
-
-	config := DbConfig{
+	config := dbconfig.DbConfig{
 		Engine:   engine,
 		Username: username,
 		Password: password,
 		Dbname:   dbName,
-		Port:     "3306",
+		Port:     dbconfig.DefaultPortForEngine(engine),
 	}
+	require.NoError(t, config.Validate())
 
 	result, err := json.Marshal(config)
 	require.NoError(t, err)
@@ -1,23 +1,54 @@
 package data_stores
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"io"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gruntwork-io/aws-service-catalog/test"
 
 	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+const (
+	s3TestFilePath = "../fixtures/simple-docker-img/Dockerfile"
+	s3TestFileKey  = "config/Dockerfile"
+
+	s3LifecycleRuleID            = "TransitionConfig"
+	s3LifecycleTransitionDays    = 30
+	s3LifecycleTransitionStorage = "STANDARD_IA"
+	s3LifecycleNoncurrentExpDays = 90
+	s3LifecycleRulePrefix        = "config/"
+)
+
+var s3LifecycleRulesForTest = map[string]interface{}{
+	s3LifecycleRuleID: map[string]interface{}{
+		"prefix":  s3LifecycleRulePrefix,
+		"enabled": true,
+		"transition": map[string]interface{}{
+			"ToStandardIa": map[string]interface{}{
+				"days":          s3LifecycleTransitionDays,
+				"storage_class": s3LifecycleTransitionStorage,
+			},
+		},
+		"noncurrent_version_expiration": s3LifecycleNoncurrentExpDays,
+	},
+}
+
 func TestS3Bucket(t *testing.T) {
 	t.Parallel()
 
@@ -26,6 +57,9 @@ func TestS3Bucket(t *testing.T) {
 	//os.Setenv("SKIP_deploy_terraform", "true")
 	//os.Setenv("SKIP_validate_access_logs", "true")
 	//os.Setenv("SKIP_validate_replication", "true")
+	//os.Setenv("SKIP_validate_replica_object", "true")
+	//os.Setenv("SKIP_validate_secure_transport", "true")
+	//os.Setenv("SKIP_validate_bucket_settings", "true")
 	//os.Setenv("SKIP_cleanup", "true")
 
 	testFolder := "../../examples/for-learning-and-testing/data-stores/s3-bucket"
@@ -56,6 +90,10 @@ func TestS3Bucket(t *testing.T) {
 		terraformOptions.Vars["access_logging_bucket"] = "test-bucket-logs-" + uuid
 		terraformOptions.Vars["replica_bucket"] = "test-bucket-replica-" + uuid
 		terraformOptions.Vars["replica_aws_region"] = replicaRegion
+		// The lifecycle rules are only checked in long mode, so leave what short mode deploys as is.
+		if test.IsLongModeEnabled() {
+			terraformOptions.Vars["lifecycle_rules"] = s3LifecycleRulesForTest
+		}
 
 		test_structure.SaveTerraformOptions(t, testFolder, terraformOptions)
 		terraform.InitAndApply(t, terraformOptions)
@@ -80,8 +118,8 @@ func TestS3Bucket(t *testing.T) {
 	})
 
 	test_structure.RunTestStage(t, "validate_replication", func() {
-		testFilePath := "../fixtures/simple-docker-img/Dockerfile"
-		testFileKey := "config/Dockerfile"
+		testFilePath := s3TestFilePath
+		testFileKey := s3TestFileKey
 
 		terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
 		primaryBucket := terraform.OutputRequired(t, terraformOptions, "primary_bucket_name")
@@ -110,4 +148,138 @@ func TestS3Bucket(t *testing.T) {
 		require.NoError(t, err)
 		assert.Contains(t, []string{"PENDING", "COMPLETE"}, awsgo.StringValue(objectOutput.ReplicationStatus))
 	})
+
+	// The following stages wait for replication to finish and inspect the bucket configuration in more depth. These can
+	// take a long time, so they only run in long mode (see test.IsLongModeEnabled). PR runs use the default short mode,
+	// which stops at the checks above.
+	if !test.IsLongModeEnabled() {
+		return
+	}
+
+	test_structure.RunTestStage(t, "validate_replica_object", func() {
+		terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
+		primaryBucket := terraform.OutputRequired(t, terraformOptions, "primary_bucket_name")
+		replicaBucket := terraform.OutputRequired(t, terraformOptions, "replica_bucket_name")
+		replicaKMSKeyArn := terraform.OutputRequired(t, terraformOptions, "replica_kms_key_arn")
+		primaryRegion := test_structure.LoadString(t, testFolder, "primaryRegion")
+		replicaRegion := test_structure.LoadString(t, testFolder, "replicaRegion")
+
+		// Wait for the primary object to report that replication is complete, and then verify the replica matches.
+		primaryClient := aws.NewS3Client(t, primaryRegion)
+		retry.DoWithRetry(
+			t,
+			fmt.Sprintf("wait for replication of %s", s3TestFileKey),
+			// Try for up to 30 minutes
+			60,
+			30*time.Second,
+			func() (string, error) {
+				headOutput, err := primaryClient.HeadObject(&s3.HeadObjectInput{
+					Bucket: awsgo.String(primaryBucket),
+					Key:    awsgo.String(s3TestFileKey),
+				})
+				if err != nil {
+					return "", err
+				}
+				status := awsgo.StringValue(headOutput.ReplicationStatus)
+				if status == s3.ReplicationStatusFailed {
+					return "", retry.FatalError{Underlying: fmt.Errorf("Replication of %s failed", s3TestFileKey)}
+				}
+				if status != s3.ReplicationStatusComplete {
+					return "", fmt.Errorf("Replication status of %s is %s", s3TestFileKey, status)
+				}
+				return status, nil
+			},
+		)
+
+		replicaClient := aws.NewS3Client(t, replicaRegion)
+		replicaObject, err := replicaClient.GetObject(&s3.GetObjectInput{
+			Bucket: awsgo.String(replicaBucket),
+			Key:    awsgo.String(s3TestFileKey),
+		})
+		require.NoError(t, err)
+		defer replicaObject.Body.Close()
+
+		// The ETag of a KMS encrypted object is not an MD5 of the contents, so we compare checksums of the actual
+		// contents instead.
+		assert.Equal(t, s3.ReplicationStatusReplica, awsgo.StringValue(replicaObject.ReplicationStatus))
+		assert.Equal(t, sha256OfFile(t, s3TestFilePath), sha256OfReader(t, replicaObject.Body))
+		assert.Equal(t, s3.ServerSideEncryptionAwsKms, awsgo.StringValue(replicaObject.ServerSideEncryption))
+		assert.Equal(t, replicaKMSKeyArn, awsgo.StringValue(replicaObject.SSEKMSKeyId))
+	})
+
+	test_structure.RunTestStage(t, "validate_secure_transport", func() {
+		terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
+		primaryBucket := terraform.OutputRequired(t, terraformOptions, "primary_bucket_name")
+		primaryRegion := test_structure.LoadString(t, testFolder, "primaryRegion")
+
+		// We make an authenticated request with the same credentials that are granted read access by the bucket policy,
+		// but over plain HTTP. This should be denied by the statement in the bucket policy that requires TLS.
+		sess, err := aws.NewAuthenticatedSession(primaryRegion)
+		require.NoError(t, err)
+		insecureClient := s3.New(sess, awsgo.NewConfig().WithDisableSSL(true))
+		_, err = insecureClient.GetObject(&s3.GetObjectInput{
+			Bucket: awsgo.String(primaryBucket),
+			Key:    awsgo.String(s3TestFileKey),
+		})
+		require.Error(t, err)
+		awsErr, isAwsErr := err.(awserr.Error)
+		require.True(t, isAwsErr)
+		assert.Equal(t, "AccessDenied", awsErr.Code())
+	})
+
+	test_structure.RunTestStage(t, "validate_bucket_settings", func() {
+		terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
+		primaryBucket := terraform.OutputRequired(t, terraformOptions, "primary_bucket_name")
+		primaryRegion := test_structure.LoadString(t, testFolder, "primaryRegion")
+		primaryClient := aws.NewS3Client(t, primaryRegion)
+
+		// The example enables versioning by default.
+		versioningOutput, err := primaryClient.GetBucketVersioning(&s3.GetBucketVersioningInput{
+			Bucket: awsgo.String(primaryBucket),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, s3.BucketVersioningStatusEnabled, awsgo.StringValue(versioningOutput.Status))
+		assert.NotEqual(t, s3.MFADeleteStatusEnabled, awsgo.StringValue(versioningOutput.MFADelete))
+
+		// The module does not configure object lock, so there should not be an object lock configuration on the bucket.
+		_, err = primaryClient.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{
+			Bucket: awsgo.String(primaryBucket),
+		})
+		require.Error(t, err)
+		awsErr, isAwsErr := err.(awserr.Error)
+		require.True(t, isAwsErr)
+		assert.Equal(t, "ObjectLockConfigurationNotFoundError", awsErr.Code())
+
+		lifecycleOutput, err := primaryClient.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+			Bucket: awsgo.String(primaryBucket),
+		})
+		require.NoError(t, err)
+		var rule *s3.LifecycleRule
+		for _, r := range lifecycleOutput.Rules {
+			if awsgo.StringValue(r.ID) == s3LifecycleRuleID {
+				rule = r
+			}
+		}
+		require.NotNilf(t, rule, "Lifecycle rule %s not found on bucket %s", s3LifecycleRuleID, primaryBucket)
+		assert.Equal(t, s3.ExpirationStatusEnabled, awsgo.StringValue(rule.Status))
+		require.Equal(t, 1, len(rule.Transitions))
+		assert.Equal(t, int64(s3LifecycleTransitionDays), awsgo.Int64Value(rule.Transitions[0].Days))
+		assert.Equal(t, s3LifecycleTransitionStorage, awsgo.StringValue(rule.Transitions[0].StorageClass))
+		require.NotNil(t, rule.NoncurrentVersionExpiration)
+		assert.Equal(t, int64(s3LifecycleNoncurrentExpDays), awsgo.Int64Value(rule.NoncurrentVersionExpiration.NoncurrentDays))
+	})
+}
+
+func sha256OfFile(t *testing.T, path string) string {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	return sha256OfReader(t, file)
+}
+
+func sha256OfReader(t *testing.T, reader io.Reader) string {
+	hash := sha256.New()
+	_, err := io.Copy(hash, reader)
+	require.NoError(t, err)
+	return fmt.Sprintf("%x", hash.Sum(nil))
 }
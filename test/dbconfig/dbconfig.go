@@ -0,0 +1,211 @@
+// Package dbconfig defines the schema of the AWS Secrets Manager secret that the rds and aurora modules read database
+// configuration from (see the db_config_secrets_manager_id input variable of those modules), along with validation of
+// the values in that secret.
+package dbconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DbConfig represents the JSON structure of the database configuration secret. Note that the port is stored as a string,
+// as that is the format documented by AWS for database secrets.
+type DbConfig struct {
+	Engine   string `json:"engine"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Dbname   string `json:"dbname"`
+	Port     string `json:"port"`
+}
+
+// RequiredKeys is the list of keys that must be set in the database configuration secret.
+var RequiredKeys = []string{"engine", "username", "password", "dbname", "port"}
+
+// engineSpec describes the constraints that RDS imposes on the configuration for a given engine.
+type engineSpec struct {
+	defaultPort       int
+	maxPasswordLength int
+}
+
+// The minimum length of the master password for all engines.
+const minPasswordLength = 8
+
+// RDS rejects master passwords containing any of these characters.
+const forbiddenPasswordChars = `/"@ `
+
+// The range of ports that RDS allows a database to listen on.
+const (
+	minPort = 1150
+	maxPort = 65535
+)
+
+var engineSpecs = map[string]engineSpec{
+	"mysql":             {defaultPort: 3306, maxPasswordLength: 41},
+	"mariadb":           {defaultPort: 3306, maxPasswordLength: 41},
+	"aurora":            {defaultPort: 3306, maxPasswordLength: 41},
+	"aurora-mysql":      {defaultPort: 3306, maxPasswordLength: 41},
+	"postgres":          {defaultPort: 5432, maxPasswordLength: 128},
+	"aurora-postgresql": {defaultPort: 5432, maxPasswordLength: 99},
+	"oracle-ee":         {defaultPort: 1521, maxPasswordLength: 30},
+	"oracle-se2":        {defaultPort: 1521, maxPasswordLength: 30},
+	"sqlserver-ee":      {defaultPort: 1433, maxPasswordLength: 128},
+	"sqlserver-se":      {defaultPort: 1433, maxPasswordLength: 128},
+	"sqlserver-ex":      {defaultPort: 1433, maxPasswordLength: 128},
+	"sqlserver-web":     {defaultPort: 1433, maxPasswordLength: 128},
+}
+
+// DefaultPortForEngine returns the default port for the given engine as a string, in the format expected in the
+// secret. Returns an empty string if the engine is not supported.
+func DefaultPortForEngine(engine string) string {
+	spec, isSupported := engineSpecs[engine]
+	if !isSupported {
+		return ""
+	}
+	return strconv.Itoa(spec.defaultPort)
+}
+
+// Parse parses the given secret string into a DbConfig and validates it. For convenience, the port may be provided as
+// either a string or a number in the JSON.
+func Parse(secretString string) (*DbConfig, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(secretString), &raw); err != nil {
+		return nil, InvalidDbConfigErr{Problems: []string{fmt.Sprintf("secret is not a valid JSON object: %s", err)}}
+	}
+
+	problems := []string{}
+	values := map[string]string{}
+	for _, key := range RequiredKeys {
+		value, hasKey := raw[key]
+		if !hasKey {
+			problems = append(problems, fmt.Sprintf("missing required key %q", key))
+			continue
+		}
+		switch typedValue := value.(type) {
+		case string:
+			values[key] = typedValue
+		case float64:
+			if key != "port" {
+				problems = append(problems, fmt.Sprintf("key %q must be a string", key))
+				continue
+			}
+			values[key] = strconv.FormatFloat(typedValue, 'f', -1, 64)
+		default:
+			problems = append(problems, fmt.Sprintf("key %q must be a string", key))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, InvalidDbConfigErr{Problems: problems}
+	}
+
+	config := &DbConfig{
+		Engine:   values["engine"],
+		Username: values["username"],
+		Password: values["password"],
+		Dbname:   values["dbname"],
+		Port:     values["port"],
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate checks that all the required fields are set, that the port is consistent with the engine, and that the
+// password satisfies the RDS rules for the engine. All the problems found are reported in a single
+// InvalidDbConfigErr.
+func (config DbConfig) Validate() error {
+	problems := []string{}
+
+	fields := map[string]string{
+		"engine":   config.Engine,
+		"username": config.Username,
+		"password": config.Password,
+		"dbname":   config.Dbname,
+		"port":     config.Port,
+	}
+	for _, key := range RequiredKeys {
+		if strings.TrimSpace(fields[key]) == "" {
+			problems = append(problems, fmt.Sprintf("key %q must not be empty", key))
+		}
+	}
+
+	spec, isSupportedEngine := engineSpecs[config.Engine]
+	if config.Engine != "" && !isSupportedEngine {
+		problems = append(problems, fmt.Sprintf("engine %q is not supported. Must be one of: %s", config.Engine, strings.Join(supportedEngines(), ", ")))
+	}
+
+	if config.Port != "" {
+		problems = append(problems, validatePort(config.Engine, config.Port)...)
+	}
+
+	if config.Password != "" && isSupportedEngine {
+		problems = append(problems, validatePassword(config.Engine, spec, config.Password)...)
+	}
+
+	if len(problems) > 0 {
+		return InvalidDbConfigErr{Problems: problems}
+	}
+	return nil
+}
+
+// validatePort checks that the port is a number in the range allowed by RDS, and that it is not the default port of a
+// different engine, which almost always means the engine or port was copy pasted from another secret.
+func validatePort(engine string, port string) []string {
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return []string{fmt.Sprintf("port %q is not a number", port)}
+	}
+	if portNum < minPort || portNum > maxPort {
+		return []string{fmt.Sprintf("port %d must be between %d and %d", portNum, minPort, maxPort)}
+	}
+
+	spec, isSupportedEngine := engineSpecs[engine]
+	if !isSupportedEngine || portNum == spec.defaultPort {
+		return nil
+	}
+	for _, otherEngine := range supportedEngines() {
+		if engineSpecs[otherEngine].defaultPort == portNum {
+			return []string{fmt.Sprintf("port %d is the default port for %s, but engine is %s (default port %d)", portNum, otherEngine, engine, spec.defaultPort)}
+		}
+	}
+	return nil
+}
+
+func validatePassword(engine string, spec engineSpec, password string) []string {
+	problems := []string{}
+	if len(password) < minPasswordLength || len(password) > spec.maxPasswordLength {
+		problems = append(problems, fmt.Sprintf("password for engine %s must be between %d and %d characters long (got %d)", engine, minPasswordLength, spec.maxPasswordLength, len(password)))
+	}
+	if strings.ContainsAny(password, forbiddenPasswordChars) {
+		problems = append(problems, `password must not contain any of '/', '"', '@', or spaces`)
+	}
+	for _, char := range password {
+		if char < '!' || char > '~' {
+			problems = append(problems, "password must only contain printable ASCII characters")
+			break
+		}
+	}
+	return problems
+}
+
+func supportedEngines() []string {
+	engines := []string{}
+	for engine := range engineSpecs {
+		engines = append(engines, engine)
+	}
+	sort.Strings(engines)
+	return engines
+}
+
+// InvalidDbConfigErr is returned when the database configuration secret does not match the schema expected by the
+// modules.
+type InvalidDbConfigErr struct {
+	Problems []string
+}
+
+func (err InvalidDbConfigErr) Error() string {
+	return fmt.Sprintf("Invalid database configuration:\n- %s", strings.Join(err.Problems, "\n- "))
+}
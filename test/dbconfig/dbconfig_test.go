@@ -0,0 +1,117 @@
+package dbconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseValidConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		secretString string
+		expectedPort string
+	}{
+		{
+			"PortAsString",
+			`{"engine": "mysql", "username": "rds", "password": "abcdef-123456", "dbname": "rds", "port": "3306"}`,
+			"3306",
+		},
+		{
+			"PortAsNumber",
+			`{"engine": "postgres", "username": "rds", "password": "abcdef-123456", "dbname": "rds", "port": 5432}`,
+			"5432",
+		},
+		{
+			"CustomPort",
+			`{"engine": "aurora-mysql", "username": "rds", "password": "abcdef-123456", "dbname": "rds", "port": "3307"}`,
+			"3307",
+		},
+	}
+
+	for _, testCase := range testCases {
+		// Capture range variable so that it doesn't change while the subtests run in parallel.
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			config, err := Parse(testCase.secretString)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedPort, config.Port)
+		})
+	}
+}
+
+func TestParseInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		secretString    string
+		expectedProblem string
+	}{
+		{
+			"NotJSON",
+			`engine=mysql`,
+			"not a valid JSON object",
+		},
+		{
+			"MissingKey",
+			`{"engine": "mysql", "username": "rds", "password": "abcdef-123456", "port": "3306"}`,
+			`missing required key "dbname"`,
+		},
+		{
+			"EmptyValue",
+			`{"engine": "mysql", "username": "", "password": "abcdef-123456", "dbname": "rds", "port": "3306"}`,
+			`key "username" must not be empty`,
+		},
+		{
+			"UnsupportedEngine",
+			`{"engine": "mongodb", "username": "rds", "password": "abcdef-123456", "dbname": "rds", "port": "27017"}`,
+			`engine "mongodb" is not supported`,
+		},
+		{
+			"PortOfOtherEngine",
+			`{"engine": "postgres", "username": "rds", "password": "abcdef-123456", "dbname": "rds", "port": "3306"}`,
+			"port 3306 is the default port for",
+		},
+		{
+			"PortOutOfRange",
+			`{"engine": "mysql", "username": "rds", "password": "abcdef-123456", "dbname": "rds", "port": "80"}`,
+			"port 80 must be between",
+		},
+		{
+			"PasswordTooShort",
+			`{"engine": "mysql", "username": "rds", "password": "abc", "dbname": "rds", "port": "3306"}`,
+			"password for engine mysql must be between 8 and 41 characters long",
+		},
+		{
+			"PasswordTooLongForEngine",
+			`{"engine": "oracle-ee", "username": "rds", "password": "abcdefghijklmnopqrstuvwxyz-0123456789", "dbname": "rds", "port": "1521"}`,
+			"password for engine oracle-ee must be between 8 and 30 characters long",
+		},
+		{
+			"PasswordForbiddenChar",
+			`{"engine": "mysql", "username": "rds", "password": "abcdef@123456", "dbname": "rds", "port": "3306"}`,
+			"password must not contain",
+		},
+	}
+
+	for _, testCase := range testCases {
+		// Capture range variable so that it doesn't change while the subtests run in parallel.
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Parse(testCase.secretString)
+			require.Error(t, err)
+			assert.IsType(t, InvalidDbConfigErr{}, err)
+			assert.Contains(t, err.Error(), testCase.expectedProblem)
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// The prefix and header that the EKS authenticator expects on a bearer token. See
+	// https://github.com/kubernetes-sigs/aws-iam-authenticator#api-authorization-from-outside-a-cluster
+	eksTokenPrefix        = "k8s-aws-v1."
+	eksClusterIDHeader    = "x-k8s-aws-id"
+	eksPresignedURLExpiry = 60 * time.Second
+)
+
+// kubeConfig is the subset of the kubeconfig file format that we need to point kubectl at a single EKS cluster. We
+// write it out as JSON, which kubectl reads just like YAML.
+type kubeConfig struct {
+	APIVersion     string              `json:"apiVersion"`
+	Kind           string              `json:"kind"`
+	Clusters       []kubeConfigCluster `json:"clusters"`
+	Users          []kubeConfigUser    `json:"users"`
+	Contexts       []kubeConfigContext `json:"contexts"`
+	CurrentContext string              `json:"current-context"`
+}
+
+type kubeConfigCluster struct {
+	Name    string `json:"name"`
+	Cluster struct {
+		Server                   string `json:"server"`
+		CertificateAuthorityData []byte `json:"certificate-authority-data"`
+	} `json:"cluster"`
+}
+
+type kubeConfigUser struct {
+	Name string `json:"name"`
+	User struct {
+		Token string `json:"token"`
+	} `json:"user"`
+}
+
+type kubeConfigContext struct {
+	Name    string `json:"name"`
+	Context struct {
+		Cluster string `json:"cluster"`
+		User    string `json:"user"`
+	} `json:"context"`
+}
+
+// ConfigureKubectlForEKSCluster writes a kubeconfig for the EKS cluster with the given ARN to a temp file, and returns
+// kubectl options that use it. The temp file is removed when the test finishes.
+//
+// The kubeconfig embeds an EKS authentication token that is only valid for 15 minutes, so tests should call this again
+// in each stage that talks to the cluster, rather than saving and reusing the options.
+func ConfigureKubectlForEKSCluster(t *testing.T, eksClusterArn string) *k8s.KubectlOptions {
+	kubectlOptions, err := ConfigureKubectlForEKSClusterE(t, eksClusterArn)
+	require.NoError(t, err)
+	return kubectlOptions
+}
+
+// ConfigureKubectlForEKSClusterE writes a kubeconfig for the EKS cluster with the given ARN to a temp file, and returns
+// kubectl options that use it. The temp file is removed when the test finishes.
+func ConfigureKubectlForEKSClusterE(t *testing.T, eksClusterArn string) (*k8s.KubectlOptions, error) {
+	return configureKubectlForEKSClusterE(t, eksClusterArn, "")
+}
+
+// ConfigureKubectlForEKSClusterAsRole is like ConfigureKubectlForEKSCluster, but the kubeconfig authenticates to the
+// cluster as the IAM role with the given ARN, which is assumed with the current credentials. This is useful to check
+// what an IAM role that is mapped into the cluster can do.
+func ConfigureKubectlForEKSClusterAsRole(t *testing.T, eksClusterArn string, roleArn string) *k8s.KubectlOptions {
+	kubectlOptions, err := ConfigureKubectlForEKSClusterAsRoleE(t, eksClusterArn, roleArn)
+	require.NoError(t, err)
+	return kubectlOptions
+}
+
+// ConfigureKubectlForEKSClusterAsRoleE is like ConfigureKubectlForEKSClusterE, but the kubeconfig authenticates to the
+// cluster as the IAM role with the given ARN.
+func ConfigureKubectlForEKSClusterAsRoleE(t *testing.T, eksClusterArn string, roleArn string) (*k8s.KubectlOptions, error) {
+	return configureKubectlForEKSClusterE(t, eksClusterArn, roleArn)
+}
+
+// configureKubectlForEKSClusterE writes the kubeconfig for the EKS cluster with the given ARN. The cluster is looked up
+// with the current credentials, while the token authenticates as the IAM role with the given ARN, or as the current
+// credentials if roleArn is empty.
+func configureKubectlForEKSClusterE(t *testing.T, eksClusterArn string, roleArn string) (*k8s.KubectlOptions, error) {
+	awsRegion, clusterName, err := parseEKSClusterArn(eksClusterArn)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := aws.NewAuthenticatedSession(awsRegion)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := eks.New(sess).DescribeCluster(&eks.DescribeClusterInput{Name: awsgo.String(clusterName)})
+	if err != nil {
+		return nil, err
+	}
+	if output.Cluster.CertificateAuthority == nil {
+		return nil, fmt.Errorf("EKS cluster %s has no certificate authority data", eksClusterArn)
+	}
+	caData, err := base64.StdEncoding.DecodeString(awsgo.StringValue(output.Cluster.CertificateAuthority.Data))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid certificate authority data for EKS cluster %s: %s", eksClusterArn, err)
+	}
+
+	tokenSess := sess
+	if roleArn != "" {
+		tokenSess, err = aws.NewAuthenticatedSessionFromRole(awsRegion, roleArn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	token, err := generateEKSToken(tokenSess, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpKubeConfigFile, err := ioutil.TempFile("", "kubeconfig-")
+	if err != nil {
+		return nil, err
+	}
+	tmpKubeConfigFile.Close()
+	tmpKubeConfigPath := tmpKubeConfigFile.Name()
+	t.Cleanup(func() { os.Remove(tmpKubeConfigPath) })
+
+	config, err := json.MarshalIndent(newEKSKubeConfig(eksClusterArn, awsgo.StringValue(output.Cluster.Endpoint), caData, token), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(tmpKubeConfigPath, config, 0600); err != nil {
+		return nil, err
+	}
+	return k8s.NewKubectlOptions(eksClusterArn, tmpKubeConfigPath, ""), nil
+}
+
+// generateEKSToken generates a bearer token for the given EKS cluster, the same way `aws eks get-token` does: a
+// presigned STS GetCallerIdentity URL, bound to the cluster through a signed header, which the cluster exchanges for the
+// IAM identity of the caller.
+func generateEKSToken(sess *session.Session, clusterName string) (string, error) {
+	request, _ := sts.New(sess).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	request.HTTPRequest.Header.Add(eksClusterIDHeader, clusterName)
+	presignedURL, err := request.Presign(eksPresignedURLExpiry)
+	if err != nil {
+		return "", err
+	}
+	return eksTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(presignedURL)), nil
+}
+
+// newEKSKubeConfig returns a kubeconfig with a single context, named after the cluster ARN like kubergrunt and the AWS
+// CLI do, that authenticates to the cluster with the given token.
+func newEKSKubeConfig(eksClusterArn string, endpoint string, caData []byte, token string) kubeConfig {
+	cluster := kubeConfigCluster{Name: eksClusterArn}
+	cluster.Cluster.Server = endpoint
+	// encoding/json base64 encodes byte slices, which is the encoding kubeconfig expects for the CA data.
+	cluster.Cluster.CertificateAuthorityData = caData
+
+	user := kubeConfigUser{Name: eksClusterArn}
+	user.User.Token = token
+
+	context := kubeConfigContext{Name: eksClusterArn}
+	context.Context.Cluster = eksClusterArn
+	context.Context.User = eksClusterArn
+
+	return kubeConfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		Clusters:       []kubeConfigCluster{cluster},
+		Users:          []kubeConfigUser{user},
+		Contexts:       []kubeConfigContext{context},
+		CurrentContext: eksClusterArn,
+	}
+}
+
+// parseEKSClusterArn returns the region and name of the EKS cluster with the given ARN, which has the form
+// arn:aws:eks:<region>:<account>:cluster/<name>.
+func parseEKSClusterArn(eksClusterArn string) (string, string, error) {
+	parsed, err := arn.Parse(eksClusterArn)
+	if err != nil {
+		return "", "", err
+	}
+	if parsed.Service != "eks" || !strings.HasPrefix(parsed.Resource, "cluster/") {
+		return "", "", fmt.Errorf("%s is not the ARN of an EKS cluster", eksClusterArn)
+	}
+	return parsed.Region, strings.TrimPrefix(parsed.Resource, "cluster/"), nil
+}
@@ -8,7 +8,9 @@ require (
 	github.com/gruntwork-io/go-commons v0.11.0
 	github.com/gruntwork-io/module-ci/test/edrhelpers v0.0.0-20220304223529-26f4f52e03fb
 	github.com/gruntwork-io/terratest v0.40.6
+	github.com/hashicorp/terraform-json v0.13.0
 	github.com/mattn/go-zglob v0.0.3
+	github.com/miekg/dns v1.1.31
 	github.com/stretchr/testify v1.7.0
 	k8s.io/api v0.20.6
 	k8s.io/apimachinery v0.20.6
@@ -0,0 +1,193 @@
+// Package localdb runs data stores (MySQL, Postgres, Redis, and Memcached) locally in docker containers, so that the
+// data store test helpers can be exercised without deploying anything to AWS. Each container is bound to a random port
+// on localhost, and is automatically stopped and removed when the test that started it finishes.
+package localdb
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/gruntwork-io/terratest/modules/docker"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/stretchr/testify/require"
+)
+
+// Docker images used for the local data stores. These track the engine versions that the examples deploy.
+const (
+	MySQLImage     = "mysql:8.0"
+	PostgresImage  = "postgres:13"
+	RedisImage     = "redis:6.2"
+	MemcachedImage = "memcached:1.6"
+)
+
+const (
+	localhost = "127.0.0.1"
+
+	// Some of the images (especially MySQL) take a while to initialize the database on first boot.
+	readyRetries            = 60
+	sleepBetweenReadyChecks = 2 * time.Second
+)
+
+// ConnectionInfo contains the information needed to connect to a local data store. It has the same fields as RDSInfo
+// in the data-stores tests, so it can be converted directly with data_stores.RDSInfo(info). For Redis and Memcached,
+// only DBEndpoint and DBPort are set.
+type ConnectionInfo struct {
+	Username   string
+	Password   string
+	DBName     string
+	DBEndpoint string
+	DBPort     string
+}
+
+// StartMySQL runs a MySQL container and waits until it accepts connections with the returned credentials.
+func StartMySQL(t *testing.T) ConnectionInfo {
+	info := ConnectionInfo{
+		Username: "localdb",
+		Password: newPassword(),
+		DBName:   "localdb",
+	}
+	env := []string{
+		"MYSQL_RANDOM_ROOT_PASSWORD=yes",
+		"MYSQL_USER=" + info.Username,
+		"MYSQL_PASSWORD=" + info.Password,
+		"MYSQL_DATABASE=" + info.DBName,
+	}
+	_, info.DBEndpoint, info.DBPort = startContainer(t, MySQLImage, 3306, env)
+
+	waitUntilReady(t, "MySQL", func() error {
+		dbConnString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", info.Username, info.Password, info.DBEndpoint, info.DBPort, info.DBName)
+		db, err := sql.Open("mysql", dbConnString)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return db.Ping()
+	})
+	return info
+}
+
+// StartPostgres runs a Postgres container and waits until it accepts connections with the returned credentials.
+func StartPostgres(t *testing.T) ConnectionInfo {
+	info := ConnectionInfo{
+		Username: "localdb",
+		Password: newPassword(),
+		DBName:   "localdb",
+	}
+	env := []string{
+		"POSTGRES_USER=" + info.Username,
+		"POSTGRES_PASSWORD=" + info.Password,
+		"POSTGRES_DB=" + info.DBName,
+	}
+	containerID, endpoint, port := startContainer(t, PostgresImage, 5432, env)
+	info.DBEndpoint = endpoint
+	info.DBPort = port
+
+	// There is no Postgres driver in this module, so we use pg_isready in the container. We connect over TCP, as the
+	// image runs a temporary server that only listens on the unix socket while it initializes the database.
+	waitUntilReady(t, "Postgres", func() error {
+		cmd := shell.Command{
+			Command: "docker",
+			Args:    []string{"exec", containerID, "pg_isready", "-h", localhost, "-U", info.Username, "-d", info.DBName},
+		}
+		_, err := shell.RunCommandAndGetOutputE(t, cmd)
+		return err
+	})
+	return info
+}
+
+// StartRedis runs a Redis container and waits until it responds to PING.
+func StartRedis(t *testing.T) ConnectionInfo {
+	_, endpoint, port := startContainer(t, RedisImage, 6379, nil)
+	info := ConnectionInfo{DBEndpoint: endpoint, DBPort: port}
+
+	waitUntilReady(t, "Redis", func() error {
+		return expectResponse(info, "PING\r\n", "+PONG")
+	})
+	return info
+}
+
+// StartMemcached runs a Memcached container and waits until it responds to the version command.
+func StartMemcached(t *testing.T) ConnectionInfo {
+	_, endpoint, port := startContainer(t, MemcachedImage, 11211, nil)
+	info := ConnectionInfo{DBEndpoint: endpoint, DBPort: port}
+
+	waitUntilReady(t, "Memcached", func() error {
+		return expectResponse(info, "version\r\n", "VERSION ")
+	})
+	return info
+}
+
+// startContainer runs the given image in the background, publishing the container port on a random port on localhost.
+// The container is stopped (and thus removed) when the test finishes. Returns the container ID, and the host and port
+// to connect to.
+func startContainer(t *testing.T, image string, containerPort uint16, env []string) (string, string, string) {
+	runOpts := &docker.RunOptions{
+		Detach:               true,
+		Remove:               true,
+		Name:                 fmt.Sprintf("localdb-%s", strings.ToLower(random.UniqueId())),
+		EnvironmentVariables: env,
+		OtherOptions:         []string{"--publish", fmt.Sprintf("%s::%d", localhost, containerPort)},
+	}
+	containerID := docker.RunAndGetID(t, image, runOpts)
+	t.Cleanup(func() {
+		docker.Stop(t, []string{containerID}, &docker.StopOptions{})
+	})
+
+	hostPort := docker.Inspect(t, containerID).GetExposedHostPort(containerPort)
+	require.NotZerof(t, hostPort, "Port %d of container %s was not published", containerPort, containerID)
+	return containerID, localhost, strconv.Itoa(int(hostPort))
+}
+
+func waitUntilReady(t *testing.T, name string, isReady func() error) {
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("wait for local %s to be ready", name),
+		readyRetries,
+		sleepBetweenReadyChecks,
+		func() (string, error) {
+			if err := isReady(); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s is ready", name), nil
+		},
+	)
+}
+
+// expectResponse sends the given command to the data store over a plain TCP connection, and checks that the first line
+// of the response starts with the expected prefix. This is enough to check the Redis and Memcached text protocols
+// without pulling in a client library.
+func expectResponse(info ConnectionInfo, command string, expectedPrefix string) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(info.DBEndpoint, info.DBPort), 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return err
+	}
+
+	if _, err := conn.Write([]byte(command)); err != nil {
+		return err
+	}
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(response, expectedPrefix) {
+		return fmt.Errorf("Expected response starting with %q, but got %q", expectedPrefix, response)
+	}
+	return nil
+}
+
+func newPassword() string {
+	return fmt.Sprintf("%s-%s", random.UniqueId(), random.UniqueId())
+}
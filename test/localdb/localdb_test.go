@@ -0,0 +1,48 @@
+package localdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Each of the Start functions waits until the data store is ready to serve requests, so these tests only need to check
+// that the returned connection info is populated.
+
+func TestStartMySQL(t *testing.T) {
+	t.Parallel()
+
+	info := StartMySQL(t)
+	assert.NotEmpty(t, info.Username)
+	assert.NotEmpty(t, info.Password)
+	assert.NotEmpty(t, info.DBName)
+	assert.Equal(t, localhost, info.DBEndpoint)
+	assert.NotEmpty(t, info.DBPort)
+}
+
+func TestStartPostgres(t *testing.T) {
+	t.Parallel()
+
+	info := StartPostgres(t)
+	assert.NotEmpty(t, info.Username)
+	assert.NotEmpty(t, info.Password)
+	assert.NotEmpty(t, info.DBName)
+	assert.Equal(t, localhost, info.DBEndpoint)
+	assert.NotEmpty(t, info.DBPort)
+}
+
+func TestStartRedis(t *testing.T) {
+	t.Parallel()
+
+	info := StartRedis(t)
+	assert.Equal(t, localhost, info.DBEndpoint)
+	assert.NoError(t, expectResponse(info, "SET localdb hello\r\n", "+OK"))
+}
+
+func TestStartMemcached(t *testing.T) {
+	t.Parallel()
+
+	info := StartMemcached(t)
+	assert.Equal(t, localhost, info.DBEndpoint)
+	assert.NoError(t, expectResponse(info, "set localdb 0 0 5\r\nhello\r\n", "STORED"))
+}
@@ -0,0 +1,560 @@
+// Package localdns serves the records of Route 53 hosted zones from a DNS server on localhost. The records are read
+// straight from the Route 53 API, so tests can check the contents of records as soon as they are created, instead of
+// waiting for them to propagate through public DNS resolvers (which is slow, and often the flakiest part of a test
+// run). The server can be queried with the terratest dns_helper functions by passing Server.Address() as the resolver.
+package localdns
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	dns_helper "github.com/gruntwork-io/terratest/modules/dns-helper"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	localhost = "127.0.0.1"
+
+	// Route 53 is a global service, so any region works for the API client.
+	route53Region = "us-east-1"
+
+	// The TTL of the records served for alias record sets, which have no TTL of their own in Route 53.
+	aliasTTL = 60
+
+	// How many CNAMEs to follow when answering a query, to guard against CNAME loops.
+	maxCNAMEChain = 8
+)
+
+// Server is a DNS server on localhost that answers queries with the records of a set of Route 53 hosted zones.
+type Server struct {
+	address string
+	server  *dns.Server
+
+	loadRecordSets func() ([]*route53.ResourceRecordSet, error)
+	resolveAlias   func(name string) ([]net.IP, error)
+
+	mutex   sync.RWMutex
+	records map[string][]dns.RR
+	names   map[string]bool
+}
+
+// StartServer reads the records of the given Route 53 hosted zones, and starts a DNS server on localhost that serves
+// them. The server is stopped when the test completes. Call Refresh to pick up records that change after the server
+// starts.
+func StartServer(t *testing.T, hostedZoneIDs ...string) *Server {
+	server, err := StartServerE(t, hostedZoneIDs...)
+	require.NoError(t, err)
+	return server
+}
+
+// StartServerE is like StartServer, but returns an error instead of failing the test.
+func StartServerE(t *testing.T, hostedZoneIDs ...string) (*Server, error) {
+	server := newServer(
+		func() ([]*route53.ResourceRecordSet, error) { return listRecordSets(hostedZoneIDs) },
+		lookupIP,
+	)
+	if err := server.start(t); err != nil {
+		return nil, err
+	}
+	logger.Logf(t, "Serving the records of hosted zones %v from local DNS server %s", hostedZoneIDs, server.address)
+	return server, nil
+}
+
+func newServer(loadRecordSets func() ([]*route53.ResourceRecordSet, error), resolveAlias func(name string) ([]net.IP, error)) *Server {
+	return &Server{
+		loadRecordSets: loadRecordSets,
+		resolveAlias:   resolveAlias,
+		records:        map[string][]dns.RR{},
+		names:          map[string]bool{},
+	}
+}
+
+func (s *Server) start(t *testing.T) error {
+	if err := s.RefreshE(); err != nil {
+		return err
+	}
+
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(localhost, "0"))
+	if err != nil {
+		return err
+	}
+	started := make(chan struct{})
+	s.server = &dns.Server{
+		PacketConn:        conn,
+		Handler:           dns.HandlerFunc(s.serveDNS),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	serveErrs := make(chan error, 1)
+	go func() { serveErrs <- s.server.ActivateAndServe() }()
+	select {
+	case <-started:
+	case err := <-serveErrs:
+		return err
+	}
+
+	s.address = conn.LocalAddr().String()
+	t.Cleanup(func() { s.server.Shutdown() })
+	return nil
+}
+
+// Address returns the address (host:port) of the server, for use as a resolver with the dns_helper functions.
+func (s *Server) Address() string {
+	return s.address
+}
+
+// Refresh reads the records from Route 53 again, and replaces the records the server answers with.
+func (s *Server) Refresh(t *testing.T) {
+	require.NoError(t, s.RefreshE())
+}
+
+// RefreshE is like Refresh, but returns an error instead of failing the test.
+func (s *Server) RefreshE() error {
+	recordSets, err := s.loadRecordSets()
+	if err != nil {
+		return err
+	}
+	records, err := buildRecords(recordSets, s.resolveAlias)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, rrs := range records {
+		for _, rr := range rrs {
+			names[rr.Header().Name] = true
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records = records
+	s.names = names
+	return nil
+}
+
+// LookupWithRetry refreshes the records from Route 53 and looks up the query through the server, until it gets a
+// non-empty answer or runs out of retries.
+func (s *Server) LookupWithRetry(t *testing.T, query dns_helper.DNSQuery, maxRetries int, sleepBetweenRetries time.Duration) dns_helper.DNSAnswers {
+	answers, err := retry.DoWithRetryInterfaceE(
+		t,
+		fmt.Sprintf("Look up %s record for %s in local DNS server", query.Type, query.Name),
+		maxRetries,
+		sleepBetweenRetries,
+		func() (interface{}, error) {
+			if err := s.RefreshE(); err != nil {
+				return nil, err
+			}
+			return dns_helper.DNSLookupE(t, query, []string{s.address})
+		},
+	)
+	require.NoError(t, err)
+	return answers.(dns_helper.DNSAnswers)
+}
+
+// LookupWithValidationRetry refreshes the records from Route 53 and looks up the query through the server, until the
+// answers match the expected answers or it runs out of retries.
+func (s *Server) LookupWithValidationRetry(t *testing.T, query dns_helper.DNSQuery, expectedAnswers dns_helper.DNSAnswers, maxRetries int, sleepBetweenRetries time.Duration) {
+	expectedAnswers.Sort()
+	_, err := retry.DoWithRetryE(
+		t,
+		fmt.Sprintf("Validate %s record for %s in local DNS server", query.Type, query.Name),
+		maxRetries,
+		sleepBetweenRetries,
+		func() (string, error) {
+			if err := s.RefreshE(); err != nil {
+				return "", err
+			}
+			answers, err := dns_helper.DNSLookupE(t, query, []string{s.address})
+			if err != nil {
+				return "", err
+			}
+			if !reflect.DeepEqual(answers, expectedAnswers) {
+				return "", &dns_helper.ValidationError{Query: query, Answers: answers, ExpectedAnswers: expectedAnswers}
+			}
+			return "", nil
+		},
+	)
+	require.NoError(t, err)
+}
+
+// DialContext dials the given address like net.Dialer does, but resolves host names through the records of the server
+// instead of the system resolver. Addresses that are already IPs are dialed as is.
+func (s *Server) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil {
+		s.mutex.RLock()
+		answers, _ := s.lookup(host, dns.TypeA)
+		s.mutex.RUnlock()
+
+		var ip net.IP
+		for _, answer := range answers {
+			if a, isA := answer.(*dns.A); isA {
+				ip = a.A
+				break
+			}
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("No A record for %s in local DNS server %s", host, s.address)
+		}
+		host = ip.String()
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, net.JoinHostPort(host, port))
+}
+
+// HTTPClient returns an HTTP client that resolves host names through the records of the server. TLS still verifies
+// the certificate against the host name in the URL.
+func (s *Server) HTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:       http.ProxyFromEnvironment,
+			DialContext: s.DialContext,
+		},
+	}
+}
+
+// HTTPGetWithRetryWithCustomValidation performs an HTTP GET on the given URL, resolving host names through the records
+// of the server, until the validate function returns true for the status code and body, or it runs out of retries.
+// Records are refreshed from Route 53 before each attempt.
+func (s *Server) HTTPGetWithRetryWithCustomValidation(t *testing.T, url string, maxRetries int, sleepBetweenRetries time.Duration, validate func(statusCode int, body string) bool) {
+	client := s.HTTPClient()
+	_, err := retry.DoWithRetryE(
+		t,
+		fmt.Sprintf("HTTP GET to URL %s through local DNS server", url),
+		maxRetries,
+		sleepBetweenRetries,
+		func() (string, error) {
+			if err := s.RefreshE(); err != nil {
+				return "", err
+			}
+			response, err := client.Get(url)
+			if err != nil {
+				return "", err
+			}
+			defer response.Body.Close()
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				return "", err
+			}
+			if !validate(response.StatusCode, string(body)) {
+				return "", fmt.Errorf("Validation failed for URL %s. Response status: %d. Response body:\n%s", url, response.StatusCode, body)
+			}
+			return "", nil
+		},
+	)
+	require.NoError(t, err)
+}
+
+// FindPublicHostedZoneID returns the ID of the public hosted zone for the given domain name that has all the given
+// tags. The tags are used to pick between multiple hosted zones with the same name (e.g., see
+// test.DomainNameTagsForTest).
+func FindPublicHostedZoneID(t *testing.T, domainName string, tags map[string]interface{}) string {
+	hostedZoneID, err := FindPublicHostedZoneIDE(domainName, tags)
+	require.NoError(t, err)
+	return hostedZoneID
+}
+
+// FindPublicHostedZoneIDE is like FindPublicHostedZoneID, but returns an error instead of failing the test.
+func FindPublicHostedZoneIDE(domainName string, tags map[string]interface{}) (string, error) {
+	client, err := newRoute53Client()
+	if err != nil {
+		return "", err
+	}
+
+	fqdn := normalizeName(domainName)
+	input := &route53.ListHostedZonesByNameInput{DNSName: awsgo.String(fqdn)}
+	for {
+		output, err := client.ListHostedZonesByName(input)
+		if err != nil {
+			return "", err
+		}
+		for _, zone := range output.HostedZones {
+			if normalizeName(awsgo.StringValue(zone.Name)) != fqdn {
+				// Zones are sorted by name, so there are no more matches.
+				return "", fmt.Errorf("No public hosted zone for %s with tags %v", domainName, tags)
+			}
+			if zone.Config != nil && awsgo.BoolValue(zone.Config.PrivateZone) {
+				continue
+			}
+			zoneID := strings.TrimPrefix(awsgo.StringValue(zone.Id), "/hostedzone/")
+			hasTags, err := hostedZoneHasTags(client, zoneID, tags)
+			if err != nil {
+				return "", err
+			}
+			if hasTags {
+				return zoneID, nil
+			}
+		}
+		if !awsgo.BoolValue(output.IsTruncated) {
+			return "", fmt.Errorf("No public hosted zone for %s with tags %v", domainName, tags)
+		}
+		input.DNSName = output.NextDNSName
+		input.HostedZoneId = output.NextHostedZoneId
+	}
+}
+
+func hostedZoneHasTags(client *route53.Route53, zoneID string, tags map[string]interface{}) (bool, error) {
+	if len(tags) == 0 {
+		return true, nil
+	}
+	output, err := client.ListTagsForResource(&route53.ListTagsForResourceInput{
+		ResourceType: awsgo.String(route53.TagResourceTypeHostedzone),
+		ResourceId:   awsgo.String(zoneID),
+	})
+	if err != nil {
+		return false, err
+	}
+	zoneTags := map[string]string{}
+	for _, tag := range output.ResourceTagSet.Tags {
+		zoneTags[awsgo.StringValue(tag.Key)] = awsgo.StringValue(tag.Value)
+	}
+	for key, value := range tags {
+		if zoneValue, hasKey := zoneTags[key]; !hasKey || zoneValue != fmt.Sprint(value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func newRoute53Client() (*route53.Route53, error) {
+	sess, err := aws.NewAuthenticatedSession(route53Region)
+	if err != nil {
+		return nil, err
+	}
+	return route53.New(sess), nil
+}
+
+func listRecordSets(hostedZoneIDs []string) ([]*route53.ResourceRecordSet, error) {
+	client, err := newRoute53Client()
+	if err != nil {
+		return nil, err
+	}
+
+	recordSets := []*route53.ResourceRecordSet{}
+	for _, hostedZoneID := range hostedZoneIDs {
+		input := &route53.ListResourceRecordSetsInput{HostedZoneId: awsgo.String(hostedZoneID)}
+		err := client.ListResourceRecordSetsPages(input, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+			recordSets = append(recordSets, page.ResourceRecordSets...)
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list records of hosted zone %s: %s", hostedZoneID, err)
+		}
+	}
+	return recordSets, nil
+}
+
+// lookupIP resolves alias targets outside of the hosted zones (e.g., the DNS name of a load balancer). These are AWS
+// managed names that resolve as soon as the target exists, so they don't have the propagation delays of our records.
+func lookupIP(name string) ([]net.IP, error) {
+	addresses, err := net.DefaultResolver.LookupIPAddr(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+	ips := []net.IP{}
+	for _, address := range addresses {
+		ips = append(ips, address.IP)
+	}
+	return ips, nil
+}
+
+// buildRecords converts Route 53 record sets into DNS records, keyed by recordKey. Alias record sets are converted into
+// records of their own type, with the data of the records they point to.
+func buildRecords(recordSets []*route53.ResourceRecordSet, resolveAlias func(name string) ([]net.IP, error)) (map[string][]dns.RR, error) {
+	records := map[string][]dns.RR{}
+	aliases := []*route53.ResourceRecordSet{}
+
+	for _, recordSet := range recordSets {
+		if recordSet.AliasTarget != nil {
+			aliases = append(aliases, recordSet)
+			continue
+		}
+		name := normalizeName(awsgo.StringValue(recordSet.Name))
+		recordType := awsgo.StringValue(recordSet.Type)
+		for _, value := range recordSet.ResourceRecords {
+			rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, awsgo.Int64Value(recordSet.TTL), recordType, awsgo.StringValue(value.Value)))
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse %s record for %s: %s", recordType, name, err)
+			}
+			key := recordKey(name, rr.Header().Rrtype)
+			records[key] = append(records[key], rr)
+		}
+	}
+
+	// Aliases can point to other aliases in the same zones, so keep going until all the aliases that point to records
+	// we know of are resolved. Whatever is left points outside of the zones.
+	for len(aliases) > 0 {
+		pending := []*route53.ResourceRecordSet{}
+		for _, alias := range aliases {
+			if !addLocalAlias(records, alias) {
+				pending = append(pending, alias)
+			}
+		}
+		if len(pending) == len(aliases) {
+			break
+		}
+		aliases = pending
+	}
+	for _, alias := range aliases {
+		if err := addExternalAlias(records, alias, resolveAlias); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// addLocalAlias adds the records for an alias record set that points to records in the same zones. Returns false if
+// there are no such records (yet).
+func addLocalAlias(records map[string][]dns.RR, alias *route53.ResourceRecordSet) bool {
+	name := normalizeName(awsgo.StringValue(alias.Name))
+	rrType := dns.StringToType[awsgo.StringValue(alias.Type)]
+	targetRecords, hasTarget := records[recordKey(normalizeName(awsgo.StringValue(alias.AliasTarget.DNSName)), rrType)]
+	if !hasTarget {
+		return false
+	}
+
+	key := recordKey(name, rrType)
+	for _, target := range targetRecords {
+		rr := dns.Copy(target)
+		rr.Header().Name = name
+		rr.Header().Ttl = aliasTTL
+		records[key] = append(records[key], rr)
+	}
+	return true
+}
+
+// addExternalAlias adds the records for an alias record set that points outside of the zones, by resolving the target.
+func addExternalAlias(records map[string][]dns.RR, alias *route53.ResourceRecordSet, resolveAlias func(name string) ([]net.IP, error)) error {
+	name := normalizeName(awsgo.StringValue(alias.Name))
+	recordType := awsgo.StringValue(alias.Type)
+	target := normalizeName(awsgo.StringValue(alias.AliasTarget.DNSName))
+	if recordType != "A" && recordType != "AAAA" {
+		return fmt.Errorf("Unsupported alias record type %s for %s (target %s)", recordType, name, target)
+	}
+
+	ips, err := resolveAlias(target)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve alias target %s of %s: %s", target, name, err)
+	}
+	for _, ip := range ips {
+		isIPv4 := ip.To4() != nil
+		if isIPv4 != (recordType == "A") {
+			continue
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, aliasTTL, recordType, ip))
+		if err != nil {
+			return err
+		}
+		key := recordKey(name, rr.Header().Rrtype)
+		records[key] = append(records[key], rr)
+	}
+	return nil
+}
+
+func (s *Server) serveDNS(writer dns.ResponseWriter, request *dns.Msg) {
+	response := new(dns.Msg)
+	response.SetReply(request)
+	response.Authoritative = true
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, question := range request.Question {
+		answers, found := s.lookup(question.Name, question.Qtype)
+		if !found {
+			response.Rcode = dns.RcodeNameError
+		}
+		response.Answer = append(response.Answer, answers...)
+	}
+	writer.WriteMsg(response)
+}
+
+// lookup returns the records of the given type for the given name, following CNAMEs and matching wildcard records.
+// Returns false if there are no records of any type for the name. The caller must hold the read lock.
+func (s *Server) lookup(name string, rrType uint16) ([]dns.RR, bool) {
+	answers := []dns.RR{}
+	name = normalizeName(name)
+	for i := 0; i < maxCNAMEChain; i++ {
+		recordName, found := s.matchName(name)
+		if !found {
+			return answers, i > 0
+		}
+
+		if rrs, hasRecords := s.records[recordKey(recordName, rrType)]; hasRecords {
+			return append(answers, renameRecords(rrs, name)...), true
+		}
+		cnames, hasCNAME := s.records[recordKey(recordName, dns.TypeCNAME)]
+		if !hasCNAME || rrType == dns.TypeCNAME {
+			return answers, true
+		}
+		answers = append(answers, renameRecords(cnames, name)...)
+		name = normalizeName(cnames[0].(*dns.CNAME).Target)
+	}
+	return answers, true
+}
+
+// matchName returns the name that holds the records for the given name: either the name itself, or the closest
+// wildcard name that covers it.
+func (s *Server) matchName(name string) (string, bool) {
+	if s.names[name] {
+		return name, true
+	}
+	labels := dns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		wildcard := "*." + dns.Fqdn(strings.Join(labels[i:], "."))
+		if s.names[wildcard] {
+			return wildcard, true
+		}
+	}
+	return "", false
+}
+
+func renameRecords(rrs []dns.RR, name string) []dns.RR {
+	renamed := []dns.RR{}
+	for _, rr := range rrs {
+		copied := dns.Copy(rr)
+		copied.Header().Name = name
+		renamed = append(renamed, copied)
+	}
+	return renamed
+}
+
+func recordKey(name string, rrType uint16) string {
+	return name + "/" + dns.TypeToString[rrType]
+}
+
+// normalizeName lower cases the given domain name and makes it fully qualified. It also unescapes the octal escape
+// codes that Route 53 uses for special characters in names (e.g., \052 for the * in wildcard records).
+func normalizeName(name string) string {
+	var unescaped strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+3 < len(name) {
+			if code, err := strconv.ParseUint(name[i+1:i+4], 8, 8); err == nil {
+				unescaped.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		unescaped.WriteByte(name[i])
+	}
+	return strings.ToLower(dns.Fqdn(unescaped.String()))
+}
@@ -0,0 +1,117 @@
+package localdns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	dns_helper "github.com/gruntwork-io/terratest/modules/dns-helper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	recordSets := []*route53.ResourceRecordSet{
+		recordSet("example.com.", "NS", "ns-1.awsdns-01.org.", "ns-2.awsdns-02.net."),
+		recordSet("www.example.com.", "A", "10.0.0.1", "10.0.0.2"),
+		recordSet("sub.example.com.", "TXT", `"hello-world"`),
+		recordSet("app.example.com.", "CNAME", "www.example.com"),
+		recordSet(`\052.apps.example.com.`, "A", "10.0.0.3"),
+		aliasRecordSet("Alias.Example.com.", "A", "www.example.com."),
+		aliasRecordSet("alb.example.com.", "A", "my-alb-1234.us-east-1.elb.amazonaws.com."),
+		aliasRecordSet("alb.example.com.", "AAAA", "my-alb-1234.us-east-1.elb.amazonaws.com."),
+	}
+	resolveAlias := func(name string) ([]net.IP, error) {
+		if name != "my-alb-1234.us-east-1.elb.amazonaws.com." {
+			return nil, fmt.Errorf("Unexpected alias target %s", name)
+		}
+		return []net.IP{net.ParseIP("203.0.113.10"), net.ParseIP("2001:db8::10")}, nil
+	}
+	server := newServer(func() ([]*route53.ResourceRecordSet, error) { return recordSets, nil }, resolveAlias)
+	require.NoError(t, server.start(t))
+
+	testCases := []struct {
+		queryType string
+		name      string
+		expected  dns_helper.DNSAnswers
+	}{
+		{"A", "www.example.com", dns_helper.DNSAnswers{{Type: "A", Value: "10.0.0.1"}, {Type: "A", Value: "10.0.0.2"}}},
+		{"NS", "example.com", dns_helper.DNSAnswers{{Type: "NS", Value: "ns-1.awsdns-01.org."}, {Type: "NS", Value: "ns-2.awsdns-02.net."}}},
+		{"TXT", "SUB.example.com", dns_helper.DNSAnswers{{Type: "TXT", Value: `"hello-world"`}}},
+		{"A", "app.example.com", dns_helper.DNSAnswers{{Type: "CNAME", Value: "www.example.com."}, {Type: "A", Value: "10.0.0.1"}, {Type: "A", Value: "10.0.0.2"}}},
+		{"A", "foo.apps.example.com", dns_helper.DNSAnswers{{Type: "A", Value: "10.0.0.3"}}},
+		{"A", "alias.example.com", dns_helper.DNSAnswers{{Type: "A", Value: "10.0.0.1"}, {Type: "A", Value: "10.0.0.2"}}},
+		{"A", "alb.example.com", dns_helper.DNSAnswers{{Type: "A", Value: "203.0.113.10"}}},
+		{"AAAA", "alb.example.com", dns_helper.DNSAnswers{{Type: "AAAA", Value: "2001:db8::10"}}},
+	}
+	for _, testCase := range testCases {
+		answers, err := dns_helper.DNSLookupE(t, dns_helper.DNSQuery{Type: testCase.queryType, Name: testCase.name}, []string{server.Address()})
+		require.NoError(t, err, testCase.name)
+		assert.ElementsMatch(t, testCase.expected, answers, testCase.name)
+	}
+
+	_, err := dns_helper.DNSLookupE(t, dns_helper.DNSQuery{Type: "A", Name: "missing.example.com"}, []string{server.Address()})
+	assert.IsType(t, &dns_helper.NotFoundError{}, err)
+
+	// Records added after the server starts are only served after a refresh.
+	recordSets = append(recordSets, recordSet("new.example.com.", "A", "10.0.0.4"))
+	_, err = dns_helper.DNSLookupE(t, dns_helper.DNSQuery{Type: "A", Name: "new.example.com"}, []string{server.Address()})
+	assert.Error(t, err)
+	server.Refresh(t)
+	answers, err := dns_helper.DNSLookupE(t, dns_helper.DNSQuery{Type: "A", Name: "new.example.com"}, []string{server.Address()})
+	require.NoError(t, err)
+	assert.Equal(t, dns_helper.DNSAnswers{{Type: "A", Value: "10.0.0.4"}}, answers)
+}
+
+func TestServerHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello from %s", r.Host)
+	}))
+	defer httpServer.Close()
+	_, port, err := net.SplitHostPort(httpServer.Listener.Addr().String())
+	require.NoError(t, err)
+
+	recordSets := []*route53.ResourceRecordSet{recordSet("app.example.com.", "A", "127.0.0.1")}
+	server := newServer(func() ([]*route53.ResourceRecordSet, error) { return recordSets, nil }, nil)
+	require.NoError(t, server.start(t))
+
+	response, err := server.HTTPClient().Get(fmt.Sprintf("http://app.example.com:%s/", port))
+	require.NoError(t, err)
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from app.example.com:"+port, string(body))
+
+	_, err = server.HTTPClient().Get(fmt.Sprintf("http://missing.example.com:%s/", port))
+	assert.Error(t, err)
+}
+
+func recordSet(name string, recordType string, values ...string) *route53.ResourceRecordSet {
+	records := []*route53.ResourceRecord{}
+	for _, value := range values {
+		records = append(records, &route53.ResourceRecord{Value: awsgo.String(value)})
+	}
+	return &route53.ResourceRecordSet{
+		Name:            awsgo.String(name),
+		Type:            awsgo.String(recordType),
+		TTL:             awsgo.Int64(300),
+		ResourceRecords: records,
+	}
+}
+
+func aliasRecordSet(name string, recordType string, target string) *route53.ResourceRecordSet {
+	return &route53.ResourceRecordSet{
+		Name:        awsgo.String(name),
+		Type:        awsgo.String(recordType),
+		AliasTarget: &route53.AliasTarget{DNSName: awsgo.String(target)},
+	}
+}
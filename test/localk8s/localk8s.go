@@ -0,0 +1,130 @@
+// Package localk8s provides the Kubernetes cluster that the k8s-service and k8s-namespace tests deploy to. If kubectl is
+// already pointed at a cluster (e.g., minikube in CI), the tests use that cluster. Otherwise, a local kind
+// (Kubernetes-in-Docker) cluster is created for the test and deleted when the test finishes, so that the k8s modules can
+// be tested on any machine with docker and kind installed.
+package localk8s
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/stretchr/testify/require"
+)
+
+// Set this environment variable to true to always run the tests against a new kind cluster, even if kubectl is
+// already pointed at a cluster.
+const forceKindEnvVar = "TEST_FORCE_KIND"
+
+// How long to wait for the control plane of a new kind cluster to be ready.
+const kindWaitTimeout = "5m"
+
+// Cluster is a Kubernetes cluster to run the tests against.
+type Cluster struct {
+	// The name of the kind cluster, or empty if the tests use the cluster kubectl is already pointed at.
+	KindClusterName string
+	// The path of the kubeconfig file and the name of the context in it to use. Both are empty when the tests use the
+	// cluster kubectl is already pointed at, in which case the default kubeconfig and current context are used.
+	KubeConfigPath string
+	ContextName    string
+}
+
+// GetCluster returns the cluster kubectl is currently pointed at, or, if there is none, creates a new kind cluster. A
+// kind cluster is deleted, together with its kubeconfig, when the test and all its subtests finish, so parallel
+// subtests can share the cluster of their parent test.
+func GetCluster(t *testing.T) *Cluster {
+	if os.Getenv(forceKindEnvVar) != "true" && hasCurrentContext(t) {
+		logger.Logf(t, "Running against the cluster of the current kubectl context")
+		return &Cluster{}
+	}
+	return createKindCluster(t)
+}
+
+// KubectlOptions returns the kubectl options to use the cluster with the given namespace.
+func (cluster *Cluster) KubectlOptions(namespace string) *k8s.KubectlOptions {
+	return k8s.NewKubectlOptions(cluster.ContextName, cluster.KubeConfigPath, namespace)
+}
+
+// TerraformVars returns the variables that configure the Kubernetes and Helm providers of the k8s-service and
+// k8s-namespace examples to use the cluster.
+func (cluster *Cluster) TerraformVars() map[string]interface{} {
+	if cluster.KindClusterName == "" {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"kubeconfig_auth_type": "context",
+		"kubeconfig_path":      cluster.KubeConfigPath,
+		"kubeconfig_context":   cluster.ContextName,
+	}
+}
+
+// LoadImage makes the given docker image available on the nodes of the cluster. For a kind cluster, the image is pulled
+// locally and loaded into the nodes, so that pods don't have to pull it from inside the cluster. Any other cluster pulls
+// the image itself, so this does nothing.
+func (cluster *Cluster) LoadImage(t *testing.T, image string) {
+	if cluster.KindClusterName == "" {
+		return
+	}
+	shell.RunCommand(t, shell.Command{Command: "docker", Args: []string{"pull", image}})
+	shell.RunCommand(t, shell.Command{
+		Command: "kind",
+		Args:    []string{"load", "docker-image", image, "--name", cluster.KindClusterName},
+	})
+}
+
+// hasCurrentContext returns true if kubectl has a current context configured, taking KUBECONFIG into account.
+func hasCurrentContext(t *testing.T) bool {
+	out, err := shell.RunCommandAndGetOutputE(t, shell.Command{
+		Command: "kubectl",
+		Args:    []string{"config", "current-context"},
+		Logger:  logger.Discard,
+	})
+	return err == nil && strings.TrimSpace(out) != ""
+}
+
+// createKindCluster creates a kind cluster with its own kubeconfig file, so that the default kubeconfig of the machine
+// is left alone.
+func createKindCluster(t *testing.T) *Cluster {
+	kubeConfigFile, err := ioutil.TempFile("", "kind-kubeconfig-")
+	require.NoError(t, err)
+	kubeConfigFile.Close()
+
+	cluster := &Cluster{
+		KindClusterName: newKindClusterName(random.UniqueId()),
+		KubeConfigPath:  kubeConfigFile.Name(),
+	}
+	// kind names the context of a cluster after the cluster, with a kind- prefix.
+	cluster.ContextName = "kind-" + cluster.KindClusterName
+
+	t.Cleanup(func() {
+		shell.RunCommand(t, shell.Command{
+			Command: "kind",
+			Args:    []string{"delete", "cluster", "--name", cluster.KindClusterName, "--kubeconfig", cluster.KubeConfigPath},
+		})
+		os.Remove(cluster.KubeConfigPath)
+	})
+
+	logger.Logf(t, "No current kubectl context: creating kind cluster %s", cluster.KindClusterName)
+	shell.RunCommand(t, shell.Command{
+		Command: "kind",
+		Args: []string{
+			"create", "cluster",
+			"--name", cluster.KindClusterName,
+			"--kubeconfig", cluster.KubeConfigPath,
+			"--wait", kindWaitTimeout,
+		},
+	})
+	return cluster
+}
+
+// newKindClusterName returns a name for a kind cluster. kind uses the name in the names of docker containers and of
+// the kubeconfig context, so it must be lower case.
+func newKindClusterName(uniqueID string) string {
+	return fmt.Sprintf("service-catalog-%s", strings.ToLower(uniqueID))
+}
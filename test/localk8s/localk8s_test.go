@@ -0,0 +1,48 @@
+package localk8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKindClusterName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "service-catalog-abc123", newKindClusterName("AbC123"))
+}
+
+func TestCurrentContextCluster(t *testing.T) {
+	t.Parallel()
+
+	cluster := &Cluster{}
+	assert.Empty(t, cluster.TerraformVars())
+
+	options := cluster.KubectlOptions("my-namespace")
+	assert.Equal(t, "", options.ContextName)
+	assert.Equal(t, "", options.ConfigPath)
+	assert.Equal(t, "my-namespace", options.Namespace)
+
+	// Loading images is left to the cluster, so this must not shell out to docker or kind.
+	cluster.LoadImage(t, "gruntwork/aws-sample-app:v0.0.5")
+}
+
+func TestKindCluster(t *testing.T) {
+	t.Parallel()
+
+	cluster := &Cluster{
+		KindClusterName: "service-catalog-abc123",
+		KubeConfigPath:  "/tmp/kind-kubeconfig-123",
+		ContextName:     "kind-service-catalog-abc123",
+	}
+	assert.Equal(t, map[string]interface{}{
+		"kubeconfig_auth_type": "context",
+		"kubeconfig_path":      "/tmp/kind-kubeconfig-123",
+		"kubeconfig_context":   "kind-service-catalog-abc123",
+	}, cluster.TerraformVars())
+
+	options := cluster.KubectlOptions("my-namespace")
+	assert.Equal(t, "kind-service-catalog-abc123", options.ContextName)
+	assert.Equal(t, "/tmp/kind-kubeconfig-123", options.ConfigPath)
+	assert.Equal(t, "my-namespace", options.Namespace)
+}
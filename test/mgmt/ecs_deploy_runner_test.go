@@ -11,7 +11,6 @@ import (
 	"github.com/gruntwork-io/aws-service-catalog/test"
 
 	awsgo "github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/gruntwork-io/module-ci/test/edrhelpers"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	"github.com/gruntwork-io/terratest/modules/docker"
@@ -19,7 +18,6 @@ import (
 	"github.com/gruntwork-io/terratest/modules/logger"
 	"github.com/gruntwork-io/terratest/modules/packer"
 	"github.com/gruntwork-io/terratest/modules/random"
-	"github.com/gruntwork-io/terratest/modules/retry"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
 	"github.com/stretchr/testify/require"
@@ -336,49 +334,7 @@ func validateECSDeployRunnerPeriodicInvoke(t *testing.T, deployOpts *terraform.O
 }
 
 func requireEDRExpectedLogEntry(t *testing.T, awsRegion, logGroupName, logStreamPrefix, expectedLogEntry string) {
-	description := "Looking in CloudWatch Logs to see if EDR was invoked"
 	maxRetries := 10
 	timeBetweenRetries := 30 * time.Second
-
-	retry.DoWithRetry(t, description, maxRetries, timeBetweenRetries, func() (string, error) {
-		logStreamNames, err := getLogStreamNamesWithPrefix(t, awsRegion, logGroupName, logStreamPrefix)
-		if err != nil {
-			return "", err
-		}
-
-		for _, logStreamName := range logStreamNames {
-			entries, err := aws.GetCloudWatchLogEntriesE(t, awsRegion, logStreamName, logGroupName)
-			if err != nil {
-				return "", err
-			}
-
-			for _, entry := range entries {
-				if strings.Contains(entry, expectedLogEntry) {
-					return entry, nil
-				}
-			}
-		}
-
-		return "", fmt.Errorf("Did not find entry '%s' in CloudWatch Logs", expectedLogEntry)
-	})
-}
-
-func getLogStreamNamesWithPrefix(t *testing.T, awsRegion, logGroupName, logStreamPrefix string) ([]string, error) {
-	svc := aws.NewCloudWatchLogsClient(t, awsRegion)
-
-	input := cloudwatchlogs.DescribeLogStreamsInput{LogGroupName: awsgo.String(logGroupName)}
-	output, err := svc.DescribeLogStreams(&input)
-	if err != nil {
-		return []string{}, err
-	}
-
-	logStreamNames := []string{}
-	for _, logStream := range output.LogStreams {
-		logStreamName := awsgo.StringValue(logStream.LogStreamName)
-		if strings.HasPrefix(logStreamName, logStreamPrefix) {
-			logStreamNames = append(logStreamNames, logStreamName)
-		}
-	}
-
-	return logStreamNames, nil
+	test.WaitForCloudWatchLogEntry(t, awsRegion, logGroupName, logStreamPrefix, expectedLogEntry, maxRetries, timeBetweenRetries)
 }
@@ -0,0 +1,145 @@
+package netreach
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ipRange is an inclusive range of IPv4 addresses. The bounds are stored as uint64 so that the end of the address space
+// can be represented without overflowing.
+type ipRange struct {
+	first uint64
+	last  uint64
+}
+
+// ipSet is a set of IPv4 addresses, stored as a sorted list of non overlapping, non adjacent ranges.
+type ipSet []ipRange
+
+// allIPv4 contains every IPv4 address.
+var allIPv4 = ipSet{{first: 0, last: 1<<32 - 1}}
+
+// publicIPv4 contains every IPv4 address except the private (RFC 1918) ranges. This is used to represent the public
+// internet, as traffic from private addresses can not come in through an internet gateway.
+var publicIPv4 = allIPv4.
+	subtract(mustParseIPSet("10.0.0.0/8")).
+	subtract(mustParseIPSet("172.16.0.0/12")).
+	subtract(mustParseIPSet("192.168.0.0/16"))
+
+// parseIPSet returns the set of addresses in the given IPv4 CIDR blocks.
+func parseIPSet(cidrBlocks ...string) (ipSet, error) {
+	set := ipSet{}
+	for _, cidrBlock := range cidrBlocks {
+		_, network, err := net.ParseCIDR(cidrBlock)
+		if err != nil {
+			return nil, err
+		}
+		ip := network.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("%s is not an IPv4 CIDR block", cidrBlock)
+		}
+		ones, bits := network.Mask.Size()
+		first := uint64(binary.BigEndian.Uint32(ip))
+		last := first + (1 << uint(bits-ones)) - 1
+		set = set.union(ipSet{{first: first, last: last}})
+	}
+	return set, nil
+}
+
+func mustParseIPSet(cidrBlocks ...string) ipSet {
+	set, err := parseIPSet(cidrBlocks...)
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+func (s ipSet) isEmpty() bool {
+	return len(s) == 0
+}
+
+func (s ipSet) union(other ipSet) ipSet {
+	ranges := append(append([]ipRange{}, s...), other...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first < ranges[j].first })
+
+	out := ipSet{}
+	for _, r := range ranges {
+		if len(out) > 0 && r.first <= out[len(out)-1].last+1 {
+			if r.last > out[len(out)-1].last {
+				out[len(out)-1].last = r.last
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (s ipSet) intersect(other ipSet) ipSet {
+	out := ipSet{}
+	for _, a := range s {
+		for _, b := range other {
+			first, last := max64(a.first, b.first), min64(a.last, b.last)
+			if first <= last {
+				out = append(out, ipRange{first: first, last: last})
+			}
+		}
+	}
+	return out.union(nil)
+}
+
+func (s ipSet) subtract(other ipSet) ipSet {
+	out := append(ipSet{}, s...)
+	for _, b := range other {
+		next := ipSet{}
+		for _, a := range out {
+			if b.last < a.first || b.first > a.last {
+				next = append(next, a)
+				continue
+			}
+			if a.first < b.first {
+				next = append(next, ipRange{first: a.first, last: b.first - 1})
+			}
+			if a.last > b.last {
+				next = append(next, ipRange{first: b.last + 1, last: a.last})
+			}
+		}
+		out = next
+	}
+	return out
+}
+
+// String renders the set as a list of address ranges, for use in error messages.
+func (s ipSet) String() string {
+	parts := []string{}
+	for _, r := range s {
+		if r.first == r.last {
+			parts = append(parts, uint64ToIP(r.first).String())
+		} else {
+			parts = append(parts, fmt.Sprintf("%s-%s", uint64ToIP(r.first), uint64ToIP(r.last)))
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func uint64ToIP(addr uint64) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, uint32(addr))
+	return ip
+}
+
+func min64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,543 @@
+// Package netreach builds an offline model of the network resources in a Terraform plan (VPCs, subnets, route tables,
+// network ACLs and security groups) and answers questions about which traffic that network allows, such as "can the
+// public internet reach port 22 on any instance" or "can the private app subnets reach the persistence subnets on port
+// 5432". This allows tests to assert the network isolation of a module without deploying anything.
+//
+// Many IDs are not known until apply time, so the model links resources by following the references in the Terraform
+// configuration. Whenever a link can not be determined, the model errs on the side of reachability: an unknown subnet,
+// route table, network ACL, or security group is assumed to allow all traffic. This makes the model suitable for
+// asserting that traffic is blocked. Only IPv4 is modeled.
+package netreach
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The port that return traffic is sent to when checking network ACLs. Network ACLs are stateless, so the response to a
+// request has to be explicitly allowed back to the ephemeral port of the client.
+const ephemeralPort = 49152
+
+// Network is a model of the network resources in a Terraform plan.
+type Network struct {
+	VPCs           []*VPC
+	Subnets        []*Subnet
+	RouteTables    []*RouteTable
+	NetworkACLs    []*NetworkACL
+	SecurityGroups []*SecurityGroup
+	Endpoints      []*Endpoint
+}
+
+// VPC is an aws_vpc resource.
+type VPC struct {
+	Address   string
+	CIDRBlock string
+
+	// The default network ACL, security group, and main route table of the VPC. These are nil if they are not managed in
+	// the plan.
+	DefaultNetworkACL    *NetworkACL
+	DefaultSecurityGroup *SecurityGroup
+	MainRouteTable       *RouteTable
+}
+
+// Subnet is an aws_subnet resource.
+type Subnet struct {
+	Address             string
+	CIDRBlock           string
+	AvailabilityZone    string
+	MapPublicIPOnLaunch bool
+
+	// The VPC, route table, and network ACL of the subnet. These are nil if they could not be determined from the plan.
+	VPC        *VPC
+	RouteTable *RouteTable
+	NetworkACL *NetworkACL
+}
+
+// RouteTarget is the kind of target that a route sends traffic to.
+type RouteTarget string
+
+const (
+	RouteTargetLocal                RouteTarget = "local"
+	RouteTargetInternetGateway      RouteTarget = "internet-gateway"
+	RouteTargetNATGateway           RouteTarget = "nat-gateway"
+	RouteTargetVPCPeeringConnection RouteTarget = "vpc-peering-connection"
+	RouteTargetTransitGateway       RouteTarget = "transit-gateway"
+	RouteTargetOther                RouteTarget = "other"
+)
+
+// RouteTable is an aws_route_table or aws_default_route_table resource, along with its routes. The local route of the
+// VPC is implicit.
+type RouteTable struct {
+	Address string
+	VPC     *VPC
+	Routes  []Route
+}
+
+// Route is a single route in a route table.
+type Route struct {
+	DestinationCIDRBlock string
+	Target               RouteTarget
+}
+
+// NetworkACL is an aws_network_acl or aws_default_network_acl resource, along with its rules. The default rule that
+// denies all traffic is implicit.
+type NetworkACL struct {
+	Address string
+	VPC     *VPC
+	Rules   []NetworkACLRule
+}
+
+// NetworkACLRule is a single rule in a network ACL.
+type NetworkACLRule struct {
+	RuleNumber int
+	Egress     bool
+	Allow      bool
+	Protocol   string
+	CIDRBlock  string
+	FromPort   int
+	ToPort     int
+}
+
+// SecurityGroup is an aws_security_group or aws_default_security_group resource, along with its rules.
+type SecurityGroup struct {
+	Address string
+	VPC     *VPC
+	Rules   []SecurityGroupRule
+}
+
+// SecurityGroupRule is a single rule in a security group.
+type SecurityGroupRule struct {
+	Egress     bool
+	Protocol   string
+	FromPort   int
+	ToPort     int
+	CIDRBlocks []string
+
+	// Traffic to or from endpoints in these security groups is allowed.
+	SecurityGroups []*SecurityGroup
+
+	// Traffic to or from endpoints in the same security group is allowed.
+	Self bool
+
+	// The rule refers to a security group that could not be determined from the plan. Traffic to or from any endpoint
+	// is assumed to be allowed.
+	UnknownSecurityGroups bool
+}
+
+// Endpoint is a resource that has network interfaces in a VPC, such as an EC2 instance, a load balancer, or an Auto
+// Scaling Group.
+type Endpoint struct {
+	Address string
+	Type    string
+
+	// The subnets the endpoint may be in. If empty, the subnets could not be determined from the plan and traffic to the
+	// endpoint is only filtered by its security groups.
+	Subnets []*Subnet
+
+	// The security groups of the endpoint. If empty, the security groups could not be determined from the plan and
+	// traffic is not filtered.
+	SecurityGroups []*SecurityGroup
+
+	// Whether the endpoint has a public IP address, and so can be reached from the internet.
+	PublicIP bool
+}
+
+// Source is the origin of traffic: the public internet, a subnet, or an endpoint.
+type Source interface {
+	sourceParties() []party
+}
+
+// Destination is the target of traffic: a subnet or an endpoint.
+type Destination interface {
+	destinationParties() []party
+}
+
+// Result describes whether traffic can flow from a source to a destination.
+type Result struct {
+	Reachable bool
+
+	// Explains, for each combination of source and destination subnets that was checked, why traffic could not flow.
+	// Empty if Reachable is true.
+	Reasons []string
+}
+
+// Internet returns a Source that represents any address on the public internet.
+func Internet() Source {
+	return internet{}
+}
+
+type internet struct{}
+
+func (internet) sourceParties() []party {
+	return []party{{description: "the internet", addresses: publicIPv4, internet: true}}
+}
+
+func (subnet *Subnet) sourceParties() []party {
+	return []party{subnet.party()}
+}
+
+func (subnet *Subnet) destinationParties() []party {
+	return []party{subnet.party()}
+}
+
+func (subnet *Subnet) party() party {
+	return party{description: subnet.Address, addresses: cidrBlockAddresses(subnet.CIDRBlock), subnet: subnet}
+}
+
+func (endpoint *Endpoint) sourceParties() []party {
+	return endpoint.parties()
+}
+
+func (endpoint *Endpoint) destinationParties() []party {
+	return endpoint.parties()
+}
+
+func (endpoint *Endpoint) parties() []party {
+	if len(endpoint.Subnets) == 0 {
+		return []party{{description: endpoint.Address, addresses: allIPv4, endpoint: endpoint}}
+	}
+
+	parties := []party{}
+	for _, subnet := range endpoint.Subnets {
+		parties = append(parties, party{
+			description: fmt.Sprintf("%s in %s", endpoint.Address, subnet.Address),
+			addresses:   cidrBlockAddresses(subnet.CIDRBlock),
+			subnet:      subnet,
+			endpoint:    endpoint,
+		})
+	}
+	return parties
+}
+
+// party is one end of a network flow.
+type party struct {
+	description string
+	addresses   ipSet
+	internet    bool
+	subnet      *Subnet
+	endpoint    *Endpoint
+}
+
+// CanReach returns whether the source can open a connection to the destination on the given protocol and port. The
+// protocol can be a name (tcp, udp, icmp) or an IP protocol number. If the source or destination can be in multiple
+// subnets, traffic is considered reachable if it can flow between any of them.
+func (network *Network) CanReach(source Source, destination Destination, protocol string, port int) Result {
+	result := Result{}
+	for _, src := range source.sourceParties() {
+		for _, dst := range destination.destinationParties() {
+			reason := evaluateFlow(src, dst, normalizeProtocol(protocol), port)
+			if reason == "" {
+				return Result{Reachable: true}
+			}
+			result.Reasons = append(result.Reasons, reason)
+		}
+	}
+	return result
+}
+
+// EndpointsReachableFromInternet returns all the endpoints that can be reached from the public internet on the given
+// protocol and port.
+func (network *Network) EndpointsReachableFromInternet(protocol string, port int) []*Endpoint {
+	endpoints := []*Endpoint{}
+	for _, endpoint := range network.Endpoints {
+		if network.CanReach(Internet(), endpoint, protocol, port).Reachable {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// SubnetsInCIDRBlocks returns the subnets whose CIDR block is one of the given CIDR blocks. This is a convenient way to
+// select a tier of subnets using the outputs of a module, as the resource addresses of subnets depend on the internals
+// of the module.
+func (network *Network) SubnetsInCIDRBlocks(cidrBlocks []string) []*Subnet {
+	subnets := []*Subnet{}
+	for _, subnet := range network.Subnets {
+		for _, cidrBlock := range cidrBlocks {
+			if subnet.CIDRBlock == cidrBlock {
+				subnets = append(subnets, subnet)
+			}
+		}
+	}
+	return subnets
+}
+
+// Endpoint returns the endpoint with the given resource address, or nil if there is no such endpoint.
+func (network *Network) Endpoint(address string) *Endpoint {
+	for _, endpoint := range network.Endpoints {
+		if endpoint.Address == address {
+			return endpoint
+		}
+	}
+	return nil
+}
+
+// evaluateFlow checks whether a connection can be opened from the source to the destination, including the return
+// traffic. Returns an empty string if the connection is allowed, or the reason it is blocked otherwise.
+func evaluateFlow(src party, dst party, protocol string, port int) string {
+	// The addresses of each side that traffic can still flow between. Network ACLs and security groups only look at the
+	// remote address of a packet, so we can narrow these down independently, and the flow is reachable as long as both
+	// remain non empty.
+	srcAddresses := src.addresses
+	dstAddresses := dst.addresses
+
+	if src.internet {
+		if dst.endpoint != nil && !dst.endpoint.PublicIP {
+			return fmt.Sprintf("%s does not have a public IP address", dst.endpoint.Address)
+		}
+		if dst.subnet != nil {
+			srcAddresses = dst.subnet.RouteTable.routedAddresses(dst.subnet.VPC, srcAddresses, isInternetGateway)
+			if srcAddresses.isEmpty() {
+				return fmt.Sprintf("%s does not route traffic back to the internet through an internet gateway", dst.subnet.Address)
+			}
+		}
+	} else if src.subnet != nil && dst.subnet != nil && src.subnet.VPC != dst.subnet.VPC {
+		dstAddresses = src.subnet.RouteTable.routedAddresses(src.subnet.VPC, dstAddresses, isCrossVPC)
+		if dstAddresses.isEmpty() {
+			return fmt.Sprintf("%s does not route traffic to %s", src.subnet.Address, dst.description)
+		}
+		srcAddresses = dst.subnet.RouteTable.routedAddresses(dst.subnet.VPC, srcAddresses, isCrossVPC)
+		if srcAddresses.isEmpty() {
+			return fmt.Sprintf("%s does not route traffic back to %s", dst.subnet.Address, src.description)
+		}
+	}
+
+	// Network ACLs do not apply to traffic within a subnet.
+	if src.subnet == nil || src.subnet != dst.subnet {
+		if src.subnet != nil {
+			dstAddresses = src.subnet.NetworkACL.allowedAddresses(true, protocol, port, dstAddresses)
+			if dstAddresses.isEmpty() {
+				return fmt.Sprintf("network ACL of %s does not allow outbound traffic to %s on %s", src.subnet.Address, dst.description, describeTraffic(protocol, port))
+			}
+		}
+		if dst.subnet != nil {
+			srcAddresses = dst.subnet.NetworkACL.allowedAddresses(false, protocol, port, srcAddresses)
+			if srcAddresses.isEmpty() {
+				return fmt.Sprintf("network ACL of %s does not allow inbound traffic from %s on %s", dst.subnet.Address, src.description, describeTraffic(protocol, port))
+			}
+			srcAddresses = dst.subnet.NetworkACL.allowedAddresses(true, protocol, ephemeralPort, srcAddresses)
+			if srcAddresses.isEmpty() {
+				return fmt.Sprintf("network ACL of %s does not allow return traffic to %s", dst.subnet.Address, src.description)
+			}
+		}
+		if src.subnet != nil {
+			dstAddresses = src.subnet.NetworkACL.allowedAddresses(false, protocol, ephemeralPort, dstAddresses)
+			if dstAddresses.isEmpty() {
+				return fmt.Sprintf("network ACL of %s does not allow return traffic from %s", src.subnet.Address, dst.description)
+			}
+		}
+	}
+
+	// Security groups are stateful, so return traffic is always allowed.
+	if src.endpoint != nil {
+		dstAddresses = src.endpoint.allowedAddresses(true, protocol, port, dstAddresses, dst.endpoint)
+		if dstAddresses.isEmpty() {
+			return fmt.Sprintf("security groups of %s do not allow outbound traffic to %s on %s", src.endpoint.Address, dst.description, describeTraffic(protocol, port))
+		}
+	}
+	if dst.endpoint != nil {
+		srcAddresses = dst.endpoint.allowedAddresses(false, protocol, port, srcAddresses, src.endpoint)
+		if srcAddresses.isEmpty() {
+			return fmt.Sprintf("security groups of %s do not allow inbound traffic from %s on %s", dst.endpoint.Address, src.description, describeTraffic(protocol, port))
+		}
+	}
+
+	return ""
+}
+
+// routedAddresses returns the subset of the destination addresses that the route table sends to a target accepted by
+// the given function, using the longest prefix match like AWS does. A nil route table is unknown, and is assumed to
+// route all traffic.
+func (routeTable *RouteTable) routedAddresses(vpc *VPC, destinations ipSet, acceptTarget func(RouteTarget) bool) ipSet {
+	if routeTable == nil {
+		return destinations
+	}
+
+	routes := append([]Route{}, routeTable.Routes...)
+	if vpc != nil && vpc.CIDRBlock != "" {
+		routes = append(routes, Route{DestinationCIDRBlock: vpc.CIDRBlock, Target: RouteTargetLocal})
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return prefixLength(routes[i].DestinationCIDRBlock) > prefixLength(routes[j].DestinationCIDRBlock)
+	})
+
+	routed := ipSet{}
+	remaining := destinations
+	for _, route := range routes {
+		routeAddresses, err := parseIPSet(route.DestinationCIDRBlock)
+		if err != nil {
+			continue
+		}
+		if acceptTarget(route.Target) {
+			routed = routed.union(remaining.intersect(routeAddresses))
+		}
+		remaining = remaining.subtract(routeAddresses)
+	}
+	return routed
+}
+
+func isInternetGateway(target RouteTarget) bool {
+	return target == RouteTargetInternetGateway
+}
+
+func isCrossVPC(target RouteTarget) bool {
+	return target == RouteTargetVPCPeeringConnection || target == RouteTargetTransitGateway || target == RouteTargetOther
+}
+
+// allowedAddresses returns the subset of the remote addresses that the network ACL allows traffic to (egress) or from
+// (ingress). Rules are evaluated in order of their rule number, and the first matching rule wins. A nil network ACL is
+// unknown, and is assumed to allow all traffic, like the default network ACL of a VPC does.
+func (acl *NetworkACL) allowedAddresses(egress bool, protocol string, port int, remotes ipSet) ipSet {
+	if acl == nil {
+		return remotes
+	}
+
+	rules := []NetworkACLRule{}
+	for _, rule := range acl.Rules {
+		if rule.Egress == egress {
+			rules = append(rules, rule)
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].RuleNumber < rules[j].RuleNumber })
+
+	allowed := ipSet{}
+	remaining := remotes
+	for _, rule := range rules {
+		if !matchesProtocolAndPort(rule.Protocol, rule.FromPort, rule.ToPort, protocol, port) {
+			continue
+		}
+		ruleAddresses, err := parseIPSet(rule.CIDRBlock)
+		if err != nil {
+			continue
+		}
+		if rule.Allow {
+			allowed = allowed.union(remaining.intersect(ruleAddresses))
+		}
+		remaining = remaining.subtract(ruleAddresses)
+	}
+	return allowed
+}
+
+// allowedAddresses returns the subset of the remote addresses that the security groups of the endpoint allow traffic
+// to (egress) or from (ingress). The remote endpoint is used to match rules that refer to other security groups, and
+// is nil if the remote side is not an endpoint.
+func (endpoint *Endpoint) allowedAddresses(egress bool, protocol string, port int, remotes ipSet, remote *Endpoint) ipSet {
+	if len(endpoint.SecurityGroups) == 0 {
+		return remotes
+	}
+
+	allowed := ipSet{}
+	for _, group := range endpoint.SecurityGroups {
+		for _, rule := range group.Rules {
+			if rule.Egress != egress || !matchesProtocolAndPort(rule.Protocol, rule.FromPort, rule.ToPort, protocol, port) {
+				continue
+			}
+			for _, cidrBlock := range rule.CIDRBlocks {
+				ruleAddresses, err := parseIPSet(cidrBlock)
+				if err == nil {
+					allowed = allowed.union(remotes.intersect(ruleAddresses))
+				}
+			}
+			if remote != nil && rule.allowsEndpoint(group, remote) {
+				allowed = allowed.union(remotes)
+			}
+		}
+	}
+	return allowed
+}
+
+// allowsEndpoint returns whether the rule of the given security group allows traffic with the given endpoint based on
+// the security groups of that endpoint.
+func (rule SecurityGroupRule) allowsEndpoint(group *SecurityGroup, remote *Endpoint) bool {
+	if rule.UnknownSecurityGroups || len(remote.SecurityGroups) == 0 {
+		return true
+	}
+	for _, remoteGroup := range remote.SecurityGroups {
+		if rule.Self && remoteGroup == group {
+			return true
+		}
+		for _, ruleGroup := range rule.SecurityGroups {
+			if remoteGroup == ruleGroup {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesProtocolAndPort returns whether a rule for the given protocol and port range applies to traffic on the given
+// protocol and port. Both protocols must already be normalized. Ports only apply to TCP and UDP.
+func matchesProtocolAndPort(ruleProtocol string, fromPort int, toPort int, protocol string, port int) bool {
+	ruleProtocol = normalizeProtocol(ruleProtocol)
+	if ruleProtocol == protocolAll {
+		return true
+	}
+	if ruleProtocol != protocol {
+		return false
+	}
+	if protocol != protocolTCP && protocol != protocolUDP {
+		return true
+	}
+	return fromPort <= port && port <= toPort
+}
+
+const (
+	protocolAll  = "-1"
+	protocolICMP = "1"
+	protocolTCP  = "6"
+	protocolUDP  = "17"
+)
+
+// normalizeProtocol converts the given protocol to the IP protocol number used by AWS, with -1 meaning all protocols.
+func normalizeProtocol(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "all", "-1":
+		return protocolAll
+	case "icmp":
+		return protocolICMP
+	case "tcp":
+		return protocolTCP
+	case "udp":
+		return protocolUDP
+	}
+	return protocol
+}
+
+// describeTraffic renders the normalized protocol and port for use in error messages, e.g. tcp/22.
+func describeTraffic(protocol string, port int) string {
+	switch protocol {
+	case protocolAll:
+		return "all traffic"
+	case protocolICMP:
+		return "icmp"
+	case protocolTCP:
+		return fmt.Sprintf("tcp/%d", port)
+	case protocolUDP:
+		return fmt.Sprintf("udp/%d", port)
+	}
+	return fmt.Sprintf("protocol %s", protocol)
+}
+
+// cidrBlockAddresses returns the addresses in the given CIDR block. If the CIDR block is unknown, this returns all
+// addresses.
+func cidrBlockAddresses(cidrBlock string) ipSet {
+	addresses, err := parseIPSet(cidrBlock)
+	if err != nil {
+		return allIPv4
+	}
+	return addresses
+}
+
+// prefixLength returns the prefix length of the given CIDR block, or -1 if it is not a valid CIDR block.
+func prefixLength(cidrBlock string) int {
+	parts := strings.Split(cidrBlock, "/")
+	if len(parts) != 2 {
+		return -1
+	}
+	var length int
+	if _, err := fmt.Sscanf(parts[1], "%d", &length); err != nil {
+		return -1
+	}
+	return length
+}
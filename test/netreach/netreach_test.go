@@ -0,0 +1,148 @@
+package netreach
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPSet(t *testing.T) {
+	t.Parallel()
+
+	vpc := mustParseIPSet("10.0.0.0/16")
+	subnet := mustParseIPSet("10.0.1.0/24")
+
+	assert.Equal(t, "[10.0.1.0-10.0.1.255]", vpc.intersect(subnet).String())
+	assert.Equal(t, "[10.0.0.0-10.0.0.255, 10.0.2.0-10.0.255.255]", vpc.subtract(subnet).String())
+	assert.Equal(t, "[10.0.0.0-10.0.255.255]", vpc.subtract(subnet).union(subnet).String())
+	assert.True(t, publicIPv4.intersect(vpc).isEmpty())
+	assert.False(t, publicIPv4.intersect(mustParseIPSet("8.8.8.8/32")).isEmpty())
+
+	_, err := parseIPSet("2001:db8::/32")
+	assert.Error(t, err)
+}
+
+func TestNetworkACLRuleOrder(t *testing.T) {
+	t.Parallel()
+
+	// Deny SSH from one address, then allow SSH from the rest of the internet.
+	acl := &NetworkACL{Rules: []NetworkACLRule{
+		{RuleNumber: 200, Allow: true, Protocol: "tcp", CIDRBlock: "0.0.0.0/0", FromPort: 22, ToPort: 22},
+		{RuleNumber: 100, Allow: false, Protocol: "-1", CIDRBlock: "203.0.113.10/32"},
+	}}
+
+	allowed := acl.allowedAddresses(false, protocolTCP, 22, mustParseIPSet("203.0.113.0/24"))
+	assert.Equal(t, "[203.0.113.0-203.0.113.9, 203.0.113.11-203.0.113.255]", allowed.String())
+	assert.True(t, acl.allowedAddresses(false, protocolTCP, 80, mustParseIPSet("203.0.113.0/24")).isEmpty())
+	assert.True(t, acl.allowedAddresses(true, protocolTCP, 22, mustParseIPSet("203.0.113.0/24")).isEmpty())
+}
+
+func TestFromPlan(t *testing.T) {
+	t.Parallel()
+
+	network := loadTestPlan(t)
+
+	require.Len(t, network.VPCs, 1)
+	require.Len(t, network.Subnets, 6)
+	require.Len(t, network.Endpoints, 3)
+
+	publicSubnets := network.SubnetsInCIDRBlocks([]string{"10.0.0.0/24", "10.0.1.0/24"})
+	privateSubnets := network.SubnetsInCIDRBlocks([]string{"10.0.10.0/24", "10.0.11.0/24"})
+	persistenceSubnets := network.SubnetsInCIDRBlocks([]string{"10.0.20.0/24", "10.0.21.0/24"})
+	require.Len(t, publicSubnets, 2)
+	require.Len(t, privateSubnets, 2)
+	require.Len(t, persistenceSubnets, 2)
+
+	for _, subnet := range network.Subnets {
+		assert.Equal(t, network.VPCs[0], subnet.VPC, subnet.Address)
+	}
+	for _, subnet := range publicSubnets {
+		require.NotNil(t, subnet.RouteTable, subnet.Address)
+		assert.Equal(t, "module.vpc.aws_route_table.public", subnet.RouteTable.Address)
+	}
+	for _, subnet := range privateSubnets {
+		require.NotNil(t, subnet.RouteTable, subnet.Address)
+		assert.Equal(t, "module.vpc.aws_route_table.private", subnet.RouteTable.Address)
+	}
+	for _, subnet := range persistenceSubnets {
+		require.NotNil(t, subnet.NetworkACL, subnet.Address)
+		assert.Len(t, subnet.NetworkACL.Rules, 3)
+	}
+
+	web := network.Endpoint("aws_instance.web")
+	require.NotNil(t, web)
+	assert.True(t, web.PublicIP)
+	assert.ElementsMatch(t, publicSubnets, web.Subnets)
+	require.Len(t, web.SecurityGroups, 1)
+	assert.Equal(t, "aws_security_group.web", web.SecurityGroups[0].Address)
+	assert.Len(t, web.SecurityGroups[0].Rules, 3)
+
+	db := network.Endpoint("aws_instance.db")
+	require.NotNil(t, db)
+	assert.False(t, db.PublicIP)
+	require.Len(t, db.SecurityGroups, 1)
+	require.Len(t, db.SecurityGroups[0].Rules, 1)
+	require.Len(t, db.SecurityGroups[0].Rules[0].SecurityGroups, 1)
+	assert.Equal(t, "aws_security_group.internal", db.SecurityGroups[0].Rules[0].SecurityGroups[0].Address)
+}
+
+func TestReachabilityFromPlan(t *testing.T) {
+	t.Parallel()
+
+	network := loadTestPlan(t)
+	web := network.Endpoint("aws_instance.web")
+	internal := network.Endpoint("aws_instance.internal")
+	db := network.Endpoint("aws_instance.db")
+	publicSubnets := network.SubnetsInCIDRBlocks([]string{"10.0.0.0/24", "10.0.1.0/24"})
+	privateSubnet := network.SubnetsInCIDRBlocks([]string{"10.0.10.0/24"})[0]
+	persistenceSubnet := network.SubnetsInCIDRBlocks([]string{"10.0.20.0/24"})[0]
+
+	testCases := []struct {
+		name        string
+		source      Source
+		destination Destination
+		port        int
+		reachable   bool
+	}{
+		{"internet to web server", Internet(), web, 8080, true},
+		{"internet to ssh on web server", Internet(), web, 22, false},
+		{"internet to private instance", Internet(), internal, 8080, false},
+		{"internet to private subnet", Internet(), privateSubnet, 8080, false},
+		{"private instance to ssh on web server", internal, web, 22, true},
+		{"private instance to database", internal, db, 5432, true},
+		{"private instance to database on other port", internal, db, 3306, false},
+		{"web server to database", web, db, 5432, false},
+		{"private subnet to persistence subnet", privateSubnet, persistenceSubnet, 5432, true},
+		{"public subnet to persistence subnet", publicSubnets[0], persistenceSubnet, 5432, false},
+		{"persistence subnet to private subnet", persistenceSubnet, privateSubnet, 5432, false},
+	}
+
+	for _, testCase := range testCases {
+		result := network.CanReach(testCase.source, testCase.destination, "tcp", testCase.port)
+		assert.Equal(t, testCase.reachable, result.Reachable, "%s: %v", testCase.name, result.Reasons)
+		if !testCase.reachable {
+			assert.NotEmpty(t, result.Reasons, testCase.name)
+		}
+	}
+
+	exposed := network.EndpointsReachableFromInternet("tcp", 8080)
+	require.Len(t, exposed, 1)
+	assert.Equal(t, web, exposed[0])
+	assert.Empty(t, network.EndpointsReachableFromInternet("tcp", 22))
+}
+
+func loadTestPlan(t *testing.T) *Network {
+	planJSON, err := ioutil.ReadFile("testdata/plan.json")
+	require.NoError(t, err)
+
+	var plan tfjson.Plan
+	require.NoError(t, json.Unmarshal(planJSON, &plan))
+
+	network, err := FromPlan(&plan)
+	require.NoError(t, err)
+	return network
+}
@@ -0,0 +1,999 @@
+package netreach
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/require"
+)
+
+// The maximum number of module inputs and outputs to follow when resolving a reference. Terraform does not allow
+// cycles, so this only guards against malformed plans.
+const maxReferenceDepth = 50
+
+// InitAndPlan runs terraform init and plan with the given options, and builds a model of the network in the plan. The
+// plan is returned as well, so that its planned outputs can be used to pick out parts of the network. If the options
+// don't set a plan file path, the plan is written to a temp file that is removed when the test finishes.
+func InitAndPlan(t *testing.T, terraformOptions *terraform.Options) (*Network, *terraform.PlanStruct) {
+	if terraformOptions.PlanFilePath == "" {
+		planFile, err := ioutil.TempFile("", "netreach-plan-")
+		require.NoError(t, err)
+		planFile.Close()
+		t.Cleanup(func() { os.Remove(planFile.Name()) })
+		terraformOptions.PlanFilePath = planFile.Name()
+	}
+
+	plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+	network, err := FromPlan(&plan.RawPlan)
+	require.NoError(t, err)
+	return network, plan
+}
+
+// PlannedOutputStrings returns the strings in the planned value of the given output, which can be a string, a list, or
+// a map. The value of an output is only in the plan if it is known before apply.
+func PlannedOutputStrings(t *testing.T, plan *terraform.PlanStruct, name string) []string {
+	require.NotNil(t, plan.RawPlan.PlannedValues)
+	output, hasOutput := plan.RawPlan.PlannedValues.Outputs[name]
+	require.Truef(t, hasOutput, "Output %s is not known at plan time", name)
+
+	if values, isMap := output.Value.(map[string]interface{}); isMap {
+		strs := []string{}
+		for _, value := range values {
+			strs = append(strs, stringListValue(value)...)
+		}
+		sort.Strings(strs)
+		return strs
+	}
+	return stringListValue(output.Value)
+}
+
+// FromPlan builds a model of the network in the given Terraform plan.
+func FromPlan(plan *tfjson.Plan) (*Network, error) {
+	if plan.PlannedValues == nil || plan.PlannedValues.RootModule == nil {
+		return nil, fmt.Errorf("plan does not contain any planned values")
+	}
+
+	resources := newPlanResources(plan)
+	builder := networkBuilder{
+		resources:      resources,
+		network:        &Network{},
+		vpcs:           map[*resourceInstance]*VPC{},
+		subnets:        map[*resourceInstance]*Subnet{},
+		routeTables:    map[*resourceInstance]*RouteTable{},
+		networkACLs:    map[*resourceInstance]*NetworkACL{},
+		securityGroups: map[*resourceInstance]*SecurityGroup{},
+	}
+	builder.build()
+	return builder.network, nil
+}
+
+// networkBuilder builds the network model from the resources in a plan. The maps track which model object was created
+// for each resource instance, so that the resources can be linked together.
+type networkBuilder struct {
+	resources      *planResources
+	network        *Network
+	vpcs           map[*resourceInstance]*VPC
+	subnets        map[*resourceInstance]*Subnet
+	routeTables    map[*resourceInstance]*RouteTable
+	networkACLs    map[*resourceInstance]*NetworkACL
+	securityGroups map[*resourceInstance]*SecurityGroup
+}
+
+func (builder *networkBuilder) build() {
+	builder.addVPCs()
+	builder.addSubnets()
+	builder.addRouteTables()
+	builder.addNetworkACLs()
+	builder.addSecurityGroups()
+	builder.addEndpoints()
+}
+
+func (builder *networkBuilder) addVPCs() {
+	for _, inst := range builder.resources.ofType("aws_vpc") {
+		vpc := &VPC{Address: inst.address, CIDRBlock: inst.stringValue("cidr_block")}
+		builder.vpcs[inst] = vpc
+		builder.network.VPCs = append(builder.network.VPCs, vpc)
+	}
+}
+
+func (builder *networkBuilder) addSubnets() {
+	for _, inst := range builder.resources.ofType("aws_subnet") {
+		subnet := &Subnet{
+			Address:             inst.address,
+			CIDRBlock:           inst.stringValue("cidr_block"),
+			AvailabilityZone:    inst.stringValue("availability_zone"),
+			MapPublicIPOnLaunch: inst.boolValue("map_public_ip_on_launch"),
+			VPC:                 builder.linkedVPC(inst, "vpc_id"),
+		}
+		builder.subnets[inst] = subnet
+		builder.network.Subnets = append(builder.network.Subnets, subnet)
+	}
+}
+
+func (builder *networkBuilder) addRouteTables() {
+	for _, inst := range builder.resources.ofType("aws_route_table", "aws_default_route_table") {
+		routeTable := &RouteTable{Address: inst.address}
+		if inst.resourceType == "aws_default_route_table" {
+			routeTable.VPC = builder.linkedVPC(inst, "default_route_table_id")
+			if routeTable.VPC != nil {
+				routeTable.VPC.MainRouteTable = routeTable
+			}
+		} else {
+			routeTable.VPC = builder.linkedVPC(inst, "vpc_id")
+		}
+		for i, route := range inst.blocks("route") {
+			routeTable.Routes = append(routeTable.Routes, Route{
+				DestinationCIDRBlock: stringValue(route["cidr_block"]),
+				Target:               builder.routeTarget(inst, "route", i, route),
+			})
+		}
+		builder.routeTables[inst] = routeTable
+		builder.network.RouteTables = append(builder.network.RouteTables, routeTable)
+	}
+
+	for _, inst := range builder.resources.ofType("aws_route") {
+		route := Route{
+			DestinationCIDRBlock: inst.stringValue("destination_cidr_block"),
+			Target:               builder.routeTarget(inst, "", 0, inst.values),
+		}
+		for _, routeTable := range builder.linkedRouteTables(inst, "route_table_id") {
+			routeTable.Routes = append(routeTable.Routes, route)
+		}
+	}
+
+	for _, inst := range builder.resources.ofType("aws_main_route_table_association") {
+		vpc := builder.linkedVPC(inst, "vpc_id")
+		routeTables := builder.linkedRouteTables(inst, "route_table_id")
+		if vpc != nil && len(routeTables) > 0 {
+			vpc.MainRouteTable = routeTables[0]
+		}
+	}
+
+	for _, inst := range builder.resources.ofType("aws_route_table_association") {
+		routeTables := builder.linkedRouteTables(inst, "route_table_id")
+		if len(routeTables) == 0 {
+			continue
+		}
+		for _, subnet := range builder.linkedSubnets(inst, "subnet_id") {
+			subnet.RouteTable = routeTables[0]
+		}
+	}
+
+	// Subnets that are not explicitly associated with a route table use the main route table of their VPC. If that is
+	// not managed in the plan, the route table stays unknown.
+	for _, subnet := range builder.network.Subnets {
+		if subnet.RouteTable == nil && subnet.VPC != nil {
+			subnet.RouteTable = subnet.VPC.MainRouteTable
+		}
+	}
+}
+
+// routeTarget determines the kind of target of a route, either from the ID of the target if it is known, or from the
+// type of the resource that the target refers to.
+func (builder *networkBuilder) routeTarget(inst *resourceInstance, block string, blockIndex int, route map[string]interface{}) RouteTarget {
+	targetAttributes := []struct {
+		name   string
+		target RouteTarget
+	}{
+		{"gateway_id", RouteTargetInternetGateway},
+		{"nat_gateway_id", RouteTargetNATGateway},
+		{"vpc_peering_connection_id", RouteTargetVPCPeeringConnection},
+		{"transit_gateway_id", RouteTargetTransitGateway},
+		{"egress_only_gateway_id", RouteTargetOther},
+		{"network_interface_id", RouteTargetOther},
+		{"instance_id", RouteTargetOther},
+		{"vpc_endpoint_id", RouteTargetOther},
+	}
+
+	for _, attribute := range targetAttributes {
+		if id := stringValue(route[attribute.name]); id != "" {
+			if attribute.name != "gateway_id" {
+				return attribute.target
+			}
+			switch {
+			case id == "local":
+				return RouteTargetLocal
+			case strings.HasPrefix(id, "igw-"):
+				return RouteTargetInternetGateway
+			}
+			return RouteTargetOther
+		}
+
+		refs := builder.resources.references(inst, block, blockIndex, attribute.name)
+		if len(refs) == 0 && !builder.resources.hasExpression(inst, block, blockIndex, attribute.name) {
+			continue
+		}
+		if attribute.name != "gateway_id" || len(refs) == 0 {
+			// A gateway that can not be resolved is assumed to be an internet gateway.
+			return attribute.target
+		}
+		for _, ref := range refs {
+			if ref.resourceType == "aws_internet_gateway" {
+				return RouteTargetInternetGateway
+			}
+		}
+		return RouteTargetOther
+	}
+	return RouteTargetOther
+}
+
+func (builder *networkBuilder) addNetworkACLs() {
+	for _, inst := range builder.resources.ofType("aws_network_acl", "aws_default_network_acl") {
+		acl := &NetworkACL{Address: inst.address}
+		if inst.resourceType == "aws_default_network_acl" {
+			acl.VPC = builder.linkedVPC(inst, "default_network_acl_id")
+			if acl.VPC != nil {
+				acl.VPC.DefaultNetworkACL = acl
+			}
+		} else {
+			acl.VPC = builder.linkedVPC(inst, "vpc_id")
+		}
+		for _, direction := range []string{"ingress", "egress"} {
+			for _, rule := range inst.blocks(direction) {
+				// Values that are not known until apply time are filled in to allow the most traffic.
+				acl.Rules = append(acl.Rules, NetworkACLRule{
+					RuleNumber: intValue(valueOr(rule["rule_no"], 0.0)),
+					Egress:     direction == "egress",
+					Allow:      stringValue(valueOr(rule["action"], "allow")) == "allow",
+					Protocol:   stringValue(valueOr(rule["protocol"], protocolAll)),
+					CIDRBlock:  stringValue(valueOr(rule["cidr_block"], "0.0.0.0/0")),
+					FromPort:   intValue(valueOr(rule["from_port"], 0.0)),
+					ToPort:     intValue(valueOr(rule["to_port"], 65535.0)),
+				})
+			}
+		}
+		for _, subnet := range builder.linkedSubnets(inst, "subnet_ids") {
+			subnet.NetworkACL = acl
+		}
+		builder.networkACLs[inst] = acl
+		builder.network.NetworkACLs = append(builder.network.NetworkACLs, acl)
+	}
+
+	for _, inst := range builder.resources.ofType("aws_network_acl_rule") {
+		rule := NetworkACLRule{
+			RuleNumber: intValue(inst.values["rule_number"]),
+			Egress:     inst.boolValue("egress"),
+			Allow:      inst.stringValue("rule_action") == "allow",
+			Protocol:   inst.stringValue("protocol"),
+			CIDRBlock:  inst.stringValue("cidr_block"),
+			FromPort:   intValue(inst.values["from_port"]),
+			ToPort:     intValue(inst.values["to_port"]),
+		}
+		for _, acl := range builder.linkedNetworkACLs(inst, "network_acl_id") {
+			acl.Rules = append(acl.Rules, rule)
+		}
+	}
+
+	for _, inst := range builder.resources.ofType("aws_network_acl_association") {
+		acls := builder.linkedNetworkACLs(inst, "network_acl_id")
+		if len(acls) == 0 {
+			continue
+		}
+		for _, subnet := range builder.linkedSubnets(inst, "subnet_id") {
+			subnet.NetworkACL = acls[0]
+		}
+	}
+
+	// Subnets that are not explicitly associated with a network ACL use the default network ACL of their VPC. If that
+	// is not managed in the plan, it allows all traffic, which is the same as leaving the network ACL unknown.
+	for _, subnet := range builder.network.Subnets {
+		if subnet.NetworkACL == nil && subnet.VPC != nil {
+			subnet.NetworkACL = subnet.VPC.DefaultNetworkACL
+		}
+	}
+}
+
+func (builder *networkBuilder) addSecurityGroups() {
+	groupInstances := builder.resources.ofType("aws_security_group", "aws_default_security_group")
+	for _, inst := range groupInstances {
+		group := &SecurityGroup{Address: inst.address, VPC: builder.linkedVPC(inst, "vpc_id")}
+		if inst.resourceType == "aws_default_security_group" && group.VPC != nil {
+			group.VPC.DefaultSecurityGroup = group
+		}
+		builder.securityGroups[inst] = group
+		builder.network.SecurityGroups = append(builder.network.SecurityGroups, group)
+	}
+
+	// Rules are added once all the groups exist, as they can refer to each other.
+	for _, inst := range groupInstances {
+		group := builder.securityGroups[inst]
+		for _, direction := range []string{"ingress", "egress"} {
+			for i, rule := range inst.blocks(direction) {
+				// Values that are not known until apply time are filled in to allow the most traffic.
+				groups, unknownGroups := builder.linkedSecurityGroups(inst, direction, i, "security_groups")
+				group.Rules = append(group.Rules, SecurityGroupRule{
+					Egress:                direction == "egress",
+					Protocol:              stringValue(valueOr(rule["protocol"], protocolAll)),
+					FromPort:              intValue(valueOr(rule["from_port"], 0.0)),
+					ToPort:                intValue(valueOr(rule["to_port"], 65535.0)),
+					CIDRBlocks:            stringListValue(valueOr(rule["cidr_blocks"], []interface{}{"0.0.0.0/0"})),
+					SecurityGroups:        groups,
+					Self:                  boolValue(valueOr(rule["self"], true)),
+					UnknownSecurityGroups: unknownGroups,
+				})
+			}
+		}
+	}
+
+	for _, inst := range builder.resources.ofType("aws_security_group_rule") {
+		sourceGroups, unknownSourceGroups := builder.linkedSecurityGroups(inst, "", 0, "source_security_group_id")
+		rule := SecurityGroupRule{
+			Egress:                inst.stringValue("type") == "egress",
+			Protocol:              inst.stringValue("protocol"),
+			FromPort:              intValue(inst.values["from_port"]),
+			ToPort:                intValue(inst.values["to_port"]),
+			CIDRBlocks:            stringListValue(inst.values["cidr_blocks"]),
+			SecurityGroups:        sourceGroups,
+			Self:                  inst.boolValue("self"),
+			UnknownSecurityGroups: unknownSourceGroups,
+		}
+		groups, _ := builder.linkedSecurityGroups(inst, "", 0, "security_group_id")
+		for _, group := range groups {
+			group.Rules = append(group.Rules, rule)
+		}
+	}
+
+	// VPCs always have a default security group, which allows inbound traffic from itself and all outbound traffic. If
+	// the plan does not manage it, we add one to the model with those rules.
+	for _, vpc := range builder.network.VPCs {
+		if vpc.DefaultSecurityGroup == nil {
+			group := &SecurityGroup{Address: vpc.Address + " (default security group)", VPC: vpc}
+			group.Rules = []SecurityGroupRule{
+				{Protocol: protocolAll, Self: true},
+				{Egress: true, Protocol: protocolAll, CIDRBlocks: []string{"0.0.0.0/0"}},
+			}
+			vpc.DefaultSecurityGroup = group
+			builder.network.SecurityGroups = append(builder.network.SecurityGroups, group)
+		}
+	}
+}
+
+func (builder *networkBuilder) addEndpoints() {
+	for _, inst := range builder.resources.ofType("aws_instance") {
+		endpoint := &Endpoint{Address: inst.address, Type: inst.resourceType}
+		endpoint.Subnets = builder.linkedSubnets(inst, "subnet_id")
+		endpoint.SecurityGroups = builder.endpointSecurityGroups(inst, endpoint.Subnets, "vpc_security_group_ids", "security_groups")
+		endpoint.PublicIP = publicIP(inst.values["associate_public_ip_address"], endpoint.Subnets)
+		builder.network.Endpoints = append(builder.network.Endpoints, endpoint)
+	}
+
+	for _, inst := range builder.resources.ofType("aws_lb") {
+		endpoint := &Endpoint{Address: inst.address, Type: inst.resourceType}
+		endpoint.Subnets = builder.linkedSubnets(inst, "subnets")
+		if inst.stringValue("load_balancer_type") != "network" {
+			endpoint.SecurityGroups = builder.endpointSecurityGroups(inst, endpoint.Subnets, "security_groups")
+		}
+		endpoint.PublicIP = !inst.boolValue("internal")
+		builder.network.Endpoints = append(builder.network.Endpoints, endpoint)
+	}
+
+	for _, inst := range builder.resources.ofType("aws_autoscaling_group") {
+		endpoint := &Endpoint{Address: inst.address, Type: inst.resourceType}
+		endpoint.Subnets = builder.linkedSubnets(inst, "vpc_zone_identifier")
+
+		var associatePublicIP interface{}
+		for _, launchConfiguration := range builder.resources.linked(inst, "", 0, "launch_configuration") {
+			endpoint.SecurityGroups = builder.endpointSecurityGroups(launchConfiguration, endpoint.Subnets, "security_groups")
+			associatePublicIP = launchConfiguration.values["associate_public_ip_address"]
+		}
+		for _, launchTemplate := range builder.resources.linked(inst, "launch_template", 0, "id") {
+			endpoint.SecurityGroups = builder.endpointSecurityGroups(launchTemplate, endpoint.Subnets, "vpc_security_group_ids")
+			for i, networkInterface := range launchTemplate.blocks("network_interfaces") {
+				if i == 0 {
+					associatePublicIP = networkInterface["associate_public_ip_address"]
+				}
+				groups, _ := builder.linkedSecurityGroups(launchTemplate, "network_interfaces", i, "security_groups")
+				endpoint.SecurityGroups = append(endpoint.SecurityGroups, groups...)
+			}
+		}
+		endpoint.PublicIP = publicIP(associatePublicIP, endpoint.Subnets)
+		builder.network.Endpoints = append(builder.network.Endpoints, endpoint)
+	}
+
+	for _, inst := range builder.resources.ofType("aws_db_instance") {
+		endpoint := &Endpoint{Address: inst.address, Type: inst.resourceType}
+		for _, subnetGroup := range builder.resources.linked(inst, "", 0, "db_subnet_group_name") {
+			endpoint.Subnets = append(endpoint.Subnets, builder.linkedSubnets(subnetGroup, "subnet_ids")...)
+		}
+		endpoint.SecurityGroups = builder.endpointSecurityGroups(inst, endpoint.Subnets, "vpc_security_group_ids")
+		endpoint.PublicIP = inst.boolValue("publicly_accessible")
+		builder.network.Endpoints = append(builder.network.Endpoints, endpoint)
+	}
+
+	for _, inst := range builder.resources.ofType("aws_ecs_service") {
+		if len(inst.blocks("network_configuration")) == 0 && !inst.hasNestedBlock("network_configuration") {
+			// Services that do not use awsvpc networking run on the network interfaces of the ECS cluster instances.
+			continue
+		}
+		endpoint := &Endpoint{Address: inst.address, Type: inst.resourceType}
+		endpoint.Subnets = builder.linkedSubnetsInBlock(inst, "network_configuration", "subnets")
+		groups, _ := builder.linkedSecurityGroups(inst, "network_configuration", 0, "security_groups")
+		endpoint.SecurityGroups = builder.defaultSecurityGroupsIfEmpty(groups, endpoint.Subnets)
+		for _, networkConfiguration := range inst.blocks("network_configuration") {
+			endpoint.PublicIP = boolValue(networkConfiguration["assign_public_ip"])
+		}
+		builder.network.Endpoints = append(builder.network.Endpoints, endpoint)
+	}
+}
+
+// endpointSecurityGroups returns the security groups in the given attributes of the endpoint resource. If the endpoint
+// does not have any security groups, AWS uses the default security group of the VPC.
+func (builder *networkBuilder) endpointSecurityGroups(inst *resourceInstance, subnets []*Subnet, attributes ...string) []*SecurityGroup {
+	groups := []*SecurityGroup{}
+	for _, attribute := range attributes {
+		linkedGroups, unknownGroups := builder.linkedSecurityGroups(inst, "", 0, attribute)
+		if unknownGroups {
+			// Leaving the security groups empty means traffic is not filtered.
+			return nil
+		}
+		groups = append(groups, linkedGroups...)
+	}
+	return builder.defaultSecurityGroupsIfEmpty(groups, subnets)
+}
+
+func (builder *networkBuilder) defaultSecurityGroupsIfEmpty(groups []*SecurityGroup, subnets []*Subnet) []*SecurityGroup {
+	if len(groups) > 0 {
+		return groups
+	}
+	for _, subnet := range subnets {
+		if subnet.VPC != nil && subnet.VPC.DefaultSecurityGroup != nil {
+			return []*SecurityGroup{subnet.VPC.DefaultSecurityGroup}
+		}
+	}
+	return nil
+}
+
+// publicIP returns whether an endpoint gets a public IP address, based on the given associate_public_ip_address value
+// of the resource, falling back to the setting of the subnets when it is not set.
+func publicIP(associatePublicIP interface{}, subnets []*Subnet) bool {
+	if associate, isBool := associatePublicIP.(bool); isBool {
+		return associate
+	}
+	if len(subnets) == 0 {
+		return true
+	}
+	for _, subnet := range subnets {
+		if subnet.MapPublicIPOnLaunch {
+			return true
+		}
+	}
+	return false
+}
+
+func (builder *networkBuilder) linkedVPC(inst *resourceInstance, attribute string) *VPC {
+	for _, linked := range builder.resources.linked(inst, "", 0, attribute) {
+		if vpc, hasVPC := builder.vpcs[linked]; hasVPC {
+			return vpc
+		}
+	}
+	return nil
+}
+
+func (builder *networkBuilder) linkedSubnets(inst *resourceInstance, attribute string) []*Subnet {
+	subnets := []*Subnet{}
+	for _, linked := range builder.resources.linked(inst, "", 0, attribute) {
+		if subnet, hasSubnet := builder.subnets[linked]; hasSubnet {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
+
+func (builder *networkBuilder) linkedSubnetsInBlock(inst *resourceInstance, block string, attribute string) []*Subnet {
+	subnets := []*Subnet{}
+	for _, linked := range builder.resources.linked(inst, block, 0, attribute) {
+		if subnet, hasSubnet := builder.subnets[linked]; hasSubnet {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
+
+func (builder *networkBuilder) linkedRouteTables(inst *resourceInstance, attribute string) []*RouteTable {
+	routeTables := []*RouteTable{}
+	for _, linked := range builder.resources.linked(inst, "", 0, attribute) {
+		if routeTable, hasRouteTable := builder.routeTables[linked]; hasRouteTable {
+			routeTables = append(routeTables, routeTable)
+		}
+		if vpc, hasVPC := builder.vpcs[linked]; hasVPC && vpc.MainRouteTable != nil {
+			// Refers to the main route table of the VPC, e.g. through aws_vpc.main.main_route_table_id.
+			routeTables = append(routeTables, vpc.MainRouteTable)
+		}
+	}
+	return routeTables
+}
+
+func (builder *networkBuilder) linkedNetworkACLs(inst *resourceInstance, attribute string) []*NetworkACL {
+	acls := []*NetworkACL{}
+	for _, linked := range builder.resources.linked(inst, "", 0, attribute) {
+		if acl, hasACL := builder.networkACLs[linked]; hasACL {
+			acls = append(acls, acl)
+		}
+		if vpc, hasVPC := builder.vpcs[linked]; hasVPC && vpc.DefaultNetworkACL != nil {
+			// Refers to the default network ACL of the VPC, e.g. through aws_vpc.main.default_network_acl_id.
+			acls = append(acls, vpc.DefaultNetworkACL)
+		}
+	}
+	return acls
+}
+
+// linkedSecurityGroups returns the security groups referred to by the given attribute. The second return value is true
+// if the attribute is set, but not all of the security groups it refers to could be determined.
+func (builder *networkBuilder) linkedSecurityGroups(inst *resourceInstance, block string, blockIndex int, attribute string) ([]*SecurityGroup, bool) {
+	groups := []*SecurityGroup{}
+	linked := builder.resources.linked(inst, block, blockIndex, attribute)
+	for _, linkedInst := range linked {
+		if group, hasGroup := builder.securityGroups[linkedInst]; hasGroup {
+			groups = append(groups, group)
+		}
+	}
+	unknown := len(groups) == 0 && builder.resources.isSet(inst, block, blockIndex, attribute)
+	return groups, unknown
+}
+
+// planResources indexes the managed resource instances in a plan, and resolves the links between them.
+type planResources struct {
+	plan      *tfjson.Plan
+	instances []*resourceInstance
+
+	// Instances indexed by their resource address with the module instance keys removed, which is also the address of
+	// the resource in the configuration.
+	byConfigAddress map[string][]*resourceInstance
+
+	// Instances indexed by their ID, for the resources where the ID is already known, such as existing resources.
+	byID map[string]*resourceInstance
+}
+
+// resourceInstance is a single instance of a managed resource in the plan.
+type resourceInstance struct {
+	address       string
+	module        string
+	configAddress string
+	resourceType  string
+	index         interface{}
+	values        map[string]interface{}
+	config        *tfjson.ConfigResource
+}
+
+func newPlanResources(plan *tfjson.Plan) *planResources {
+	resources := &planResources{
+		plan:            plan,
+		byConfigAddress: map[string][]*resourceInstance{},
+		byID:            map[string]*resourceInstance{},
+	}
+	resources.addModule(plan.PlannedValues.RootModule)
+	return resources
+}
+
+func (resources *planResources) addModule(module *tfjson.StateModule) {
+	configModule := resources.configModule(module.Address)
+	for _, resource := range module.Resources {
+		if resource.Mode != tfjson.ManagedResourceMode {
+			continue
+		}
+		inst := &resourceInstance{
+			address:       resource.Address,
+			module:        module.Address,
+			configAddress: joinAddress(stripInstanceKeys(module.Address), resource.Type+"."+resource.Name),
+			resourceType:  resource.Type,
+			index:         resource.Index,
+			values:        resource.AttributeValues,
+		}
+		if configModule != nil {
+			for _, configResource := range configModule.Resources {
+				if configResource.Mode == tfjson.ManagedResourceMode && configResource.Address == resource.Type+"."+resource.Name {
+					inst.config = configResource
+				}
+			}
+		}
+		resources.instances = append(resources.instances, inst)
+		resources.byConfigAddress[inst.configAddress] = append(resources.byConfigAddress[inst.configAddress], inst)
+		if id := inst.stringValue("id"); id != "" {
+			resources.byID[id] = inst
+		}
+	}
+	for _, child := range module.ChildModules {
+		resources.addModule(child)
+	}
+}
+
+// ofType returns all the instances of the given resource types, sorted by address so that the model is deterministic.
+func (resources *planResources) ofType(resourceTypes ...string) []*resourceInstance {
+	instances := []*resourceInstance{}
+	for _, inst := range resources.instances {
+		for _, resourceType := range resourceTypes {
+			if inst.resourceType == resourceType {
+				instances = append(instances, inst)
+			}
+		}
+	}
+	sort.SliceStable(instances, func(i, j int) bool { return instances[i].address < instances[j].address })
+	return instances
+}
+
+// linked returns the resource instances whose IDs are in the given attribute. IDs that are known in the plan are
+// looked up directly. IDs that won't be known until apply time are resolved by following the references in the
+// configuration. If block is set, the attribute is looked up in the nested block at the given index.
+func (resources *planResources) linked(inst *resourceInstance, block string, blockIndex int, attribute string) []*resourceInstance {
+	linked := []*resourceInstance{}
+	ids := stringListValue(inst.attributeValue(block, blockIndex, attribute))
+	for _, id := range ids {
+		if linkedInst, hasID := resources.byID[id]; hasID {
+			linked = append(linked, linkedInst)
+		}
+	}
+	if len(linked) > 0 {
+		return linked
+	}
+	// Some attributes hold names rather than IDs, and known IDs may belong to resources outside of the plan, so we also
+	// look at the references in these cases.
+	return resources.references(inst, block, blockIndex, attribute)
+}
+
+// isSet returns whether the given attribute has a value in the plan or an expression in the configuration.
+func (resources *planResources) isSet(inst *resourceInstance, block string, blockIndex int, attribute string) bool {
+	return len(stringListValue(inst.attributeValue(block, blockIndex, attribute))) > 0 ||
+		resources.hasExpression(inst, block, blockIndex, attribute)
+}
+
+// hasExpression returns whether the given attribute has an expression with references in the configuration.
+func (resources *planResources) hasExpression(inst *resourceInstance, block string, blockIndex int, attribute string) bool {
+	for _, expression := range inst.expressions(block, blockIndex, attribute) {
+		if len(expression.References) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// references returns the resource instances that the expression of the given attribute refers to.
+func (resources *planResources) references(inst *resourceInstance, block string, blockIndex int, attribute string) []*resourceInstance {
+	refs := []string{}
+	for _, expression := range inst.expressions(block, blockIndex, attribute) {
+		refs = append(refs, expression.References...)
+	}
+
+	// Expressions such as element(var.subnet_ids, count.index) pick the resource with the same index as this instance,
+	// so we pair them up when we can.
+	var pairIndex interface{}
+	for _, ref := range refs {
+		if ref == "count.index" || strings.HasPrefix(ref, "each.") {
+			pairIndex = inst.index
+		}
+	}
+	return resources.resolve(stripInstanceKeys(inst.module), refs, pairIndex, 0)
+}
+
+var (
+	varReferenceRegexp          = regexp.MustCompile(`^var\.([\w-]+)`)
+	moduleOutputReferenceRegexp = regexp.MustCompile(`^module\.([\w-]+)(?:\[[^\]]*\])?\.([\w-]+)`)
+	resourceReferenceRegexp     = regexp.MustCompile(`^([\w-]+\.[\w-]+)(?:\[([^\]]*)\])?`)
+)
+
+// Reference prefixes that do not refer to managed resources. Locals are not included in the JSON representation of
+// the configuration, so references through them can not be resolved.
+var nonResourceReferencePrefixes = []string{"count.", "each.", "path.", "terraform.", "local.", "data.", "self."}
+
+// resolve returns the resource instances that the given references, made from the given module, refer to. References
+// to input variables and module outputs are followed until they reach a resource.
+func (resources *planResources) resolve(module string, refs []string, pairIndex interface{}, depth int) []*resourceInstance {
+	if depth > maxReferenceDepth {
+		return nil
+	}
+
+	resolved := []*resourceInstance{}
+	// For each resource, the instance keys that are referred to. Terraform includes both the reference to a specific
+	// instance (aws_subnet.public[0]) and to the resource as a whole (aws_subnet.public), so the specific one wins.
+	resourceKeys := map[string][]string{}
+	resourceOrder := []string{}
+
+	for _, ref := range refs {
+		if hasAnyPrefix(ref, nonResourceReferencePrefixes) {
+			continue
+		}
+
+		if match := varReferenceRegexp.FindStringSubmatch(ref); match != nil {
+			parent, callName := splitModuleAddress(module)
+			if callName == "" {
+				// Input variables of the root module are set directly, so they do not refer to any resources.
+				continue
+			}
+			if call := resources.moduleCall(parent, callName); call != nil {
+				if expression, hasExpression := call.Expressions[match[1]]; hasExpression && expression.ExpressionData != nil {
+					resolved = append(resolved, resources.resolve(parent, expression.References, pairIndex, depth+1)...)
+				}
+			}
+			continue
+		}
+
+		if match := moduleOutputReferenceRegexp.FindStringSubmatch(ref); match != nil {
+			child := joinAddress(module, "module."+match[1])
+			if childConfig := resources.configModule(child); childConfig != nil {
+				if output, hasOutput := childConfig.Outputs[match[2]]; hasOutput && output.Expression != nil && output.Expression.ExpressionData != nil {
+					resolved = append(resolved, resources.resolve(child, output.Expression.References, pairIndex, depth+1)...)
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(ref, "var.") || strings.HasPrefix(ref, "module.") {
+			continue
+		}
+		if match := resourceReferenceRegexp.FindStringSubmatch(ref); match != nil {
+			address := joinAddress(module, match[1])
+			if _, seen := resourceKeys[address]; !seen {
+				resourceOrder = append(resourceOrder, address)
+				resourceKeys[address] = []string{}
+			}
+			key := strings.Trim(match[2], `"`)
+			if key != "" && key != "count.index" && !strings.HasPrefix(key, "each.") {
+				resourceKeys[address] = append(resourceKeys[address], key)
+			}
+		}
+	}
+
+	for _, address := range resourceOrder {
+		instances := resources.byConfigAddress[address]
+		keys := resourceKeys[address]
+		if len(keys) == 0 && pairIndex != nil {
+			for _, inst := range instances {
+				if fmt.Sprint(inst.index) == fmt.Sprint(pairIndex) {
+					keys = []string{fmt.Sprint(pairIndex)}
+				}
+			}
+		}
+		for _, inst := range instances {
+			if len(keys) == 0 || containsString(keys, fmt.Sprint(inst.index)) {
+				resolved = append(resolved, inst)
+			}
+		}
+	}
+	return dedupInstances(resolved)
+}
+
+// configModule returns the configuration of the module at the given address, or nil if it is not in the plan.
+func (resources *planResources) configModule(address string) *tfjson.ConfigModule {
+	if resources.plan.Config == nil {
+		return nil
+	}
+	module := resources.plan.Config.RootModule
+	address = stripInstanceKeys(address)
+	if address == "" {
+		return module
+	}
+	parts := strings.Split(address, ".")
+	for i := 0; i+1 < len(parts); i += 2 {
+		if module == nil {
+			return nil
+		}
+		call, hasCall := module.ModuleCalls[parts[i+1]]
+		if !hasCall {
+			return nil
+		}
+		module = call.Module
+	}
+	return module
+}
+
+// moduleCall returns the configuration of the call to the given child module in the module at the given address.
+func (resources *planResources) moduleCall(address string, name string) *tfjson.ModuleCall {
+	module := resources.configModule(address)
+	if module == nil {
+		return nil
+	}
+	return module.ModuleCalls[name]
+}
+
+// attributeValue returns the value of the given attribute in the plan, looking in the nested block at the given index
+// if block is set.
+func (inst *resourceInstance) attributeValue(block string, blockIndex int, attribute string) interface{} {
+	if block == "" {
+		return inst.values[attribute]
+	}
+	blocks := inst.blocks(block)
+	if blockIndex >= len(blocks) {
+		return nil
+	}
+	return blocks[blockIndex][attribute]
+}
+
+// expressions returns the expressions of the given attribute in the configuration. For nested blocks, this returns
+// the expressions of all the blocks, as the order of blocks in the plan does not necessarily match the configuration.
+func (inst *resourceInstance) expressions(block string, blockIndex int, attribute string) []*tfjson.Expression {
+	if inst.config == nil {
+		return nil
+	}
+	if block == "" {
+		if expression, hasExpression := inst.config.Expressions[attribute]; hasExpression && expression != nil && expression.ExpressionData != nil {
+			return []*tfjson.Expression{expression}
+		}
+		return nil
+	}
+
+	blockExpression, hasBlock := inst.config.Expressions[block]
+	if !hasBlock || blockExpression == nil || blockExpression.ExpressionData == nil {
+		return nil
+	}
+	expressions := []*tfjson.Expression{}
+	for _, nested := range blockExpression.NestedBlocks {
+		if expression, hasExpression := nested[attribute]; hasExpression && expression != nil && expression.ExpressionData != nil {
+			expressions = append(expressions, expression)
+		}
+	}
+	return expressions
+}
+
+// hasNestedBlock returns whether the configuration of the resource has the given nested block.
+func (inst *resourceInstance) hasNestedBlock(block string) bool {
+	if inst.config == nil {
+		return false
+	}
+	expression, hasBlock := inst.config.Expressions[block]
+	return hasBlock && expression != nil && expression.ExpressionData != nil && len(expression.NestedBlocks) > 0
+}
+
+// unknownValue marks a value in a nested block of the configuration that is not known until apply time.
+type unknownValue struct{}
+
+// valueOr returns the given value, or the fallback if the value is not known until apply time.
+func valueOr(value interface{}, fallback interface{}) interface{} {
+	if _, isUnknown := value.(unknownValue); isUnknown {
+		return fallback
+	}
+	return value
+}
+
+// blocks returns the nested blocks with the given name in the plan. If the blocks are not known until apply time,
+// which happens when a set of blocks contains an unknown value, this falls back to the blocks in the configuration,
+// with the values that are not constant set to unknownValue.
+func (inst *resourceInstance) blocks(block string) []map[string]interface{} {
+	list, isList := inst.values[block].([]interface{})
+	if !isList {
+		return inst.configBlocks(block)
+	}
+	blocks := []map[string]interface{}{}
+	for _, item := range list {
+		if values, isMap := item.(map[string]interface{}); isMap {
+			blocks = append(blocks, values)
+		}
+	}
+	return blocks
+}
+
+func (inst *resourceInstance) configBlocks(block string) []map[string]interface{} {
+	if !inst.hasNestedBlock(block) {
+		return nil
+	}
+	blocks := []map[string]interface{}{}
+	for _, nested := range inst.config.Expressions[block].NestedBlocks {
+		values := map[string]interface{}{}
+		for attribute, expression := range nested {
+			if expression == nil || expression.ExpressionData == nil {
+				continue
+			}
+			if len(expression.References) > 0 || expression.ConstantValue == tfjson.UnknownConstantValue {
+				values[attribute] = unknownValue{}
+			} else {
+				values[attribute] = expression.ConstantValue
+			}
+		}
+		blocks = append(blocks, values)
+	}
+	return blocks
+}
+
+func (inst *resourceInstance) stringValue(attribute string) string {
+	return stringValue(inst.values[attribute])
+}
+
+func (inst *resourceInstance) boolValue(attribute string) bool {
+	return boolValue(inst.values[attribute])
+}
+
+func stringValue(value interface{}) string {
+	if str, isString := value.(string); isString {
+		return str
+	}
+	return ""
+}
+
+func boolValue(value interface{}) bool {
+	if b, isBool := value.(bool); isBool {
+		return b
+	}
+	return false
+}
+
+// intValue converts a number from the plan to an int. Terraform represents some ports and rule numbers as strings.
+func intValue(value interface{}) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case string:
+		var i int
+		if _, err := fmt.Sscanf(v, "%d", &i); err == nil {
+			return i
+		}
+	}
+	return 0
+}
+
+// stringListValue returns the non empty strings in the given value, which can be a single string or a list.
+func stringListValue(value interface{}) []string {
+	if str := stringValue(value); str != "" {
+		return []string{str}
+	}
+	list, isList := value.([]interface{})
+	if !isList {
+		return nil
+	}
+	strs := []string{}
+	for _, item := range list {
+		if str := stringValue(item); str != "" {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
+
+var instanceKeyRegexp = regexp.MustCompile(`\[[^\]]*\]`)
+
+// stripInstanceKeys removes the count and for_each keys from a module address, turning module.vpc[0].module.acls into
+// module.vpc.module.acls.
+func stripInstanceKeys(address string) string {
+	return instanceKeyRegexp.ReplaceAllString(address, "")
+}
+
+// splitModuleAddress splits a module address into the address of the parent module and the name of the module call,
+// e.g. module.vpc.module.acls into module.vpc and acls.
+func splitModuleAddress(address string) (string, string) {
+	parts := strings.Split(address, ".")
+	if len(parts) < 2 {
+		return "", ""
+	}
+	return strings.Join(parts[:len(parts)-2], "."), parts[len(parts)-1]
+}
+
+func joinAddress(module string, address string) string {
+	if module == "" {
+		return address
+	}
+	return module + "." + address
+}
+
+func hasAnyPrefix(str string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(str, prefix) || str == strings.TrimSuffix(prefix, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func dedupInstances(instances []*resourceInstance) []*resourceInstance {
+	seen := map[*resourceInstance]bool{}
+	deduped := []*resourceInstance{}
+	for _, inst := range instances {
+		if !seen[inst] {
+			seen[inst] = true
+			deduped = append(deduped, inst)
+		}
+	}
+	return deduped
+}
@@ -0,0 +1,165 @@
+package networking
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The key algorithm of the certificates ACM issues for our modules, as we never request a different one.
+const expectedACMKeyAlgorithm = acm.KeyAlgorithmRsa2048
+
+// verifyRoute53Certificates checks the ACM certificate that the route53 module requests for each public zone that has
+// provision_certificates enabled (the default). The certificate must cover exactly the domain and its
+// subject_alternative_names.
+func verifyRoute53Certificates(t *testing.T, awsRegion string, publicZones interface{}) {
+	for domain, input := range parseRoute53ZoneInputs(t, publicZones) {
+		if !boolWithDefault(input.ProvisionCertificates, true) {
+			continue
+		}
+		certificateArn := aws.GetAcmCertificateArn(t, awsRegion, domain)
+		certificate := waitForACMCertificateIssued(t, awsRegion, certificateArn)
+		verifyACMCertificate(t, certificate)
+		assert.ElementsMatch(
+			t,
+			append([]string{domain}, input.SubjectAlternativeNames...),
+			awsgo.StringValueSlice(certificate.SubjectAlternativeNames),
+			"Subject alternative names of certificate %s",
+			certificateArn,
+		)
+	}
+}
+
+// getListenerCertificateArn returns the ARN of the default certificate of the given HTTPS listener.
+func getListenerCertificateArn(t *testing.T, awsRegion string, listenerArn string) string {
+	sess, err := aws.NewAuthenticatedSession(awsRegion)
+	require.NoError(t, err)
+	client := elbv2.New(sess)
+
+	output, err := client.DescribeListeners(&elbv2.DescribeListenersInput{ListenerArns: awsgo.StringSlice([]string{listenerArn})})
+	require.NoError(t, err)
+	require.Len(t, output.Listeners, 1)
+	require.NotEmpty(t, output.Listeners[0].Certificates, "Listener %s has no certificates", listenerArn)
+	return awsgo.StringValue(output.Listeners[0].Certificates[0].CertificateArn)
+}
+
+// waitForACMCertificateIssued returns the details of the given certificate once ACM reports it as ISSUED. The modules
+// wait for DNS validation to complete, but ACM can take a little longer to update the status of the certificate.
+func waitForACMCertificateIssued(t *testing.T, awsRegion string, certificateArn string) *acm.CertificateDetail {
+	client := newACMClient(t, awsRegion)
+	certificate, err := retry.DoWithRetryInterfaceE(
+		t,
+		fmt.Sprintf("Wait for ACM certificate %s to be issued", certificateArn),
+		30,
+		10*time.Second,
+		func() (interface{}, error) {
+			output, err := client.DescribeCertificate(&acm.DescribeCertificateInput{CertificateArn: awsgo.String(certificateArn)})
+			if err != nil {
+				return nil, err
+			}
+			status := awsgo.StringValue(output.Certificate.Status)
+			if status != acm.CertificateStatusIssued {
+				return nil, fmt.Errorf("Certificate %s has status %s", certificateArn, status)
+			}
+			return output.Certificate, nil
+		},
+	)
+	require.NoError(t, err)
+	return certificate.(*acm.CertificateDetail)
+}
+
+// verifyACMCertificate checks that the given certificate was validated through DNS, for every domain it covers, and
+// uses the expected key algorithm.
+func verifyACMCertificate(t *testing.T, certificate *acm.CertificateDetail) {
+	certificateArn := awsgo.StringValue(certificate.CertificateArn)
+	assert.Equal(t, expectedACMKeyAlgorithm, awsgo.StringValue(certificate.KeyAlgorithm), "Key algorithm of certificate %s", certificateArn)
+	require.NotEmpty(t, certificate.DomainValidationOptions, "Certificate %s has no domain validation options", certificateArn)
+	for _, option := range certificate.DomainValidationOptions {
+		assert.Equal(
+			t,
+			acm.ValidationMethodDns,
+			awsgo.StringValue(option.ValidationMethod),
+			"Validation method for %s in certificate %s",
+			awsgo.StringValue(option.DomainName),
+			certificateArn,
+		)
+	}
+}
+
+// verifyServedCertificateChain connects to the given address over TLS, using serverName for SNI and host name
+// verification, and checks that the server presents the given ACM certificate, along with intermediates from the
+// chain ACM issued with it.
+func verifyServedCertificateChain(t *testing.T, awsRegion string, address string, serverName string, certificateArn string) {
+	output, err := newACMClient(t, awsRegion).GetCertificate(&acm.GetCertificateInput{CertificateArn: awsgo.String(certificateArn)})
+	require.NoError(t, err)
+	expectedLeaf := decodePEMCertificates(t, awsgo.StringValue(output.Certificate))
+	require.Len(t, expectedLeaf, 1)
+	expectedChain := decodePEMCertificates(t, awsgo.StringValue(output.CertificateChain))
+
+	servedChain, err := retry.DoWithRetryInterfaceE(
+		t,
+		fmt.Sprintf("TLS handshake with %s as %s", address, serverName),
+		30,
+		10*time.Second,
+		func() (interface{}, error) {
+			dialer := &net.Dialer{Timeout: 10 * time.Second}
+			conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: serverName})
+			if err != nil {
+				return nil, err
+			}
+			defer conn.Close()
+			return conn.ConnectionState().PeerCertificates, nil
+		},
+	)
+	require.NoError(t, err)
+	peerCertificates := servedChain.([]*x509.Certificate)
+
+	require.NotEmpty(t, peerCertificates)
+	assert.True(t, bytes.Equal(expectedLeaf[0].Raw, peerCertificates[0].Raw), "%s served certificate with serial %s instead of %s", address, peerCertificates[0].SerialNumber, certificateArn)
+	for _, intermediate := range peerCertificates[1:] {
+		assert.True(t, containsCertificate(expectedChain, intermediate), "%s served intermediate %s, which is not in the chain of %s", address, intermediate.Subject, certificateArn)
+	}
+}
+
+func newACMClient(t *testing.T, awsRegion string) *acm.ACM {
+	sess, err := aws.NewAuthenticatedSession(awsRegion)
+	require.NoError(t, err)
+	return acm.New(sess)
+}
+
+func decodePEMCertificates(t *testing.T, pemData string) []*x509.Certificate {
+	certificates := []*x509.Certificate{}
+	rest := []byte(pemData)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certificates
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		certificates = append(certificates, certificate)
+	}
+}
+
+func containsCertificate(certificates []*x509.Certificate, certificate *x509.Certificate) bool {
+	for _, candidate := range certificates {
+		if bytes.Equal(candidate.Raw, certificate.Raw) {
+			return true
+		}
+	}
+	return false
+}
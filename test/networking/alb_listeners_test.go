@@ -0,0 +1,258 @@
+package networking
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gruntwork-io/aws-service-catalog/test/alblogs"
+)
+
+// The TLS versions we probe the HTTPS listeners with, keyed by the protocol names the ELB SSL policies use.
+var albTLSVersions = map[string]uint16{
+	"TLSv1":   tls.VersionTLS10,
+	"TLSv1.1": tls.VersionTLS11,
+	"TLSv1.2": tls.VersionTLS12,
+	"TLSv1.3": tls.VersionTLS13,
+}
+
+// The TLS 1.0 - 1.2 cipher suites we probe the HTTPS listeners with, keyed by the OpenSSL names the ELB SSL policies
+// use. Our listeners serve RSA certificates, so only the suites that work with RSA keys are listed.
+var albCipherSuites = map[string]uint16{
+	"ECDHE-RSA-AES128-GCM-SHA256": tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"ECDHE-RSA-AES256-GCM-SHA384": tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-RSA-AES128-SHA256":     tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"ECDHE-RSA-AES128-SHA":        tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"ECDHE-RSA-AES256-SHA":        tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"ECDHE-RSA-DES-CBC3-SHA":      tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"ECDHE-RSA-CHACHA20-POLY1305": tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"AES128-GCM-SHA256":           tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"AES256-GCM-SHA384":           tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"AES128-SHA256":               tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"AES128-SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"AES256-SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"DES-CBC3-SHA":                tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+}
+
+// verifyHTTPRedirectsToHTTPS checks that plain HTTP requests to the given ALB address are redirected to the same URL
+// over HTTPS, with the given status code. The request is sent with serverName in the Host header, so that it doesn't
+// depend on DNS propagation.
+func verifyHTTPRedirectsToHTTPS(t *testing.T, albDNSName string, serverName string, expectedStatusCode int) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		// Don't follow the redirect, as we want to check the redirect itself
+		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	path := "/redirect-test?foo=bar"
+	expectedLocation := fmt.Sprintf("https://%s:443%s", serverName, path)
+
+	_, err := retry.DoWithRetryE(
+		t,
+		fmt.Sprintf("Check that http://%s%s redirects to HTTPS", albDNSName, path),
+		30,
+		5*time.Second,
+		func() (string, error) {
+			request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s%s", albDNSName, path), nil)
+			if err != nil {
+				return "", err
+			}
+			request.Host = serverName
+			response, err := client.Do(request)
+			if err != nil {
+				return "", err
+			}
+			defer response.Body.Close()
+
+			location := response.Header.Get("Location")
+			if response.StatusCode != expectedStatusCode || location != expectedLocation {
+				return "", fmt.Errorf("Expected a %d redirect to %s, but got status %d with location %q", expectedStatusCode, expectedLocation, response.StatusCode, location)
+			}
+			return "", nil
+		},
+	)
+	require.NoError(t, err)
+}
+
+// verifyDefaultActionFixedResponse checks that HTTPS requests that don't match any listener rule get the fixed response
+// of the default action. The request uses the AWS-managed name of the ALB as the Host, which none of our rules match,
+// but sends serverName for SNI, so that the certificate can still be verified.
+func verifyDefaultActionFixedResponse(t *testing.T, albDNSName string, serverName string, expectedStatusCode int, expectedBody string) {
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{ServerName: serverName}},
+	}
+
+	_, err := retry.DoWithRetryE(
+		t,
+		fmt.Sprintf("Check the fixed response of the default action of https://%s", albDNSName),
+		30,
+		5*time.Second,
+		func() (string, error) {
+			response, err := client.Get(fmt.Sprintf("https://%s/no-rule-matches-this", albDNSName))
+			if err != nil {
+				return "", err
+			}
+			defer response.Body.Close()
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				return "", err
+			}
+			if response.StatusCode != expectedStatusCode || string(body) != expectedBody {
+				return "", fmt.Errorf("Expected status %d with body %q, but got status %d with body %q", expectedStatusCode, expectedBody, response.StatusCode, body)
+			}
+			return "", nil
+		},
+	)
+	require.NoError(t, err)
+}
+
+// verifyListenerTLSPolicy checks that the HTTPS listener at the given address negotiates exactly the TLS versions and
+// cipher suites that the given ELB SSL policy allows. Each version and cipher suite is probed with a separate
+// handshake that only offers that version or suite.
+func verifyListenerTLSPolicy(t *testing.T, awsRegion string, address string, serverName string, sslPolicyName string) {
+	sess, err := aws.NewAuthenticatedSession(awsRegion)
+	require.NoError(t, err)
+	output, err := elbv2.New(sess).DescribeSSLPolicies(&elbv2.DescribeSSLPoliciesInput{Names: awsgo.StringSlice([]string{sslPolicyName})})
+	require.NoError(t, err)
+	require.Len(t, output.SslPolicies, 1)
+	policy := output.SslPolicies[0]
+
+	allowedProtocols := map[string]bool{}
+	for _, protocol := range policy.SslProtocols {
+		allowedProtocols[awsgo.StringValue(protocol)] = true
+	}
+	allowedCiphers := map[string]bool{}
+	for _, cipher := range policy.Ciphers {
+		allowedCiphers[awsgo.StringValue(cipher.Name)] = true
+	}
+
+	for protocol, version := range albTLSVersions {
+		config := &tls.Config{ServerName: serverName, MinVersion: version, MaxVersion: version}
+		err := tlsHandshake(address, config)
+		if allowedProtocols[protocol] {
+			assert.NoError(t, err, "%s should be allowed by %s", protocol, sslPolicyName)
+		} else {
+			assert.Error(t, err, "%s should not be allowed by %s", protocol, sslPolicyName)
+		}
+	}
+
+	// Cipher suites can only be chosen for TLS 1.2 and below, so probe each of them with the highest of those versions
+	// that the policy allows.
+	var maxVersion uint16
+	for protocol, version := range albTLSVersions {
+		if allowedProtocols[protocol] && version > maxVersion && version <= tls.VersionTLS12 {
+			maxVersion = version
+		}
+	}
+	require.NotZero(t, maxVersion, "%s does not allow any of the TLS versions we probe", sslPolicyName)
+
+	for cipher, suite := range albCipherSuites {
+		config := &tls.Config{
+			ServerName:   serverName,
+			MinVersion:   tls.VersionTLS10,
+			MaxVersion:   maxVersion,
+			CipherSuites: []uint16{suite},
+		}
+		err := tlsHandshake(address, config)
+		if allowedCiphers[cipher] && cipherSupportsVersion(suite, maxVersion) {
+			assert.NoError(t, err, "%s should be allowed by %s", cipher, sslPolicyName)
+		} else if !allowedCiphers[cipher] {
+			assert.Error(t, err, "%s should not be allowed by %s", cipher, sslPolicyName)
+		}
+	}
+}
+
+// verifyAccessLogs waits for the ALB to write access logs to the given bucket until isComplete returns nil for the
+// entries, and checks that every entry in them parses as a valid ALB access log entry for the given ALB. The ALB
+// delivers logs every 5 minutes, so this can take a while.
+func verifyAccessLogs(t *testing.T, awsRegion string, bucket string, albName string, isComplete func(entries []alblogs.Entry) error) []alblogs.Entry {
+	client := aws.NewS3Client(t, awsRegion)
+
+	entries, err := retry.DoWithRetryInterfaceE(
+		t,
+		fmt.Sprintf("Wait for access logs in bucket %s", bucket),
+		20,
+		30*time.Second,
+		func() (interface{}, error) {
+			keys := []string{}
+			err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: awsgo.String(bucket)}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+				for _, object := range page.Contents {
+					// The ALB also writes a test file when logging is enabled, which is not a log file
+					if strings.HasSuffix(awsgo.StringValue(object.Key), ".log.gz") {
+						keys = append(keys, awsgo.StringValue(object.Key))
+					}
+				}
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			entries := []alblogs.Entry{}
+			for _, key := range keys {
+				output, err := client.GetObject(&s3.GetObjectInput{Bucket: awsgo.String(bucket), Key: awsgo.String(key)})
+				if err != nil {
+					return nil, err
+				}
+				fileEntries, err := alblogs.ParseFile(output.Body)
+				output.Body.Close()
+				if err != nil {
+					// Waiting won't fix a malformed log file, so stop retrying
+					return nil, retry.FatalError{Underlying: fmt.Errorf("Invalid access log file %s: %s", key, err)}
+				}
+				entries = append(entries, fileEntries...)
+			}
+			if len(entries) == 0 {
+				return nil, fmt.Errorf("No access log entries in bucket %s yet", bucket)
+			}
+			if err := isComplete(entries); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		},
+	)
+	require.NoError(t, err)
+
+	for _, entry := range entries.([]alblogs.Entry) {
+		assert.True(t, strings.HasPrefix(entry.LoadBalancer, fmt.Sprintf("app/%s/", albName)), "Access log entry for unexpected load balancer %s", entry.LoadBalancer)
+	}
+	return entries.([]alblogs.Entry)
+}
+
+func tlsHandshake(address string, config *tls.Config) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, config)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// cipherSupportsVersion returns false for the cipher suites that can't be negotiated at the given TLS version: the
+// SHA-256 and GCM suites were introduced with TLS 1.2.
+func cipherSupportsVersion(suite uint16, version uint16) bool {
+	switch suite {
+	case tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA256:
+		return version >= tls.VersionTLS12
+	}
+	return true
+}
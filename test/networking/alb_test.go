@@ -2,21 +2,21 @@ package networking
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/gruntwork-io/aws-service-catalog/test"
+	"github.com/gruntwork-io/aws-service-catalog/test/alblogs"
 
 	awsgo "github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/require"
 )
 
 func TestAlb(t *testing.T) {
@@ -27,6 +27,8 @@ func TestAlb(t *testing.T) {
 	//os.Setenv("SKIP_setup", "true")
 	//os.Setenv("SKIP_deploy_terraform", "true")
 	//os.Setenv("SKIP_validate_server", "true")
+	//os.Setenv("SKIP_validate_certificate", "true")
+	//os.Setenv("SKIP_validate_listeners", "true")
 	//os.Setenv("SKIP_validate_access_logs", "true")
 	//os.Setenv("SKIP_cleanup", "true")
 
@@ -49,6 +51,10 @@ func TestAlb(t *testing.T) {
 		terraformOptions.Vars["base_domain_name"] = test.BaseDomainForTest
 		terraformOptions.Vars["alb_subdomain"] = name
 		terraformOptions.Vars["base_domain_name_tags"] = test.DomainNameTagsForTest
+		terraformOptions.Vars["ssl_policy"] = "ELBSecurityPolicy-TLS-1-2-2017-01"
+		terraformOptions.Vars["http_to_https_redirect_status_code"] = "HTTP_301"
+		terraformOptions.Vars["default_action_body"] = fmt.Sprintf("No rule matched on %s", name)
+		terraformOptions.Vars["default_action_status_code"] = 404
 
 		test_structure.SaveTerraformOptions(t, testFolder, terraformOptions)
 	})
@@ -77,18 +83,66 @@ func TestAlb(t *testing.T) {
 		}
 	})
 
-	test_structure.RunTestStage(t, "validate_access_logs", func() {
+	test_structure.RunTestStage(t, "validate_certificate", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
-		accessLogsBucket := terraform.OutputRequired(t, terraformOptions, "alb_access_logs_bucket")
 		region := test_structure.LoadString(t, testFolder, "region")
+		albName := terraformOptions.Vars["alb_name"].(string)
+		listenerArns := terraform.OutputMap(t, terraformOptions, "https_listener_acm_cert_arns")
+		originalAlbDNSName := terraform.Output(t, terraformOptions, "original_alb_dns_name")
+
+		// The ALB looks up the certificate by the tls_domain_name configured in the example.
+		certificateArn := getListenerCertificateArn(t, region, listenerArns["443"])
+		certificate := waitForACMCertificateIssued(t, region, certificateArn)
+		verifyACMCertificate(t, certificate)
+		assert.Contains(t, awsgo.StringValueSlice(certificate.SubjectAlternativeNames), test.AcmDomainForTest)
+
+		// Connect to the AWS-managed name of the ALB, so that we don't have to wait for our record to propagate
+		// through DNS, but send our domain for SNI.
+		serverName := fmt.Sprintf("%s.%s", albName, test.BaseDomainForTest)
+		verifyServedCertificateChain(t, region, originalAlbDNSName+":443", serverName, certificateArn)
+	})
 
-		client := aws.NewS3Client(t, region)
-
-		output, err := client.ListObjects(&s3.ListObjectsInput{Bucket: awsgo.String(accessLogsBucket)})
-
-		require.NoError(t, err)
+	test_structure.RunTestStage(t, "validate_listeners", func() {
+		terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
+		region := test_structure.LoadString(t, testFolder, "region")
+		albName := terraformOptions.Vars["alb_name"].(string)
+		originalAlbDNSName := terraform.Output(t, terraformOptions, "original_alb_dns_name")
+
+		// Talk to the AWS-managed name of the ALB, so that we don't depend on DNS propagation of our record, but use
+		// our domain for the Host header and SNI, as that is what the listener rules and certificate are set up for.
+		serverName := fmt.Sprintf("%s.%s", albName, test.BaseDomainForTest)
+
+		verifyHTTPRedirectsToHTTPS(t, originalAlbDNSName, serverName, http.StatusMovedPermanently)
+		verifyDefaultActionFixedResponse(
+			t,
+			originalAlbDNSName,
+			serverName,
+			int(terraformOptions.Vars["default_action_status_code"].(float64)),
+			terraformOptions.Vars["default_action_body"].(string),
+		)
+		verifyListenerTLSPolicy(t, region, originalAlbDNSName+":443", serverName, terraformOptions.Vars["ssl_policy"].(string))
+	})
 
-		assert.Greater(t, len(output.Contents), 0)
+	test_structure.RunTestStage(t, "validate_access_logs", func() {
+		terraformOptions := test_structure.LoadTerraformOptions(t, testFolder)
+		accessLogsBucket := terraform.OutputRequired(t, terraformOptions, "alb_access_logs_bucket")
+		region := test_structure.LoadString(t, testFolder, "region")
+		albName := terraformOptions.Vars["alb_name"].(string)
+
+		// The earlier stages sent both HTTPS requests and HTTP requests that got redirected, so we expect to find both
+		// kinds in the logs.
+		verifyAccessLogs(t, region, accessLogsBucket, albName, func(entries []alblogs.Entry) error {
+			foundHTTPS := false
+			foundRedirect := false
+			for _, entry := range entries {
+				foundHTTPS = foundHTTPS || (entry.Type == "https" && entry.SSLProtocol != "-")
+				foundRedirect = foundRedirect || (entry.Type == "http" && entry.ELBStatusCode == http.StatusMovedPermanently)
+			}
+			if !foundHTTPS || !foundRedirect {
+				return fmt.Errorf("Found HTTPS requests: %t, found redirected HTTP requests: %t", foundHTTPS, foundRedirect)
+			}
+			return nil
+		})
 	})
 }
 
@@ -0,0 +1,170 @@
+package networking
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/aws-service-catalog/test"
+	"github.com/gruntwork-io/aws-service-catalog/test/netreach"
+
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/random"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests in this file only run terraform plan, and check the network isolation of the examples using an offline
+// model of the network in the plan. See the netreach package for details.
+
+func TestVpcNetworkIsolation(t *testing.T) {
+	t.Parallel()
+
+	awsRegion := aws.GetRandomRegion(t, test.RegionsForEc2Tests, nil)
+	port := 8080
+
+	// Plan a copy of the example, as the tests that deploy it apply in the example folder in parallel.
+	testFolder := test_structure.CopyTerraformFolderToTemp(t, "../../", "examples/for-learning-and-testing/networking/vpc")
+	terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, awsRegion)
+	terraformOptions.Vars["vpc_name"] = "vpc-reach-" + random.UniqueId()
+	terraformOptions.Vars["sg_ingress_port"] = port
+
+	network, plan := netreach.InitAndPlan(t, terraformOptions)
+
+	publicSubnets := network.SubnetsInCIDRBlocks(netreach.PlannedOutputStrings(t, plan, "public_subnet_cidr_blocks"))
+	appSubnets := network.SubnetsInCIDRBlocks(netreach.PlannedOutputStrings(t, plan, "private_app_subnet_cidr_blocks"))
+	persistenceSubnets := network.SubnetsInCIDRBlocks(netreach.PlannedOutputStrings(t, plan, "private_persistence_subnet_cidr_blocks"))
+	require.NotEmpty(t, publicSubnets)
+	require.NotEmpty(t, appSubnets)
+	require.NotEmpty(t, persistenceSubnets)
+
+	// The example instance is the only thing that should be exposed to the internet, and only on its port.
+	assertEndpointsReachableFromInternet(t, network, port, "aws_instance.example")
+	assertEndpointsReachableFromInternet(t, network, 22)
+
+	for _, subnet := range appSubnets {
+		assertNotReachable(t, network.CanReach(netreach.Internet(), subnet, "tcp", port), "internet", subnet.Address)
+	}
+
+	// Only the private app subnets can talk to the persistence subnets.
+	for _, persistenceSubnet := range persistenceSubnets {
+		assertNotReachable(t, network.CanReach(netreach.Internet(), persistenceSubnet, "tcp", 5432), "internet", persistenceSubnet.Address)
+		for _, appSubnet := range appSubnets {
+			assertReachable(t, network.CanReach(appSubnet, persistenceSubnet, "tcp", 5432), appSubnet.Address, persistenceSubnet.Address)
+		}
+		for _, publicSubnet := range publicSubnets {
+			assertNotReachable(t, network.CanReach(publicSubnet, persistenceSubnet, "tcp", 5432), publicSubnet.Address, persistenceSubnet.Address)
+		}
+	}
+}
+
+func TestVpcMgmtNetworkIsolation(t *testing.T) {
+	t.Parallel()
+
+	awsRegion := aws.GetRandomRegion(t, test.RegionsForEc2Tests, nil)
+	port := 8080
+
+	// Plan a copy of the example, as the tests that deploy it apply in the example folder in parallel.
+	testFolder := test_structure.CopyTerraformFolderToTemp(t, "../../", "examples/for-learning-and-testing/networking/vpc-mgmt")
+	terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, awsRegion)
+	terraformOptions.Vars["vpc_name"] = "vpc-mgmt-reach-" + random.UniqueId()
+	terraformOptions.Vars["sg_ingress_port"] = port
+
+	network, plan := netreach.InitAndPlan(t, terraformOptions)
+
+	privateSubnets := network.SubnetsInCIDRBlocks(netreach.PlannedOutputStrings(t, plan, "private_subnet_cidr_blocks"))
+	require.NotEmpty(t, privateSubnets)
+	for _, subnet := range privateSubnets {
+		assertNotReachable(t, network.CanReach(netreach.Internet(), subnet, "tcp", port), "internet", subnet.Address)
+	}
+
+	assertEndpointsReachableFromInternet(t, network, port, "aws_instance.example")
+	assertEndpointsReachableFromInternet(t, network, 22)
+
+	// SSH access is only opened up when a Key Pair is passed in. The plan does not check that the Key Pair exists.
+	terraformOptions.Vars["keypair_name"] = "vpc-mgmt-reach-test"
+	networkWithSSH, _ := netreach.InitAndPlan(t, terraformOptions)
+	assertEndpointsReachableFromInternet(t, networkWithSSH, 22, "aws_instance.example")
+}
+
+func TestAlbNetworkIsolation(t *testing.T) {
+	t.Parallel()
+
+	awsRegion := aws.GetRandomRegion(t, test.RegionsForEc2Tests, nil)
+	name := strings.ToLower("alb-reach-" + random.UniqueId())
+
+	// Plan a copy of the example, as the tests that deploy it apply in the example folder in parallel.
+	testFolder := test_structure.CopyTerraformFolderToTemp(t, "../../", "examples/for-learning-and-testing/networking/alb")
+	terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, awsRegion)
+	terraformOptions.Vars["alb_name"] = name
+	terraformOptions.Vars["base_domain_name"] = test.BaseDomainForTest
+	terraformOptions.Vars["alb_subdomain"] = name
+	terraformOptions.Vars["base_domain_name_tags"] = test.DomainNameTagsForTest
+
+	network, _ := netreach.InitAndPlan(t, terraformOptions)
+
+	alb := findEndpointOfType(t, network, "aws_lb")
+	webserver := network.Endpoint("aws_instance.webserver")
+	require.NotNil(t, webserver)
+
+	// The webserver should only be reachable through the ALB.
+	assertReachable(t, network.CanReach(netreach.Internet(), alb, "tcp", 443), "internet", alb.Address)
+	assertReachable(t, network.CanReach(alb, webserver, "tcp", 8080), alb.Address, webserver.Address)
+	assertNotReachable(t, network.CanReach(netreach.Internet(), webserver, "tcp", 8080), "internet", webserver.Address)
+	assertEndpointsReachableFromInternet(t, network, 22)
+}
+
+func TestAsgServiceNetworkIsolation(t *testing.T) {
+	t.Parallel()
+
+	awsRegion := aws.GetRandomRegion(t, test.RegionsForEc2Tests, nil)
+	port := 8080
+
+	testFolder := test_structure.CopyTerraformFolderToTemp(t, "../../", "examples/for-learning-and-testing/services/asg-service")
+	terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, awsRegion)
+	terraformOptions.Vars["name"] = strings.ToLower("asg-reach-" + random.UniqueId())
+	// The plan doesn't boot any instances, so any AMI will do.
+	terraformOptions.Vars["ami"] = aws.GetAmazonLinuxAmi(t, awsRegion)
+
+	network, _ := netreach.InitAndPlan(t, terraformOptions)
+
+	alb := findEndpointOfType(t, network, "aws_lb")
+	asg := findEndpointOfType(t, network, "aws_autoscaling_group")
+
+	// The instances of the service should only serve traffic through the ALB. SSH is open to the world for testing.
+	assertReachable(t, network.CanReach(netreach.Internet(), alb, "tcp", 80), "internet", alb.Address)
+	assertReachable(t, network.CanReach(alb, asg, "tcp", port), alb.Address, asg.Address)
+	assertNotReachable(t, network.CanReach(netreach.Internet(), asg, "tcp", port), "internet", asg.Address)
+	assertEndpointsReachableFromInternet(t, network, port)
+	assertEndpointsReachableFromInternet(t, network, 22, asg.Address)
+}
+
+// findEndpointOfType returns the only endpoint of the given resource type in the network.
+func findEndpointOfType(t *testing.T, network *netreach.Network, resourceType string) *netreach.Endpoint {
+	endpoints := []*netreach.Endpoint{}
+	for _, endpoint := range network.Endpoints {
+		if endpoint.Type == resourceType {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	require.Lenf(t, endpoints, 1, "Expected exactly one endpoint of type %s", resourceType)
+	return endpoints[0]
+}
+
+// assertEndpointsReachableFromInternet checks that exactly the endpoints with the given addresses can be reached from
+// the internet on the given TCP port.
+func assertEndpointsReachableFromInternet(t *testing.T, network *netreach.Network, port int, expectedAddresses ...string) {
+	addresses := []string{}
+	for _, endpoint := range network.EndpointsReachableFromInternet("tcp", port) {
+		addresses = append(addresses, endpoint.Address)
+	}
+	assert.ElementsMatchf(t, expectedAddresses, addresses, "Endpoints reachable from the internet on port %d", port)
+}
+
+func assertReachable(t *testing.T, result netreach.Result, source string, destination string) {
+	assert.Truef(t, result.Reachable, "Expected %s to reach %s, but: %s", source, destination, strings.Join(result.Reasons, "; "))
+}
+
+func assertNotReachable(t *testing.T, result netreach.Result, source string, destination string) {
+	assert.Falsef(t, result.Reachable, "Expected %s to not reach %s", source, destination)
+}
@@ -0,0 +1,345 @@
+package networking
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The TTL the route53 module sets on the NS records that delegate a subdomain from its parent hosted zone.
+const route53DelegationTTL = 172800
+
+// route53ZoneInput holds the attributes of the public_zones and private_zones inputs of the route53 module that
+// determine which records and VPC associations the module creates.
+type route53ZoneInput struct {
+	SubjectAlternativeNames  []string                      `json:"subject_alternative_names"`
+	CreatedOutsideTerraform  bool                          `json:"created_outside_terraform"`
+	ParentHostedZoneID       string                        `json:"parent_hosted_zone_id"`
+	ProvisionCertificates    *bool                         `json:"provision_certificates"`
+	CreateVerificationRecord *bool                         `json:"create_verification_record"`
+	Subdomains               map[string]route53RecordInput `json:"subdomains"`
+	ApexRecords              []route53RecordInput          `json:"apex_records"`
+	VPCs                     []route53VPCInput             `json:"vpcs"`
+}
+
+type route53RecordInput struct {
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl"`
+	Records []string `json:"records"`
+}
+
+type route53VPCInput struct {
+	ID     string  `json:"id"`
+	Region *string `json:"region"`
+}
+
+// route53Record is a record set, reduced to the attributes we compare. Values are sorted and fully qualified where they
+// are domain names, so that records can be compared regardless of how they were entered.
+type route53Record struct {
+	Name   string
+	Type   string
+	TTL    int64
+	Values []string
+}
+
+// String renders the record without its TTL, as the TTL of some records (e.g., the ACM validation records) is not
+// under the control of the module. TTLs are checked separately, where the inputs set them.
+func (record route53Record) String() string {
+	return fmt.Sprintf("%s %s %s", record.Name, record.Type, strings.Join(record.Values, " "))
+}
+
+// route53ZoneExpectation holds the records we expect in a hosted zone, under the given domains. A zone can be shared
+// with records that have nothing to do with the test (e.g., the gruntwork.in zone), so we only compare the records that
+// are under the domains the test manages.
+type route53ZoneExpectation struct {
+	domains []string
+	records []route53Record
+}
+
+// verifyRoute53Records lists every record set in the hosted zones that the route53 module writes to, and compares
+// them to the records implied by the public_zones and private_zones inputs: the subdomain and apex records, the ACM
+// validation CNAMEs for each domain and subject alternative name, and the NS records that delegate zones with a
+// parent_hosted_zone_id. It also checks the VPC associations of the private zones. Mismatches are reported as a diff.
+func verifyRoute53Records(
+	t *testing.T,
+	awsRegion string,
+	publicZones interface{},
+	privateZones interface{},
+	publicHostedZoneMap map[string]string,
+	privateZoneIDs map[string]string,
+) {
+	client := newRoute53Client(t)
+
+	publicInputs := parseRoute53ZoneInputs(t, publicZones)
+	privateInputs := parseRoute53ZoneInputs(t, privateZones)
+
+	expectations := map[string]*route53ZoneExpectation{}
+	expect := func(zoneID string, domain string, records ...route53Record) {
+		zoneID = strings.TrimPrefix(zoneID, "/hostedzone/")
+		if _, hasZone := expectations[zoneID]; !hasZone {
+			expectations[zoneID] = &route53ZoneExpectation{}
+		}
+		expectation := expectations[zoneID]
+		expectation.domains = append(expectation.domains, fqdn(domain))
+		expectation.records = append(expectation.records, records...)
+	}
+
+	for domain, input := range publicInputs {
+		zoneID, hasZone := publicHostedZoneMap[domain]
+		require.True(t, hasZone, "No hosted zone ID for public zone %s", domain)
+
+		records := []route53Record{}
+		for subdomain, record := range input.Subdomains {
+			records = append(records, newRoute53Record(fmt.Sprintf("%s.%s", subdomain, domain), record))
+		}
+		for _, record := range input.ApexRecords {
+			records = append(records, newRoute53Record(domain, record))
+		}
+		if boolWithDefault(input.ProvisionCertificates, true) && boolWithDefault(input.CreateVerificationRecord, true) {
+			records = append(records, getACMValidationRecords(t, awsRegion, domain, input.SubjectAlternativeNames)...)
+		}
+		expect(zoneID, domain, records...)
+
+		if !input.CreatedOutsideTerraform && input.ParentHostedZoneID != "" {
+			nameServers := getHostedZoneNameServers(t, client, zoneID)
+			delegation := route53Record{Name: fqdn(domain), Type: "NS", TTL: route53DelegationTTL, Values: nameServers}
+			expect(input.ParentHostedZoneID, domain, delegation)
+		}
+	}
+
+	for domain := range privateInputs {
+		zoneID, hasZone := privateZoneIDs[fqdn(domain)]
+		require.True(t, hasZone, "No hosted zone ID for private zone %s", domain)
+		expect(zoneID, domain)
+	}
+
+	for zoneID, expectation := range expectations {
+		actualRecords := listRoute53Records(t, client, zoneID, expectation.domains)
+		assert.Equal(t, route53RecordStrings(expectation.records), route53RecordStrings(actualRecords), "Records in hosted zone %s", zoneID)
+
+		actualTTLs := map[string]int64{}
+		for _, record := range actualRecords {
+			actualTTLs[record.Name+" "+record.Type] = record.TTL
+		}
+		for _, record := range expectation.records {
+			if record.TTL != 0 {
+				assert.Equal(t, record.TTL, actualTTLs[record.Name+" "+record.Type], "TTL of %s record %s in hosted zone %s", record.Type, record.Name, zoneID)
+			}
+		}
+	}
+
+	for domain, input := range privateInputs {
+		zoneID := strings.TrimPrefix(privateZoneIDs[fqdn(domain)], "/hostedzone/")
+		expectedVPCs := []string{}
+		for _, vpc := range input.VPCs {
+			region := awsRegion
+			if vpc.Region != nil {
+				region = *vpc.Region
+			}
+			expectedVPCs = append(expectedVPCs, fmt.Sprintf("%s (%s)", vpc.ID, region))
+		}
+		sort.Strings(expectedVPCs)
+		assert.Equal(t, expectedVPCs, getHostedZoneVPCs(t, client, zoneID), "VPC associations of private zone %s", domain)
+	}
+}
+
+// parseRoute53ZoneInputs converts the public_zones or private_zones input of the route53 module, as passed in
+// terraform.Options.Vars, to route53ZoneInput structs.
+func parseRoute53ZoneInputs(t *testing.T, zones interface{}) map[string]route53ZoneInput {
+	inputs := map[string]route53ZoneInput{}
+	if zones == nil {
+		return inputs
+	}
+	zonesJSON, err := json.Marshal(zones)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(zonesJSON, &inputs))
+	return inputs
+}
+
+func newRoute53Record(name string, input route53RecordInput) route53Record {
+	recordType := strings.ToUpper(input.Type)
+	values := []string{}
+	for _, value := range input.Records {
+		values = append(values, normalizeRoute53Value(recordType, value))
+	}
+	sort.Strings(values)
+	return route53Record{Name: fqdn(name), Type: recordType, TTL: input.TTL, Values: values}
+}
+
+// getACMValidationRecords returns the CNAME records ACM asks for to validate the certificate for the given domain and
+// subject alternative names. A domain and its wildcard share the same validation record, so there are no duplicates.
+// ACM fills in the validation records asynchronously after the certificate is requested, so this retries until they
+// are all available.
+func getACMValidationRecords(t *testing.T, awsRegion string, domain string, subjectAlternativeNames []string) []route53Record {
+	client := newACMClient(t, awsRegion)
+
+	certificateArn := aws.GetAcmCertificateArn(t, awsRegion, domain)
+	expectedDomains := append([]string{domain}, subjectAlternativeNames...)
+
+	records, err := retry.DoWithRetryInterfaceE(
+		t,
+		fmt.Sprintf("Get ACM validation records for %s", domain),
+		30,
+		10*time.Second,
+		func() (interface{}, error) {
+			output, err := client.DescribeCertificate(&acm.DescribeCertificateInput{CertificateArn: awsgo.String(certificateArn)})
+			if err != nil {
+				return nil, err
+			}
+
+			recordsByName := map[string]route53Record{}
+			validatedDomains := map[string]bool{}
+			for _, option := range output.Certificate.DomainValidationOptions {
+				if option.ResourceRecord == nil {
+					return nil, fmt.Errorf("ACM has not issued the validation record for %s yet", awsgo.StringValue(option.DomainName))
+				}
+				validatedDomains[awsgo.StringValue(option.DomainName)] = true
+				name := fqdn(awsgo.StringValue(option.ResourceRecord.Name))
+				recordsByName[name] = route53Record{
+					Name:   name,
+					Type:   awsgo.StringValue(option.ResourceRecord.Type),
+					Values: []string{fqdn(awsgo.StringValue(option.ResourceRecord.Value))},
+				}
+			}
+			for _, expectedDomain := range expectedDomains {
+				if !validatedDomains[expectedDomain] {
+					return nil, fmt.Errorf("Certificate %s does not cover %s", certificateArn, expectedDomain)
+				}
+			}
+
+			records := []route53Record{}
+			for _, record := range recordsByName {
+				records = append(records, record)
+			}
+			return records, nil
+		},
+	)
+	require.NoError(t, err)
+	return records.([]route53Record)
+}
+
+// listRoute53Records returns the record sets in the given hosted zone that are under any of the given domains. The SOA
+// and NS records at the apex of the zone are left out, as Route 53 creates those with the zone.
+func listRoute53Records(t *testing.T, client *route53.Route53, zoneID string, domains []string) []route53Record {
+	zone, err := client.GetHostedZone(&route53.GetHostedZoneInput{Id: awsgo.String(zoneID)})
+	require.NoError(t, err)
+	zoneName := fqdn(awsgo.StringValue(zone.HostedZone.Name))
+
+	records := []route53Record{}
+	input := &route53.ListResourceRecordSetsInput{HostedZoneId: awsgo.String(zoneID)}
+	err = client.ListResourceRecordSetsPages(input, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		for _, recordSet := range page.ResourceRecordSets {
+			name := fqdn(unescapeRoute53Name(awsgo.StringValue(recordSet.Name)))
+			recordType := awsgo.StringValue(recordSet.Type)
+			if recordType == "SOA" || (recordType == "NS" && name == zoneName) || !isUnderAnyDomain(name, domains) {
+				continue
+			}
+
+			values := []string{}
+			if recordSet.AliasTarget != nil {
+				values = append(values, "ALIAS "+fqdn(awsgo.StringValue(recordSet.AliasTarget.DNSName)))
+			}
+			for _, record := range recordSet.ResourceRecords {
+				values = append(values, normalizeRoute53Value(recordType, awsgo.StringValue(record.Value)))
+			}
+			sort.Strings(values)
+			records = append(records, route53Record{Name: name, Type: recordType, TTL: awsgo.Int64Value(recordSet.TTL), Values: values})
+		}
+		return true
+	})
+	require.NoError(t, err)
+	return records
+}
+
+// getHostedZoneNameServers returns the fully qualified name servers that Route 53 assigned to the given public zone.
+func getHostedZoneNameServers(t *testing.T, client *route53.Route53, zoneID string) []string {
+	zone, err := client.GetHostedZone(&route53.GetHostedZoneInput{Id: awsgo.String(zoneID)})
+	require.NoError(t, err)
+	require.NotNil(t, zone.DelegationSet, "Hosted zone %s has no delegation set", zoneID)
+
+	nameServers := []string{}
+	for _, nameServer := range zone.DelegationSet.NameServers {
+		nameServers = append(nameServers, fqdn(awsgo.StringValue(nameServer)))
+	}
+	sort.Strings(nameServers)
+	return nameServers
+}
+
+// getHostedZoneVPCs returns the VPCs associated with the given private zone, rendered as "<vpc id> (<region>)".
+func getHostedZoneVPCs(t *testing.T, client *route53.Route53, zoneID string) []string {
+	zone, err := client.GetHostedZone(&route53.GetHostedZoneInput{Id: awsgo.String(zoneID)})
+	require.NoError(t, err)
+
+	vpcs := []string{}
+	for _, vpc := range zone.VPCs {
+		vpcs = append(vpcs, fmt.Sprintf("%s (%s)", awsgo.StringValue(vpc.VPCId), awsgo.StringValue(vpc.VPCRegion)))
+	}
+	sort.Strings(vpcs)
+	return vpcs
+}
+
+func newRoute53Client(t *testing.T) *route53.Route53 {
+	// Route 53 is a global service, so any region works for the API client.
+	sess, err := aws.NewAuthenticatedSession("us-east-1")
+	require.NoError(t, err)
+	return route53.New(sess)
+}
+
+func route53RecordStrings(records []route53Record) []string {
+	recordStrings := []string{}
+	for _, record := range records {
+		recordStrings = append(recordStrings, record.String())
+	}
+	sort.Strings(recordStrings)
+	return recordStrings
+}
+
+// normalizeRoute53Value makes the value of a record comparable to what the Route 53 API returns: TXT values are quoted,
+// and domain names are fully qualified and lower case.
+func normalizeRoute53Value(recordType string, value string) string {
+	switch recordType {
+	case "TXT", "SPF":
+		if !strings.HasPrefix(value, `"`) {
+			return `"` + value + `"`
+		}
+	case "CNAME", "NS":
+		return fqdn(value)
+	}
+	return value
+}
+
+// unescapeRoute53Name replaces the octal escape code Route 53 uses for the * in wildcard records.
+func unescapeRoute53Name(name string) string {
+	return strings.Replace(name, `\052`, "*", -1)
+}
+
+func isUnderAnyDomain(name string, domains []string) bool {
+	for _, domain := range domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func fqdn(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
+
+func boolWithDefault(value *bool, defaultValue bool) bool {
+	if value == nil {
+		return defaultValue
+	}
+	return *value
+}
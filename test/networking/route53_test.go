@@ -8,10 +8,12 @@ import (
 	"time"
 
 	"github.com/gruntwork-io/aws-service-catalog/test"
+	"github.com/gruntwork-io/aws-service-catalog/test/localdns"
 
 	awsgo "github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/servicediscovery"
 	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/collections"
 	dns_helper "github.com/gruntwork-io/terratest/modules/dns-helper"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/retry"
@@ -154,25 +156,61 @@ func TestRoute53(t *testing.T) {
 		require.NotNil(t, publicZonesIds)
 		require.NotNil(t, publicZonesNameServers)
 
+		// Verify that the records in each zone match the public_zones and private_zones inputs
+		awsRegion := test_structure.LoadString(t, testFolder, "region")
+		privateZoneIDs := map[string]string{}
+		privateZoneNames := terraform.OutputList(t, terraformOptions, "private_domain_names")
+		for i, zoneID := range terraform.OutputList(t, terraformOptions, "private_zones_ids") {
+			privateZoneIDs[fqdn(privateZoneNames[i])] = zoneID
+		}
+		verifyRoute53Records(
+			t,
+			awsRegion,
+			terraformOptions.Vars["public_zones"],
+			terraformOptions.Vars["private_zones"],
+			terraform.OutputMap(t, terraformOptions, "public_hosted_zone_map"),
+			privateZoneIDs,
+		)
+
+		// Verify the ACM certificates requested for the public zones
+		verifyRoute53Certificates(t, awsRegion, terraformOptions.Vars["public_zones"])
+
 		// Verify that the TXT subdomain record was created
 		subdomainName := test_structure.LoadString(t, testFolder, "subdomainName")
 		query := dns_helper.DNSQuery{
 			Type: "TXT",
 			Name: subdomainName,
 		}
-		dns_helper.DNSLookupAuthoritativeAllWithValidationRetry(
-			t,
-			query,
-			[]string{"8.8.8.8", "1.1.1.1"},
-			dns_helper.DNSAnswers{
-				dns_helper.DNSAnswer{
-					Type:  "TXT",
-					Value: `"hello-world"`,
-				},
+		expectedAnswers := dns_helper.DNSAnswers{
+			dns_helper.DNSAnswer{
+				Type:  "TXT",
+				Value: `"hello-world"`,
 			},
-			// Try for up to 10 minutes
-			60, 10*time.Second,
-		)
+		}
+
+		// Check the record contents straight from the Route 53 API, through a local DNS server, so that we don't have
+		// to wait for the record to propagate through public DNS. The server has to serve the zones that hold the
+		// records of each public domain, which includes zones created outside of Terraform, such as the one the TXT
+		// record is written to.
+		publicHostedZoneIDs := []string{}
+		for _, zoneID := range terraform.OutputMap(t, terraformOptions, "public_hosted_zone_map") {
+			if !collections.ListContains(publicHostedZoneIDs, zoneID) {
+				publicHostedZoneIDs = append(publicHostedZoneIDs, zoneID)
+			}
+		}
+		dnsServer := localdns.StartServer(t, publicHostedZoneIDs...)
+		dnsServer.LookupWithValidationRetry(t, query, expectedAnswers, 30, 2*time.Second)
+
+		if test.IsPublicDNSCheckEnabled() {
+			dns_helper.DNSLookupAuthoritativeAllWithValidationRetry(
+				t,
+				query,
+				[]string{"8.8.8.8", "1.1.1.1"},
+				expectedAnswers,
+				// Try for up to 10 minutes
+				60, 10*time.Second,
+			)
+		}
 	})
 }
 
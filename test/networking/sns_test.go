@@ -1,14 +1,25 @@
 package networking
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gruntwork-io/aws-service-catalog/test"
 
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
 	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSnsTopics(t *testing.T) {
@@ -19,6 +30,9 @@ func TestSnsTopics(t *testing.T) {
 	testFolder := "../../examples/for-learning-and-testing/networking/sns-topics"
 	terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, awsRegion)
 	terraformOptions.Vars["name"] = "test-topic-" + random.UniqueId()
+	terraformOptions.Vars["allow_publish_accounts"] = []string{fmt.Sprintf("arn:aws:iam::%s:root", aws.GetAccountId(t))}
+	terraformOptions.Vars["allow_publish_services"] = []string{"events.amazonaws.com", "cloudwatch.amazonaws.com"}
+	terraformOptions.Vars["kms_master_key_id"] = "alias/aws/sns"
 
 	defer terraform.Destroy(t, terraformOptions)
 
@@ -27,4 +41,258 @@ func TestSnsTopics(t *testing.T) {
 	topicArn := terraform.Output(t, terraformOptions, "topic_arn")
 	assert.Regexp(t, "^arn:aws:sns:.*:test-topic-.*", topicArn)
 
+	verifySNSTopicAttributes(t, awsRegion, topicArn, terraformOptions.Vars)
+
+	queueURL := createSQSSubscriber(t, awsRegion, topicArn)
+	defer aws.DeleteQueue(t, awsRegion, queueURL)
+
+	verifySNSDelivery(t, aws.NewSnsClient(t, awsRegion), aws.NewSqsClient(t, awsRegion), topicArn, queueURL)
+}
+
+// TestSnsTopicsCrossAccountPublish verifies that an external account listed in allow_publish_accounts can publish to the
+// topic. Other accounts can't use the AWS managed key for SNS, so the topic is encrypted with a customer managed key
+// that the test creates, and which grants the external account the rights it needs to publish.
+func TestSnsTopicsCrossAccountPublish(t *testing.T) {
+	t.Parallel()
+
+	test.RequireEnvVar(t, "TEST_EXTERNAL_ACCOUNT_ID")
+	externalAccountID := test.GetExternalAccountId()
+
+	awsRegion := aws.GetRandomRegion(t, test.RegionsForEc2Tests, nil)
+
+	kmsKeyArn := createSNSKMSKey(t, awsRegion, externalAccountID)
+	defer scheduleKMSKeyDeletion(t, awsRegion, kmsKeyArn)
+
+	// Copy the example, so that this test doesn't share the terraform state with TestSnsTopics
+	testFolder := test_structure.CopyTerraformFolderToTemp(t, "../../", "examples/for-learning-and-testing/networking/sns-topics")
+	terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, awsRegion)
+	terraformOptions.Vars["name"] = "test-topic-" + random.UniqueId()
+	terraformOptions.Vars["allow_publish_accounts"] = []string{fmt.Sprintf("arn:aws:iam::%s:root", externalAccountID)}
+	terraformOptions.Vars["allow_publish_services"] = []string{}
+	terraformOptions.Vars["kms_master_key_id"] = kmsKeyArn
+
+	defer terraform.Destroy(t, terraformOptions)
+
+	terraform.InitAndApply(t, terraformOptions)
+
+	topicArn := terraform.Output(t, terraformOptions, "topic_arn")
+	verifySNSTopicAttributes(t, awsRegion, topicArn, terraformOptions.Vars)
+
+	queueURL := createSQSSubscriber(t, awsRegion, topicArn)
+	defer aws.DeleteQueue(t, awsRegion, queueURL)
+
+	externalSession, err := aws.NewAuthenticatedSessionFromRole(awsRegion, test.GetExternalAccountRoleArn())
+	require.NoError(t, err)
+	verifySNSDelivery(t, sns.New(externalSession), aws.NewSqsClient(t, awsRegion), topicArn, queueURL)
+}
+
+// verifySNSTopicAttributes checks that the topic is encrypted with the kms_master_key_id input, and that the topic
+// policy grants publish rights to the allow_publish_accounts and allow_publish_services inputs.
+func verifySNSTopicAttributes(t *testing.T, awsRegion string, topicArn string, vars map[string]interface{}) {
+	output, err := aws.NewSnsClient(t, awsRegion).GetTopicAttributes(&sns.GetTopicAttributesInput{TopicArn: awsgo.String(topicArn)})
+	require.NoError(t, err)
+	attributes := awsgo.StringValueMap(output.Attributes)
+
+	assert.Equal(t, vars["kms_master_key_id"], attributes["KmsMasterKeyId"])
+
+	var policy snsTopicPolicy
+	require.NoError(t, json.Unmarshal([]byte(attributes["Policy"]), &policy))
+	publishAccounts, publishServices := policy.principalsAllowed("sns:Publish")
+	for _, account := range vars["allow_publish_accounts"].([]string) {
+		assert.Contains(t, publishAccounts, account, "Topic policy should allow %s to publish", account)
+	}
+	for _, service := range vars["allow_publish_services"].([]string) {
+		assert.Contains(t, publishServices, service, "Topic policy should allow %s to publish", service)
+	}
+}
+
+// createSQSSubscriber creates a temporary SQS queue and subscribes it to the given topic, with raw message delivery, so
+// that the SNS message attributes arrive as SQS message attributes. The caller is responsible for deleting the queue;
+// the subscription goes away with the topic.
+func createSQSSubscriber(t *testing.T, awsRegion string, topicArn string) string {
+	sqsClient := aws.NewSqsClient(t, awsRegion)
+	queueURL := aws.CreateRandomQueue(t, awsRegion, "sns-test")
+
+	attributes, err := sqsClient.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       awsgo.String(queueURL),
+		AttributeNames: awsgo.StringSlice([]string{sqs.QueueAttributeNameQueueArn}),
+	})
+	require.NoError(t, err)
+	queueArn := awsgo.StringValue(attributes.Attributes[sqs.QueueAttributeNameQueueArn])
+
+	queuePolicy := map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Effect":    "Allow",
+				"Principal": map[string]string{"Service": "sns.amazonaws.com"},
+				"Action":    "sqs:SendMessage",
+				"Resource":  queueArn,
+				"Condition": map[string]interface{}{"ArnEquals": map[string]string{"aws:SourceArn": topicArn}},
+			},
+		},
+	}
+	queuePolicyJSON, err := json.Marshal(queuePolicy)
+	require.NoError(t, err)
+	_, err = sqsClient.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl:   awsgo.String(queueURL),
+		Attributes: map[string]*string{sqs.QueueAttributeNamePolicy: awsgo.String(string(queuePolicyJSON))},
+	})
+	require.NoError(t, err)
+
+	_, err = aws.NewSnsClient(t, awsRegion).Subscribe(&sns.SubscribeInput{
+		TopicArn:              awsgo.String(topicArn),
+		Protocol:              awsgo.String("sqs"),
+		Endpoint:              awsgo.String(queueArn),
+		Attributes:            map[string]*string{"RawMessageDelivery": awsgo.String("true")},
+		ReturnSubscriptionArn: awsgo.Bool(true),
+	})
+	require.NoError(t, err)
+	return queueURL
+}
+
+// verifySNSDelivery publishes a message with a unique body and attributes to the topic, using the given SNS client, and
+// checks that the message arrives on the subscribed queue with the same body and attributes.
+func verifySNSDelivery(t *testing.T, snsClient *sns.SNS, sqsClient *sqs.SQS, topicArn string, queueURL string) {
+	body := fmt.Sprintf("test-message-%s", random.UniqueId())
+	messageAttributes := map[string]string{
+		"source":    "terratest",
+		"messageID": random.UniqueId(),
+	}
+
+	publishAttributes := map[string]*sns.MessageAttributeValue{}
+	for name, value := range messageAttributes {
+		publishAttributes[name] = &sns.MessageAttributeValue{DataType: awsgo.String("String"), StringValue: awsgo.String(value)}
+	}
+	_, err := snsClient.Publish(&sns.PublishInput{
+		TopicArn:          awsgo.String(topicArn),
+		Message:           awsgo.String(body),
+		MessageAttributes: publishAttributes,
+	})
+	require.NoError(t, err)
+
+	message, err := retry.DoWithRetryInterfaceE(
+		t,
+		fmt.Sprintf("Wait for message %s on queue %s", body, queueURL),
+		30,
+		5*time.Second,
+		func() (interface{}, error) {
+			output, err := sqsClient.ReceiveMessage(&sqs.ReceiveMessageInput{
+				QueueUrl:              awsgo.String(queueURL),
+				MessageAttributeNames: awsgo.StringSlice([]string{sqs.QueueAttributeNameAll}),
+				MaxNumberOfMessages:   awsgo.Int64(10),
+				WaitTimeSeconds:       awsgo.Int64(10),
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, message := range output.Messages {
+				if awsgo.StringValue(message.Body) == body {
+					return message, nil
+				}
+			}
+			return nil, fmt.Errorf("Message %s not delivered yet", body)
+		},
+	)
+	require.NoError(t, err)
+
+	receivedAttributes := map[string]string{}
+	for name, value := range message.(*sqs.Message).MessageAttributes {
+		receivedAttributes[name] = awsgo.StringValue(value.StringValue)
+	}
+	assert.Equal(t, messageAttributes, receivedAttributes)
+}
+
+// createSNSKMSKey creates a customer managed KMS key that the current account can administer and use, and that the
+// given external account can use to publish to topics encrypted with it.
+func createSNSKMSKey(t *testing.T, awsRegion string, externalAccountID string) string {
+	keyPolicy := map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Sid":       "AllowCurrentAccount",
+				"Effect":    "Allow",
+				"Principal": map[string]string{"AWS": fmt.Sprintf("arn:aws:iam::%s:root", aws.GetAccountId(t))},
+				"Action":    "kms:*",
+				"Resource":  "*",
+			},
+			{
+				"Sid":       "AllowExternalAccountToPublish",
+				"Effect":    "Allow",
+				"Principal": map[string]string{"AWS": fmt.Sprintf("arn:aws:iam::%s:root", externalAccountID)},
+				"Action":    []string{"kms:GenerateDataKey*", "kms:Decrypt"},
+				"Resource":  "*",
+			},
+		},
+	}
+	keyPolicyJSON, err := json.Marshal(keyPolicy)
+	require.NoError(t, err)
+
+	output, err := aws.NewKmsClient(t, awsRegion).CreateKey(&kms.CreateKeyInput{
+		Description: awsgo.String(fmt.Sprintf("Test key for %s", t.Name())),
+		Policy:      awsgo.String(string(keyPolicyJSON)),
+	})
+	require.NoError(t, err)
+	return awsgo.StringValue(output.KeyMetadata.Arn)
+}
+
+func scheduleKMSKeyDeletion(t *testing.T, awsRegion string, keyArn string) {
+	_, err := aws.NewKmsClient(t, awsRegion).ScheduleKeyDeletion(&kms.ScheduleKeyDeletionInput{
+		KeyId:               awsgo.String(keyArn),
+		PendingWindowInDays: awsgo.Int64(7),
+	})
+	require.NoError(t, err)
+}
+
+// snsTopicPolicy is the subset of an IAM policy document needed to find out who a topic policy allows to do what.
+// Principal and Action can each be a single value or a list, so they are parsed loosely.
+type snsTopicPolicy struct {
+	Statement []struct {
+		Effect    string
+		Principal interface{}
+		Action    interface{}
+	}
+}
+
+// principalsAllowed returns the AWS principals and service principals that the policy allows to perform the given
+// action.
+func (policy snsTopicPolicy) principalsAllowed(action string) ([]string, []string) {
+	accounts := []string{}
+	services := []string{}
+	for _, statement := range policy.Statement {
+		if statement.Effect != "Allow" || !containsStringIgnoreCase(stringOrList(statement.Action), action) {
+			continue
+		}
+		principal, isMap := statement.Principal.(map[string]interface{})
+		if !isMap {
+			continue
+		}
+		accounts = append(accounts, stringOrList(principal["AWS"])...)
+		services = append(services, stringOrList(principal["Service"])...)
+	}
+	return accounts, services
+}
+
+func stringOrList(value interface{}) []string {
+	switch typedValue := value.(type) {
+	case string:
+		return []string{typedValue}
+	case []interface{}:
+		values := []string{}
+		for _, item := range typedValue {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	}
+	return nil
+}
+
+// containsStringIgnoreCase is used to match IAM actions, which are not case sensitive.
+func containsStringIgnoreCase(values []string, value string) bool {
+	for _, item := range values {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
 }
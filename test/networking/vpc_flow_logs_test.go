@@ -0,0 +1,201 @@
+package networking
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A port that the security group of the example instance does not allow, so connection attempts to it show up as
+// REJECT records in the flow logs.
+const flowLogsBlockedPort = 23
+
+// The protocol number of TCP, as it appears in flow log records.
+const flowLogsProtocolTCP = 6
+
+// flowLogRecord is a single record of a VPC flow log in the default (version 2) format. See
+// https://docs.aws.amazon.com/vpc/latest/userguide/flow-logs.html#flow-log-records
+type flowLogRecord struct {
+	InterfaceID string
+	SrcAddr     string
+	DstAddr     string
+	SrcPort     int
+	DstPort     int
+	Protocol    int
+	Action      string
+	LogStatus   string
+}
+
+// parseFlowLogRecord parses a flow log record in the default format. Fields that have no data (e.g., in NODATA
+// records) are left as zero values.
+func parseFlowLogRecord(line string) (flowLogRecord, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 14 {
+		return flowLogRecord{}, fmt.Errorf("Expected 14 fields in flow log record, but got %d: %s", len(fields), line)
+	}
+	if fields[0] != "2" {
+		return flowLogRecord{}, fmt.Errorf("Unsupported flow log record version %s: %s", fields[0], line)
+	}
+
+	record := flowLogRecord{
+		InterfaceID: fields[2],
+		SrcAddr:     noDataToEmpty(fields[3]),
+		DstAddr:     noDataToEmpty(fields[4]),
+		Action:      noDataToEmpty(fields[12]),
+		LogStatus:   fields[13],
+	}
+	numbers := []struct {
+		value string
+		dest  *int
+	}{
+		{fields[5], &record.SrcPort},
+		{fields[6], &record.DstPort},
+		{fields[7], &record.Protocol},
+	}
+	for _, number := range numbers {
+		if number.value == "-" {
+			continue
+		}
+		parsed, err := strconv.Atoi(number.value)
+		if err != nil {
+			return flowLogRecord{}, fmt.Errorf("Invalid number %s in flow log record: %s", number.value, line)
+		}
+		*number.dest = parsed
+	}
+	return record, nil
+}
+
+func noDataToEmpty(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// validateFlowLogs sends an accepted and a rejected request to the example instance, and checks that both show up in
+// the flow logs of the VPC with the right action. The VPC must have flow logs enabled for ALL traffic.
+func validateFlowLogs(t *testing.T, terraformOptions *terraform.Options, awsRegion string, vpcName string, port int) {
+	instanceIP := terraform.Output(t, terraformOptions, "instance_ip")
+	instancePrivateIP := terraform.Output(t, terraformOptions, "instance_private_ip")
+	// This is the name the vpc-flow-logs module uses for the log group when the flow logs go to CloudWatch.
+	logGroupName := vpcName + "-vpc-flow-logs"
+	// Flow log records are timestamped with the start of their capture window, so look back a bit further than now.
+	startTime := time.Now().Add(-15 * time.Minute)
+
+	// Generate some traffic that is accepted and some that is rejected.
+	for i := 0; i < 3; i++ {
+		http_helper.HttpGet(t, fmt.Sprintf("http://%s:%d", instanceIP, port), nil)
+
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", instanceIP, flowLogsBlockedPort), 5*time.Second)
+		if err == nil {
+			conn.Close()
+			t.Fatalf("Expected connection to port %d of %s to be blocked, but it succeeded", flowLogsBlockedPort, instanceIP)
+		}
+	}
+
+	// Flow logs are aggregated for up to 10 minutes before they are published to CloudWatch.
+	maxRetries := 40
+	timeBetweenRetries := 30 * time.Second
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Find accepted and rejected traffic to %s in flow log group %s", instancePrivateIP, logGroupName),
+		maxRetries,
+		timeBetweenRetries,
+		func() (string, error) {
+			records, err := getFlowLogRecords(t, awsRegion, logGroupName, startTime)
+			if err != nil {
+				return "", err
+			}
+
+			foundAccept := false
+			foundReject := false
+			for _, record := range records {
+				if record.DstAddr != instancePrivateIP || record.Protocol != flowLogsProtocolTCP {
+					continue
+				}
+				switch {
+				case record.DstPort == port && record.Action == "ACCEPT":
+					foundAccept = true
+				case record.DstPort == flowLogsBlockedPort && record.Action == "REJECT":
+					foundReject = true
+				}
+			}
+			if !foundAccept || !foundReject {
+				return "", fmt.Errorf("Found %d flow log records, but not yet both an ACCEPT on port %d (%t) and a REJECT on port %d (%t)", len(records), port, foundAccept, flowLogsBlockedPort, foundReject)
+			}
+			return "", nil
+		},
+	)
+}
+
+// getFlowLogRecords returns all the flow log records in the given log group since the given time.
+func getFlowLogRecords(t *testing.T, awsRegion string, logGroupName string, startTime time.Time) ([]flowLogRecord, error) {
+	client := aws.NewCloudWatchLogsClient(t, awsRegion)
+
+	records := []flowLogRecord{}
+	var parseErr error
+	input := &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: awsgo.String(logGroupName),
+		StartTime:    awsgo.Int64(startTime.UnixNano() / int64(time.Millisecond)),
+	}
+	err := client.FilterLogEventsPages(input, func(page *cloudwatchlogs.FilterLogEventsOutput, lastPage bool) bool {
+		for _, event := range page.Events {
+			record, err := parseFlowLogRecord(awsgo.StringValue(event.Message))
+			if err != nil {
+				parseErr = err
+				return false
+			}
+			records = append(records, record)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	logger.Logf(t, "Found %d flow log records in log group %s", len(records), logGroupName)
+	return records, nil
+}
+
+func TestParseFlowLogRecord(t *testing.T) {
+	t.Parallel()
+
+	record, err := parseFlowLogRecord("2 123456789010 eni-1235b8ca123456789 172.31.16.139 10.0.1.20 20641 80 6 20 4249 1418530010 1418530070 ACCEPT OK")
+	require.NoError(t, err)
+	assert.Equal(t, flowLogRecord{
+		InterfaceID: "eni-1235b8ca123456789",
+		SrcAddr:     "172.31.16.139",
+		DstAddr:     "10.0.1.20",
+		SrcPort:     20641,
+		DstPort:     80,
+		Protocol:    flowLogsProtocolTCP,
+		Action:      "ACCEPT",
+		LogStatus:   "OK",
+	}, record)
+
+	record, err = parseFlowLogRecord("2 123456789010 eni-1235b8ca123456789 - - - - - - - 1431280876 1431280934 - NODATA")
+	require.NoError(t, err)
+	assert.Equal(t, flowLogRecord{InterfaceID: "eni-1235b8ca123456789", LogStatus: "NODATA"}, record)
+
+	_, err = parseFlowLogRecord("2 123456789010 eni-1235b8ca123456789 172.31.16.139")
+	assert.Error(t, err)
+
+	_, err = parseFlowLogRecord("2 123456789010 eni-1235b8ca123456789 172.31.16.139 10.0.1.20 x 80 6 20 4249 1418530010 1418530070 ACCEPT OK")
+	assert.Error(t, err)
+}
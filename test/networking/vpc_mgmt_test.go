@@ -24,6 +24,8 @@ func TestVpcMgmt(t *testing.T) {
 	testFolder := "../../examples/for-learning-and-testing/networking/vpc-mgmt"
 	terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, awsRegion)
 	terraformOptions.Vars["vpc_name"] = "vpc-mgmt-test-" + random.UniqueId()
+	terraformOptions.Vars["cidr_block"] = test.AllocateCIDRBlock(t, 18)
+	terraformOptions.Vars["num_nat_gateways"] = "1"
 	terraformOptions.Vars["sg_ingress_port"] = port
 
 	defer terraform.Destroy(t, terraformOptions)
@@ -41,4 +43,6 @@ func TestVpcMgmt(t *testing.T) {
 	maxRetries := 30
 	timeBetweenRetries := 5 * time.Second
 	http_helper.HttpGetWithRetry(t, instanceURL, &tlsConfig, 200, instanceText, maxRetries, timeBetweenRetries)
+
+	validateVpcNetworking(t, terraformOptions, awsRegion, []string{"private_subnet_ids"}, []string{"private_subnet_ids"})
 }
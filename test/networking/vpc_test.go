@@ -2,21 +2,34 @@ package networking
 
 import (
 	"crypto/tls"
+	"fmt"
+	"net"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gruntwork-io/aws-service-catalog/test"
 
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/ssh"
 	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
 
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// An IP address that is not in either of the peered VPCs in TestVpcPeering, and that neither VPC has a route to. Used
+// to confirm that only traffic to the peered VPC is routed. This is outside of the default test CIDR pool, so that it
+// is never allocated to a VPC.
+const unpeeredTestIP = "10.127.0.10"
+
 func TestVpc(t *testing.T) {
 	t.Parallel()
 
@@ -25,10 +38,13 @@ func TestVpc(t *testing.T) {
 
 	testFolder := "../../examples/for-learning-and-testing/networking/vpc"
 	terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, awsRegion)
-	terraformOptions.Vars["vpc_name"] = "vpc-test-" + random.UniqueId()
-	terraformOptions.Vars["cidr_block"] = "10.100.0.0/18"
+	vpcName := "vpc-test-" + random.UniqueId()
+	terraformOptions.Vars["vpc_name"] = vpcName
+	terraformOptions.Vars["cidr_block"] = test.AllocateCIDRBlock(t, 18)
 	terraformOptions.Vars["num_nat_gateways"] = "1"
 	terraformOptions.Vars["sg_ingress_port"] = port
+	terraformOptions.Vars["create_flow_logs"] = true
+	terraformOptions.Vars["flow_logs_traffic_type"] = "ALL"
 
 	defer terraform.Destroy(t, terraformOptions)
 
@@ -46,6 +62,11 @@ func TestVpc(t *testing.T) {
 	maxRetries := 30
 	timeBetweenRetries := 5 * time.Second
 	http_helper.HttpGetWithRetry(t, instanceURL, &tlsConfig, 200, instanceText, maxRetries, timeBetweenRetries)
+
+	// Only the private app subnets get internet access: the persistence subnets are kept off the internet by default.
+	validateVpcNetworking(t, terraformOptions, awsRegion, []string{"private_app_subnet_ids"}, []string{"private_app_subnet_ids", "private_persistence_subnet_ids"})
+
+	validateFlowLogs(t, terraformOptions, awsRegion, vpcName, port)
 }
 
 func TestVpcPeering(t *testing.T) {
@@ -55,8 +76,11 @@ func TestVpcPeering(t *testing.T) {
 	//os.Setenv("SKIP_setup", "true")
 	//os.Setenv("SKIP_deploy_vpc_mgmt", "true")
 	//os.Setenv("SKIP_deploy_vpc_app", "true")
+	//os.Setenv("SKIP_validate_peering_routes", "true")
+	//os.Setenv("SKIP_validate_peering_connectivity", "true")
 	//os.Setenv("SKIP_destroy_vpc_app", "true")
 	//os.Setenv("SKIP_destroy_vpc_mgmt", "true")
+	//os.Setenv("SKIP_cleanup_keypair", "true")
 
 	// Create a directory path that won't conflict
 	workingDir := filepath.Join(".", "stages", t.Name())
@@ -65,18 +89,36 @@ func TestVpcPeering(t *testing.T) {
 	vpcMgmtModulePath := filepath.Join(examplesRoot, "for-learning-and-testing/networking/vpc-mgmt")
 	vpcAppModulePath := filepath.Join(examplesRoot, "for-learning-and-testing/networking/vpc")
 
+	defer test_structure.RunTestStage(t, "cleanup_keypair", func() {
+		awsKeyPair := test_structure.LoadEc2KeyPair(t, workingDir)
+		aws.DeleteEC2KeyPair(t, awsKeyPair)
+	})
+
 	test_structure.RunTestStage(t, "setup", func() {
 		awsRegion := aws.GetRandomRegion(t, test.RegionsForEc2Tests, nil)
 		test_structure.SaveString(t, workingDir, "awsRegion", awsRegion)
 
 		uniqueID := random.UniqueId()
 		test_structure.SaveString(t, workingDir, "uniqueID", uniqueID)
+
+		awsKeyPair := aws.CreateAndImportEC2KeyPair(t, awsRegion, uniqueID)
+		test_structure.SaveEc2KeyPair(t, workingDir, awsKeyPair)
+
+		// The peered VPCs must not overlap with each other, or with the VPCs of other tests running in parallel.
+		test_structure.SaveString(t, workingDir, "mgmtVpcCidrBlock", test.AllocateCIDRBlock(t, 16))
+		test_structure.SaveString(t, workingDir, "appVpcCidrBlock", test.AllocateCIDRBlock(t, 16))
 	})
 	awsRegion := test_structure.LoadString(t, workingDir, "awsRegion")
 	uniqueID := test_structure.LoadString(t, workingDir, "uniqueID")
+	awsKeyPair := test_structure.LoadEc2KeyPair(t, workingDir)
+	// Reserve the blocks again in case the setup stage that allocated them was skipped, as the VPCs still use them.
+	mgmtVpcAllocatedCidrBlock := test.ReserveCIDRBlock(t, test_structure.LoadString(t, workingDir, "mgmtVpcCidrBlock"))
+	appVpcAllocatedCidrBlock := test.ReserveCIDRBlock(t, test_structure.LoadString(t, workingDir, "appVpcCidrBlock"))
 
 	vpcMgmtTFOptions := test.CreateBaseTerraformOptions(t, vpcMgmtModulePath, awsRegion)
 	vpcMgmtTFOptions.Vars["vpc_name"] = "scvpc-peering-test-mgmt-" + uniqueID
+	vpcMgmtTFOptions.Vars["cidr_block"] = mgmtVpcAllocatedCidrBlock
+	vpcMgmtTFOptions.Vars["keypair_name"] = awsKeyPair.Name
 
 	defer test_structure.RunTestStage(t, "destroy_vpc_mgmt", func() {
 		terraform.Destroy(t, vpcMgmtTFOptions)
@@ -92,7 +134,7 @@ func TestVpcPeering(t *testing.T) {
 	mgmtVpcPublicSubnetIDs := terraform.OutputList(t, vpcMgmtTFOptions, "public_subnet_ids")
 	vpcAppTFOptions := test.CreateBaseTerraformOptions(t, vpcAppModulePath, awsRegion)
 	vpcAppTFOptions.Vars["vpc_name"] = "scvpc-peering-test-app-" + uniqueID
-	vpcAppTFOptions.Vars["cidr_block"] = "10.1.0.0/16"
+	vpcAppTFOptions.Vars["cidr_block"] = appVpcAllocatedCidrBlock
 	vpcAppTFOptions.Vars["create_peering_connection"] = true
 	vpcAppTFOptions.Vars["create_flow_logs"] = false
 	vpcAppTFOptions.Vars["origin_vpc_id"] = mgmtVpcID
@@ -107,4 +149,77 @@ func TestVpcPeering(t *testing.T) {
 	test_structure.RunTestStage(t, "deploy_vpc_app", func() {
 		terraform.InitAndApply(t, vpcAppTFOptions)
 	})
+
+	test_structure.RunTestStage(t, "validate_peering_routes", func() {
+		appVpcCidrBlock := terraform.Output(t, vpcAppTFOptions, "vpc_cidr_block")
+		validatePeeringRoutes(t, awsRegion, mgmtVpcRouteTableIDs, appVpcCidrBlock)
+	})
+
+	test_structure.RunTestStage(t, "validate_peering_connectivity", func() {
+		mgmtInstanceIP := terraform.Output(t, vpcMgmtTFOptions, "instance_ip")
+		appInstancePrivateIP := terraform.Output(t, vpcAppTFOptions, "instance_private_ip")
+		appInstancePort := vpcAppTFOptions.Vars["sg_ingress_port"]
+		if appInstancePort == nil {
+			// Use the default of the vpc example.
+			appInstancePort = 8080
+		}
+
+		for _, cidrBlock := range []string{mgmtVpcCidrBlock, terraform.Output(t, vpcAppTFOptions, "vpc_cidr_block")} {
+			_, network, err := net.ParseCIDR(cidrBlock)
+			require.NoError(t, err)
+			require.Falsef(t, network.Contains(net.ParseIP(unpeeredTestIP)), "%s is in the peered CIDR block %s", unpeeredTestIP, cidrBlock)
+		}
+
+		mgmtHost := ssh.Host{
+			Hostname:    mgmtInstanceIP,
+			SshKeyPair:  awsKeyPair.KeyPair,
+			SshUserName: "ubuntu",
+		}
+
+		// The instance in the app VPC runs a web server that responds with "Hello, World". We hit it from the instance in
+		// the mgmt VPC on its private IP, which is only routable through the peering connection. We wrap the SSH call in
+		// a retry to account for delays in SSH bootup on the instance. Try for up to 5 minutes.
+		peeredURL := fmt.Sprintf("http://%s:%v", appInstancePrivateIP, appInstancePort)
+		out := retry.DoWithRetry(
+			t,
+			fmt.Sprintf("reach %s from mgmt instance %s", peeredURL, mgmtInstanceIP),
+			30,
+			10*time.Second,
+			func() (string, error) {
+				return ssh.CheckSshCommandE(t, mgmtHost, fmt.Sprintf("curl --silent --show-error --max-time 5 %s", peeredURL))
+			},
+		)
+		assert.Equal(t, "Hello, World", strings.TrimSpace(out))
+
+		// Traffic to a CIDR block that is not peered should not get through.
+		_, err := ssh.CheckSshCommandE(t, mgmtHost, fmt.Sprintf("curl --silent --show-error --max-time 5 http://%s:%v", unpeeredTestIP, appInstancePort))
+		assert.Error(t, err)
+	})
+}
+
+// validatePeeringRoutes checks that each of the given route tables has an active route to the destination CIDR block
+// through a VPC peering connection.
+func validatePeeringRoutes(t *testing.T, awsRegion string, routeTableIDs []string, destinationCidrBlock string) {
+	require.NotEmpty(t, routeTableIDs)
+
+	client := aws.NewEc2Client(t, awsRegion)
+	output, err := client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		RouteTableIds: awsgo.StringSlice(routeTableIDs),
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(routeTableIDs), len(output.RouteTables))
+
+	for _, routeTable := range output.RouteTables {
+		var peeringRoute *ec2.Route
+		for _, route := range routeTable.Routes {
+			if awsgo.StringValue(route.DestinationCidrBlock) == destinationCidrBlock {
+				peeringRoute = route
+			}
+		}
+		routeTableID := awsgo.StringValue(routeTable.RouteTableId)
+		if assert.NotNilf(t, peeringRoute, "Route table %s has no route to %s", routeTableID, destinationCidrBlock) {
+			assert.Regexpf(t, "^pcx-", awsgo.StringValue(peeringRoute.VpcPeeringConnectionId), "Route to %s in route table %s is not through a peering connection", destinationCidrBlock, routeTableID)
+			assert.Equal(t, ec2.RouteStateActive, awsgo.StringValue(peeringRoute.State))
+		}
+	}
 }
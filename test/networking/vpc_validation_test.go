@@ -0,0 +1,137 @@
+package networking
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// validateVpcNetworking runs the checks that both the vpc and vpc-mgmt examples are held to:
+//
+//   - The VPC has exactly as many NAT gateways as the num_nat_gateways input asks for.
+//   - Every subnet in egressSubnetOutputs routes internet traffic through one of those NAT gateways.
+//   - The default security group of the VPC has no rules left.
+//   - The public subnets and the subnets of every tier in privateSubnetOutputs are spread across num_availability_zones
+//     distinct availability zones.
+//
+// egressSubnetOutputs and privateSubnetOutputs are names of outputs of the example that hold lists of subnet IDs.
+func validateVpcNetworking(t *testing.T, terraformOptions *terraform.Options, awsRegion string, egressSubnetOutputs []string, privateSubnetOutputs []string) {
+	vpcID := terraform.Output(t, terraformOptions, "vpc_id")
+
+	expectedNumNatGateways, err := strconv.Atoi(fmt.Sprint(terraformOptions.Vars["num_nat_gateways"]))
+	require.NoError(t, err, "num_nat_gateways must be set on the terraform options")
+	natGatewayIDs := validateNatGateways(t, awsRegion, vpcID, expectedNumNatGateways)
+
+	for _, output := range egressSubnetOutputs {
+		validateSubnetsEgressThroughNat(t, awsRegion, terraform.OutputList(t, terraformOptions, output), natGatewayIDs)
+	}
+
+	validateDefaultSecurityGroupStripped(t, awsRegion, vpcID)
+
+	numAvailabilityZones, err := strconv.Atoi(terraform.Output(t, terraformOptions, "num_availability_zones"))
+	require.NoError(t, err)
+	for _, output := range append([]string{"public_subnet_ids"}, privateSubnetOutputs...) {
+		validateSubnetsSpreadAcrossAZs(t, awsRegion, output, terraform.OutputList(t, terraformOptions, output), numAvailabilityZones)
+	}
+}
+
+// validateNatGateways checks that the VPC has the expected number of available NAT gateways, each with a public IP, and
+// returns their IDs.
+func validateNatGateways(t *testing.T, awsRegion string, vpcID string, expectedCount int) []string {
+	client := aws.NewEc2Client(t, awsRegion)
+	output, err := client.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{
+		Filter: []*ec2.Filter{
+			{Name: awsgo.String("vpc-id"), Values: awsgo.StringSlice([]string{vpcID})},
+			{Name: awsgo.String("state"), Values: awsgo.StringSlice([]string{ec2.NatGatewayStateAvailable})},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, output.NatGateways, expectedCount, "Unexpected number of NAT gateways in VPC %s", vpcID)
+
+	natGatewayIDs := []string{}
+	for _, natGateway := range output.NatGateways {
+		natGatewayID := awsgo.StringValue(natGateway.NatGatewayId)
+		natGatewayIDs = append(natGatewayIDs, natGatewayID)
+
+		hasPublicIP := false
+		for _, address := range natGateway.NatGatewayAddresses {
+			hasPublicIP = hasPublicIP || awsgo.StringValue(address.PublicIp) != ""
+		}
+		assert.True(t, hasPublicIP, "NAT gateway %s has no public IP", natGatewayID)
+	}
+	return natGatewayIDs
+}
+
+// validateSubnetsEgressThroughNat checks that each of the given subnets is explicitly associated with a route table that
+// sends internet traffic (0.0.0.0/0) to one of the given NAT gateways. Subnets without an explicit association would fall
+// back to the main route table of the VPC, which the modules don't manage, so they count as a failure.
+func validateSubnetsEgressThroughNat(t *testing.T, awsRegion string, subnetIDs []string, natGatewayIDs []string) {
+	require.NotEmpty(t, subnetIDs)
+
+	client := aws.NewEc2Client(t, awsRegion)
+	for _, subnetID := range subnetIDs {
+		output, err := client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+			Filters: []*ec2.Filter{
+				{Name: awsgo.String("association.subnet-id"), Values: awsgo.StringSlice([]string{subnetID})},
+			},
+		})
+		require.NoError(t, err)
+		if !assert.Len(t, output.RouteTables, 1, "Subnet %s is not associated with a route table", subnetID) {
+			continue
+		}
+
+		var defaultRoute *ec2.Route
+		for _, route := range output.RouteTables[0].Routes {
+			if awsgo.StringValue(route.DestinationCidrBlock) == "0.0.0.0/0" {
+				defaultRoute = route
+			}
+		}
+		if !assert.NotNil(t, defaultRoute, "Route table %s of subnet %s has no route to 0.0.0.0/0", awsgo.StringValue(output.RouteTables[0].RouteTableId), subnetID) {
+			continue
+		}
+		assert.Contains(t, natGatewayIDs, awsgo.StringValue(defaultRoute.NatGatewayId), "Subnet %s does not route internet traffic through a NAT gateway of the VPC", subnetID)
+		assert.Equal(t, ec2.RouteStateActive, awsgo.StringValue(defaultRoute.State))
+	}
+}
+
+// validateDefaultSecurityGroupStripped checks that the default security group of the VPC has no ingress or egress
+// rules, so that resources that end up in it by mistake can't send or receive any traffic.
+func validateDefaultSecurityGroupStripped(t *testing.T, awsRegion string, vpcID string) {
+	client := aws.NewEc2Client(t, awsRegion)
+	output, err := client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{Name: awsgo.String("vpc-id"), Values: awsgo.StringSlice([]string{vpcID})},
+			{Name: awsgo.String("group-name"), Values: awsgo.StringSlice([]string{"default"})},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, output.SecurityGroups, 1)
+
+	securityGroup := output.SecurityGroups[0]
+	assert.Empty(t, securityGroup.IpPermissions, "Default security group %s has ingress rules", awsgo.StringValue(securityGroup.GroupId))
+	assert.Empty(t, securityGroup.IpPermissionsEgress, "Default security group %s has egress rules", awsgo.StringValue(securityGroup.GroupId))
+}
+
+// validateSubnetsSpreadAcrossAZs checks that the given tier has one subnet in each of numAvailabilityZones distinct
+// availability zones.
+func validateSubnetsSpreadAcrossAZs(t *testing.T, awsRegion string, tierName string, subnetIDs []string, numAvailabilityZones int) {
+	require.Len(t, subnetIDs, numAvailabilityZones, "Unexpected number of subnets in %s", tierName)
+
+	client := aws.NewEc2Client(t, awsRegion)
+	output, err := client.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: awsgo.StringSlice(subnetIDs)})
+	require.NoError(t, err)
+
+	subnetsByAZ := map[string][]string{}
+	for _, subnet := range output.Subnets {
+		az := awsgo.StringValue(subnet.AvailabilityZone)
+		subnetsByAZ[az] = append(subnetsByAZ[az], awsgo.StringValue(subnet.SubnetId))
+	}
+	assert.Len(t, subnetsByAZ, numAvailabilityZones, "Subnets in %s are not spread across distinct availability zones: %v", tierName, subnetsByAZ)
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gruntwork-io/aws-service-catalog/test"
+)
+
+const (
+	// The aws-auth-merger watches for ConfigMaps in its namespace, and merges them into the aws-auth ConfigMap. It
+	// takes a little while to pick up changes, and EKS takes a little while to pick up the new aws-auth ConfigMap.
+	awsAuthMergerRetries = 30
+	awsAuthMergerSleep   = 10 * time.Second
+)
+
+// validateAWSAuthMergerAccessControl onboards a team to the cluster the same way a team would be onboarded to a shared
+// cluster: an IAM role of the team is mapped to a Kubernetes group with a ConfigMap in the namespace of the
+// aws-auth-merger, and the group is bound to a Role in the namespace of the team. The test then assumes the IAM role
+// and checks that it can do exactly what the Role allows: everything in the namespace of the team, and nothing
+// elsewhere. Finally, it removes the ConfigMap and checks that the IAM role loses access to the cluster.
+// parentWorkingDir should be the working dir of the overarching test, and is where the global options like region and
+// AMI are stored.
+// workingDir should be the working dir of the subtest, and is where local options like the terraform options are
+// stored.
+func validateAWSAuthMergerAccessControl(t *testing.T, parentWorkingDir string, workingDir string) {
+	awsRegion := test_structure.LoadString(t, parentWorkingDir, "region")
+	eksClusterOptions := test_structure.LoadTerraformOptions(t, workingDir)
+	eksClusterArn := terraform.OutputRequired(t, eksClusterOptions, "eks_cluster_arn")
+	mergerNamespace := terraform.OutputRequired(t, eksClusterOptions, "aws_auth_merger_namespace")
+
+	uniqueID := strings.ToLower(random.UniqueId())
+	teamName := fmt.Sprintf("team-%s", uniqueID)
+	teamGroup := fmt.Sprintf("%s-developers", teamName)
+	otherNamespace := fmt.Sprintf("other-%s", uniqueID)
+
+	roleArn := createAssumableIAMRole(t, awsRegion, fmt.Sprintf("eks-service-catalog-%s", teamName))
+	defer deleteIAMRole(t, awsRegion, roleArn)
+
+	// Set up the namespaces and RBAC with admin credentials.
+	adminOptions := loadEKSKubectlOptions(t, workingDir)
+	k8s.CreateNamespace(t, adminOptions, teamName)
+	defer func() {
+		k8s.DeleteNamespace(t, loadEKSKubectlOptions(t, workingDir), teamName)
+	}()
+	k8s.CreateNamespace(t, adminOptions, otherNamespace)
+	defer func() {
+		k8s.DeleteNamespace(t, loadEKSKubectlOptions(t, workingDir), otherNamespace)
+	}()
+	createNamespaceAdminRole(t, adminOptions, teamName, teamGroup)
+
+	adminClientset, err := k8s.GetKubernetesClientFromOptionsE(t, adminOptions)
+	require.NoError(t, err)
+	mappingConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: teamName, Namespace: mergerNamespace},
+		Data: map[string]string{
+			"mapRoles": fmt.Sprintf("- rolearn: %s\n  username: %s\n  groups:\n    - %s\n", roleArn, teamName, teamGroup),
+		},
+	}
+	_, err = adminClientset.CoreV1().ConfigMaps(mergerNamespace).Create(context.Background(), mappingConfigMap, metav1.CreateOptions{})
+	require.NoError(t, err)
+	configMapDeleted := false
+	defer func() {
+		if !configMapDeleted {
+			deleteAWSAuthMergerConfigMap(t, loadEKSKubectlOptions(t, workingDir), mergerNamespace, teamName)
+		}
+	}()
+
+	// Wait for the mapping to be merged into aws-auth, and for EKS to let the IAM role in.
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Wait for IAM role %s to be mapped into the cluster", roleArn),
+		awsAuthMergerRetries,
+		awsAuthMergerSleep,
+		func() (string, error) {
+			roleOptions, err := test.ConfigureKubectlForEKSClusterAsRoleE(t, eksClusterArn, roleArn)
+			if err != nil {
+				return "", err
+			}
+			clientset, err := k8s.GetKubernetesClientFromOptionsE(t, roleOptions)
+			if err != nil {
+				return "", err
+			}
+			if _, err := clientset.CoreV1().ConfigMaps(teamName).List(context.Background(), metav1.ListOptions{}); err != nil {
+				return "", err
+			}
+			return "IAM role mapped", nil
+		},
+	)
+	assert.Contains(t, getAWSAuthMapRoles(t, loadEKSKubectlOptions(t, workingDir)), roleArn)
+
+	roleOptions := test.ConfigureKubectlForEKSClusterAsRole(t, eksClusterArn, roleArn)
+	allowedActions := []authv1.ResourceAttributes{
+		{Namespace: teamName, Verb: "create", Resource: "pods"},
+		{Namespace: teamName, Verb: "delete", Resource: "deployments", Group: "apps"},
+		{Namespace: teamName, Verb: "list", Resource: "configmaps"},
+	}
+	forbiddenActions := []authv1.ResourceAttributes{
+		{Namespace: otherNamespace, Verb: "create", Resource: "pods"},
+		{Namespace: otherNamespace, Verb: "list", Resource: "configmaps"},
+		{Namespace: "kube-system", Verb: "get", Resource: "configmaps", Name: "aws-auth"},
+		{Namespace: mergerNamespace, Verb: "create", Resource: "configmaps"},
+		{Verb: "list", Resource: "nodes"},
+		{Verb: "create", Resource: "namespaces"},
+	}
+	for _, action := range allowedActions {
+		assert.Truef(t, k8s.CanIDo(t, roleOptions, action), "IAM role should be allowed to %s %s in namespace '%s'", action.Verb, action.Resource, action.Namespace)
+	}
+	for _, action := range forbiddenActions {
+		assert.Falsef(t, k8s.CanIDo(t, roleOptions, action), "IAM role should not be allowed to %s %s in namespace '%s'", action.Verb, action.Resource, action.Namespace)
+	}
+
+	// Besides asking the API server, actually make requests as the IAM role.
+	roleClientset, err := k8s.GetKubernetesClientFromOptionsE(t, roleOptions)
+	require.NoError(t, err)
+	probeConfigMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "access-check"}}
+	_, err = roleClientset.CoreV1().ConfigMaps(teamName).Create(context.Background(), probeConfigMap, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	_, err = roleClientset.CoreV1().ConfigMaps(otherNamespace).Create(context.Background(), probeConfigMap, metav1.CreateOptions{})
+	assert.Truef(t, apierrors.IsForbidden(err), "Expected creating a ConfigMap in namespace %s to be forbidden, but got: %v", otherNamespace, err)
+
+	// Offboard the team, and check that the IAM role can no longer authenticate to the cluster at all.
+	deleteAWSAuthMergerConfigMap(t, loadEKSKubectlOptions(t, workingDir), mergerNamespace, teamName)
+	configMapDeleted = true
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Wait for IAM role %s to lose access to the cluster", roleArn),
+		awsAuthMergerRetries,
+		awsAuthMergerSleep,
+		func() (string, error) {
+			roleOptions, err := test.ConfigureKubectlForEKSClusterAsRoleE(t, eksClusterArn, roleArn)
+			if err != nil {
+				return "", err
+			}
+			clientset, err := k8s.GetKubernetesClientFromOptionsE(t, roleOptions)
+			if err != nil {
+				return "", err
+			}
+			_, err = clientset.CoreV1().ConfigMaps(teamName).List(context.Background(), metav1.ListOptions{})
+			if !apierrors.IsUnauthorized(err) {
+				return "", fmt.Errorf("Expected IAM role to be unauthorized, but got: %v", err)
+			}
+			return "IAM role unmapped", nil
+		},
+	)
+	assert.NotContains(t, getAWSAuthMapRoles(t, loadEKSKubectlOptions(t, workingDir)), roleArn)
+}
+
+// createAssumableIAMRole creates an IAM role without any permissions that the current account can assume, and returns
+// its ARN. The role is only used to authenticate to the cluster, which requires no IAM permissions.
+func createAssumableIAMRole(t *testing.T, awsRegion string, roleName string) string {
+	accountID := aws.GetAccountId(t)
+	assumeRolePolicy := fmt.Sprintf(`{
+  "Version": "2012-10-17",
+  "Statement": [{
+    "Effect": "Allow",
+    "Principal": {"AWS": "arn:aws:iam::%s:root"},
+    "Action": "sts:AssumeRole"
+  }]
+}`, accountID)
+
+	sess, err := aws.NewAuthenticatedSession(awsRegion)
+	require.NoError(t, err)
+	output, err := iam.New(sess).CreateRole(&iam.CreateRoleInput{
+		RoleName:                 awsgo.String(roleName),
+		AssumeRolePolicyDocument: awsgo.String(assumeRolePolicy),
+	})
+	require.NoError(t, err)
+	return awsgo.StringValue(output.Role.Arn)
+}
+
+func deleteIAMRole(t *testing.T, awsRegion string, roleArn string) {
+	sess, err := aws.NewAuthenticatedSession(awsRegion)
+	require.NoError(t, err)
+	_, err = iam.New(sess).DeleteRole(&iam.DeleteRoleInput{RoleName: awsgo.String(getIAMRoleNameFromArn(t, roleArn))})
+	require.NoError(t, err)
+}
+
+// createNamespaceAdminRole creates a Role that allows everything in the given namespace, and binds it to the given
+// group.
+func createNamespaceAdminRole(t *testing.T, kubectlOptions *k8s.KubectlOptions, namespace string, group string) {
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, kubectlOptions)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "namespace-admin", Namespace: namespace},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{"*"},
+			Resources: []string{"*"},
+			Verbs:     []string{"*"},
+		}},
+	}
+	_, err = clientset.RbacV1().Roles(namespace).Create(ctx, role, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "namespace-admin", Namespace: namespace},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name},
+		Subjects:   []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.GroupKind, Name: group}},
+	}
+	_, err = clientset.RbacV1().RoleBindings(namespace).Create(ctx, roleBinding, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func deleteAWSAuthMergerConfigMap(t *testing.T, kubectlOptions *k8s.KubectlOptions, namespace string, name string) {
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, kubectlOptions)
+	require.NoError(t, err)
+	require.NoError(t, clientset.CoreV1().ConfigMaps(namespace).Delete(context.Background(), name, metav1.DeleteOptions{}))
+}
+
+// getAWSAuthMapRoles returns the role mappings of the aws-auth ConfigMap that EKS authenticates IAM roles with.
+func getAWSAuthMapRoles(t *testing.T, kubectlOptions *k8s.KubectlOptions) string {
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, kubectlOptions)
+	require.NoError(t, err)
+	awsAuth, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "aws-auth", metav1.GetOptions{})
+	require.NoError(t, err)
+	return awsAuth.Data["mapRoles"]
+}
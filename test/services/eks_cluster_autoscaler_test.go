@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// The image of the pods that take up the capacity of the workers. It does nothing, so all that matters is the
+	// resources the pods request.
+	autoscalerWorkloadImage = "k8s.gcr.io/pause:3.2"
+
+	// The share of the allocatable CPU of a worker that each pod of the workload requests. This is more than half, so
+	// that no two pods fit on the same worker.
+	autoscalerWorkloadCPUShare = 0.55
+
+	// The workload is scheduled as soon as a new worker is ready, but the cluster autoscaler only scales in after
+	// autoscaler_scale_down_unneeded_time and autoscaler_down_delay_after_add (2m each in the example), so give
+	// scale in more time.
+	autoscalerScaleOutRetries = 60
+	autoscalerScaleInRetries  = 80
+	autoscalerSleep           = 10 * time.Second
+)
+
+// validateClusterAutoscaler checks that the cluster autoscaler adds a worker when pods can't be scheduled on the
+// current workers, and removes it again once the pods are gone. The time each takes is logged, so that the
+// responsiveness of the cluster autoscaler can be compared across versions.
+// workingDir should be the working dir of the subtest, and is where local options like the terraform options are
+// stored.
+func validateClusterAutoscaler(t *testing.T, workingDir string) {
+	kubectlOptions := loadEKSKubectlOptions(t, workingDir)
+	autoscalerImage := getClusterAutoscalerImage(t, kubectlOptions)
+
+	baselineNumNodes := len(k8s.GetNodes(t, kubectlOptions))
+	workers := getEC2WorkerNodes(t, kubectlOptions)
+	require.NotEmpty(t, workers)
+
+	// Request one more pod than there are workers, where each pod needs a worker to itself, so that exactly one pod is
+	// left pending until a worker is added.
+	cpuRequest := getMinAllocatableCPUMillis(workers) * autoscalerWorkloadCPUShare
+	numPods := int32(len(workers) + 1)
+
+	namespace := fmt.Sprintf("autoscaler-%s", strings.ToLower(random.UniqueId()))
+	k8s.CreateNamespace(t, kubectlOptions, namespace)
+	// Scaling out and in takes longer than the token in the kubectl options is valid, so load new options for each step.
+	defer func() {
+		k8s.DeleteNamespace(t, loadEKSKubectlOptions(t, workingDir), namespace)
+	}()
+	namespaceOptions := loadEKSKubectlOptions(t, workingDir)
+	namespaceOptions.Namespace = namespace
+
+	deploymentName := "capacity-hog"
+	logger.Logf(t, "Deploying %d pods requesting %dm CPU each on %d workers", numPods, int64(cpuRequest), len(workers))
+	scaleOutStart := time.Now()
+	createCapacityHogDeployment(t, namespaceOptions, deploymentName, numPods, int64(cpuRequest))
+
+	kubeWaitUntilNumNodes(t, loadEKSKubectlOptions(t, workingDir), baselineNumNodes+1, autoscalerScaleOutRetries, autoscalerSleep)
+	scaleOutNodeRegistered := time.Since(scaleOutStart)
+	waitUntilDeploymentPodsAvailable(t, loadEKSKubectlOptions(t, workingDir), namespace, deploymentName, int(numPods))
+	scaleOutPodsAvailable := time.Since(scaleOutStart)
+
+	scaleInStart := time.Now()
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, loadEKSKubectlOptions(t, workingDir))
+	require.NoError(t, err)
+	require.NoError(t, clientset.AppsV1().Deployments(namespace).Delete(context.Background(), deploymentName, metav1.DeleteOptions{}))
+
+	kubeWaitUntilNumNodes(t, loadEKSKubectlOptions(t, workingDir), baselineNumNodes, autoscalerScaleInRetries, autoscalerSleep)
+	scaleIn := time.Since(scaleInStart)
+
+	// Log the timings on a single line, so that they're easy to find in the test output.
+	logger.Logf(
+		t,
+		"Cluster autoscaler timings (%s): scale out node registered after %s, pods available after %s; scale in after %s",
+		autoscalerImage,
+		scaleOutNodeRegistered.Round(time.Second),
+		scaleOutPodsAvailable.Round(time.Second),
+		scaleIn.Round(time.Second),
+	)
+}
+
+// getClusterAutoscalerImage returns the image (including the version) of the cluster autoscaler, to label the timings.
+func getClusterAutoscalerImage(t *testing.T, kubectlOptions *k8s.KubectlOptions) string {
+	options := *kubectlOptions
+	options.Namespace = "kube-system"
+	pods := k8s.ListPods(t, &options, metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/instance=cluster-autoscaler,app.kubernetes.io/name=aws-cluster-autoscaler",
+	})
+	require.NotEmpty(t, pods, "Found no pods of the cluster autoscaler")
+	return pods[0].Spec.Containers[0].Image
+}
+
+// getEC2WorkerNodes returns the nodes that run on EC2 instances, as opposed to Fargate.
+func getEC2WorkerNodes(t *testing.T, kubectlOptions *k8s.KubectlOptions) []corev1.Node {
+	workers := []corev1.Node{}
+	for _, node := range k8s.GetNodes(t, kubectlOptions) {
+		if getEKSNodeCategory(node) != fargateNodeCategory {
+			workers = append(workers, node)
+		}
+	}
+	return workers
+}
+
+func getMinAllocatableCPUMillis(nodes []corev1.Node) float64 {
+	var minCPU int64
+	for i, node := range nodes {
+		cpu := node.Status.Allocatable.Cpu().MilliValue()
+		if i == 0 || cpu < minCPU {
+			minCPU = cpu
+		}
+	}
+	return float64(minCPU)
+}
+
+// createCapacityHogDeployment creates a deployment of pods that do nothing but request the given amount of CPU. The
+// pods are kept off Fargate, as Fargate provisions capacity for each pod without the cluster autoscaler.
+func createCapacityHogDeployment(t *testing.T, kubectlOptions *k8s.KubectlOptions, name string, numPods int32, cpuMillis int64) {
+	labels := map[string]string{"app": name}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: kubectlOptions.Namespace},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &numPods,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Affinity: &corev1.Affinity{
+						NodeAffinity: &corev1.NodeAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+								NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+									MatchExpressions: []corev1.NodeSelectorRequirement{{
+										Key:      computeTypeLabel,
+										Operator: corev1.NodeSelectorOpNotIn,
+										Values:   []string{"fargate"},
+									}},
+								}},
+							},
+						},
+					},
+					Containers: []corev1.Container{{
+						Name:  "pause",
+						Image: autoscalerWorkloadImage,
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU: *resource.NewMilliQuantity(cpuMillis, resource.DecimalSI),
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, kubectlOptions)
+	require.NoError(t, err)
+	_, err = clientset.AppsV1().Deployments(kubectlOptions.Namespace).Create(context.Background(), deployment, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+// waitUntilDeploymentPodsAvailable waits until the given number of pods of the given deployment are available.
+func waitUntilDeploymentPodsAvailable(t *testing.T, kubectlOptions *k8s.KubectlOptions, namespace string, name string, numPods int) {
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, kubectlOptions)
+	require.NoError(t, err)
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Wait for %d pods of deployment %s to be available", numPods, name),
+		autoscalerScaleOutRetries,
+		autoscalerSleep,
+		func() (string, error) {
+			deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			if err != nil {
+				return "", err
+			}
+			if int(deployment.Status.AvailableReplicas) != numPods {
+				return "", fmt.Errorf("%d of %d pods available", deployment.Status.AvailableReplicas, numPods)
+			}
+			return "All pods available", nil
+		},
+	)
+}
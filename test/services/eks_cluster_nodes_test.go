@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// The categories of nodes in an EKS cluster. Each category is provisioned by a different mechanism, so the expected
+// number of nodes is derived separately for each.
+const (
+	selfManagedNodeCategory      = "self-managed workers"
+	managedNodeGroupNodeCategory = "managed node group workers"
+	fargateNodeCategory          = "Fargate pods"
+)
+
+var eksNodeCategories = []string{selfManagedNodeCategory, managedNodeGroupNodeCategory, fargateNodeCategory}
+
+const (
+	// The labels EKS puts on the nodes of managed node groups, and on Fargate nodes.
+	managedNodeGroupLabel = "eks.amazonaws.com/nodegroup"
+	computeTypeLabel      = "eks.amazonaws.com/compute-type"
+
+	// The scheduler EKS assigns to the pods that match a Fargate profile. Each of those pods runs on a node of its own.
+	fargateSchedulerName = "fargate-scheduler"
+)
+
+// eksNodeCount is the number of nodes of a category: the nodes that have registered with the cluster, and how many of
+// them are ready.
+type eksNodeCount struct {
+	registered int
+	ready      int
+}
+
+// eksWorkerGroupConfiguration is the part of an entry in the autoscaling_group_configurations or
+// managed_node_group_configurations input of the eks-cluster module that determines how many workers it starts with.
+type eksWorkerGroupConfiguration struct {
+	MinSize int `json:"min_size"`
+}
+
+// Validate that each category of nodes of the deployed EKS cluster has the expected number of ready nodes. Rather than
+// hard coding a total, the expected number of workers is derived from the worker groups the example passes to the
+// eks-cluster module, as each group starts out with its minimum size. The cluster autoscaler can add workers later on,
+// so this should run before the core services are deployed. The Fargate profiles run each of the pods they schedule on
+// a node of its own, so one Fargate node is expected for each of those pods.
+// workingDir should be the working dir of the subtest, and is where local options like the terraform options are
+// stored.
+func validateEKSCluster(t *testing.T, workingDir string) {
+	terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
+	eksClusterArn := terraform.OutputRequired(t, terraformOptions, "eks_cluster_arn")
+	clusterName := terraform.OutputRequired(t, terraformOptions, "eks_cluster_name")
+	parsedArn, err := arn.Parse(eksClusterArn)
+	require.NoError(t, err)
+	sess, err := aws.NewAuthenticatedSession(parsedArn.Region)
+	require.NoError(t, err)
+
+	asgConfigurations := map[string]eksWorkerGroupConfiguration{}
+	terraform.OutputStruct(t, terraformOptions, "autoscaling_group_configurations", &asgConfigurations)
+	nodeGroupConfigurations := map[string]eksWorkerGroupConfiguration{}
+	terraform.OutputStruct(t, terraformOptions, "managed_node_group_configurations", &nodeGroupConfigurations)
+
+	kubectlOptions := loadEKSKubectlOptions(t, workingDir)
+	message := retry.DoWithRetry(
+		t,
+		"Wait for the expected number of nodes of each category to be ready",
+		30,
+		10*time.Second,
+		func() (string, error) {
+			fargatePods, err := getFargateProfilePodsE(t, eks.New(sess), kubectlOptions, clusterName)
+			if err != nil {
+				return "", err
+			}
+			expected := map[string]int{
+				selfManagedNodeCategory:      sumEKSWorkerGroupMinSizes(asgConfigurations),
+				managedNodeGroupNodeCategory: sumEKSWorkerGroupMinSizes(nodeGroupConfigurations),
+				fargateNodeCategory:          len(fargatePods),
+			}
+			nodes, err := k8s.GetNodesE(t, kubectlOptions)
+			if err != nil {
+				return "", err
+			}
+			actual := countEKSNodesByCategory(nodes)
+			if mismatches := describeEKSNodeCountMismatches(expected, actual); len(mismatches) > 0 {
+				return "", fmt.Errorf("Unexpected number of nodes: %s", strings.Join(mismatches, "; "))
+			}
+			return fmt.Sprintf("All nodes ready: %v", expected), nil
+		},
+	)
+	logger.Logf(t, message)
+}
+
+// sumEKSWorkerGroupMinSizes returns the number of workers the given worker groups start out with.
+func sumEKSWorkerGroupMinSizes(configurations map[string]eksWorkerGroupConfiguration) int {
+	total := 0
+	for _, configuration := range configurations {
+		total += configuration.MinSize
+	}
+	return total
+}
+
+// getFargateProfilePodsE returns the names (as NAMESPACE/NAME) of the running pods that the Fargate profiles of the
+// cluster schedule on Fargate.
+func getFargateProfilePodsE(t *testing.T, eksClient *eks.EKS, kubectlOptions *k8s.KubectlOptions, clusterName string) (map[string]bool, error) {
+	profileNames := []*string{}
+	err := eksClient.ListFargateProfilesPages(
+		&eks.ListFargateProfilesInput{ClusterName: awsgo.String(clusterName)},
+		func(page *eks.ListFargateProfilesOutput, lastPage bool) bool {
+			profileNames = append(profileNames, page.FargateProfileNames...)
+			return true
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, kubectlOptions)
+	if err != nil {
+		return nil, err
+	}
+	pods := map[string]bool{}
+	for _, profileName := range profileNames {
+		output, err := eksClient.DescribeFargateProfile(&eks.DescribeFargateProfileInput{
+			ClusterName:        awsgo.String(clusterName),
+			FargateProfileName: profileName,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, selector := range output.FargateProfile.Selectors {
+			podList, err := clientset.CoreV1().Pods(awsgo.StringValue(selector.Namespace)).List(
+				context.Background(),
+				metav1.ListOptions{LabelSelector: labels.SelectorFromSet(awsgo.StringValueMap(selector.Labels)).String()},
+			)
+			if err != nil {
+				return nil, err
+			}
+			for _, pod := range podList.Items {
+				if isScheduledOnFargate(pod) {
+					pods[fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)] = true
+				}
+			}
+		}
+	}
+	return pods, nil
+}
+
+// isScheduledOnFargate returns true if the pod was handed to the Fargate scheduler and still needs a node.
+func isScheduledOnFargate(pod corev1.Pod) bool {
+	return pod.Spec.SchedulerName == fargateSchedulerName &&
+		pod.DeletionTimestamp == nil &&
+		pod.Status.Phase != corev1.PodSucceeded &&
+		pod.Status.Phase != corev1.PodFailed
+}
+
+// getEKSNodeCategory returns the category of the given node, based on the labels EKS puts on the node.
+func getEKSNodeCategory(node corev1.Node) string {
+	if node.Labels[computeTypeLabel] == "fargate" {
+		return fargateNodeCategory
+	}
+	if _, isManaged := node.Labels[managedNodeGroupLabel]; isManaged {
+		return managedNodeGroupNodeCategory
+	}
+	return selfManagedNodeCategory
+}
+
+func countEKSNodesByCategory(nodes []corev1.Node) map[string]eksNodeCount {
+	counts := map[string]eksNodeCount{}
+	for _, node := range nodes {
+		category := getEKSNodeCategory(node)
+		count := counts[category]
+		count.registered++
+		if k8s.IsNodeReady(node) {
+			count.ready++
+		}
+		counts[category] = count
+	}
+	return counts
+}
+
+// describeEKSNodeCountMismatches returns a description of each category of nodes that doesn't have exactly the expected
+// number of nodes, all of them ready. Returns an empty list if all categories are as expected.
+func describeEKSNodeCountMismatches(expected map[string]int, actual map[string]eksNodeCount) []string {
+	mismatches := []string{}
+	for _, category := range eksNodeCategories {
+		count := actual[category]
+		if count.registered != expected[category] || count.ready != expected[category] {
+			mismatches = append(
+				mismatches,
+				fmt.Sprintf("%s: expected %d, found %d registered and %d ready", category, expected[category], count.registered, count.ready),
+			)
+		}
+	}
+	return mismatches
+}
+
+func TestDescribeEKSNodeCountMismatches(t *testing.T) {
+	t.Parallel()
+
+	nodes := []corev1.Node{
+		newTestNode("self-managed", map[string]string{}, true),
+		newTestNode("managed-1", map[string]string{managedNodeGroupLabel: "node_group"}, true),
+		newTestNode("managed-2", map[string]string{managedNodeGroupLabel: "node_group"}, false),
+		newTestNode("fargate-1", map[string]string{computeTypeLabel: "fargate"}, true),
+	}
+	actual := countEKSNodesByCategory(nodes)
+	assert.Equal(t, map[string]eksNodeCount{
+		selfManagedNodeCategory:      {registered: 1, ready: 1},
+		managedNodeGroupNodeCategory: {registered: 2, ready: 1},
+		fargateNodeCategory:          {registered: 1, ready: 1},
+	}, actual)
+
+	assert.Empty(t, describeEKSNodeCountMismatches(
+		map[string]int{selfManagedNodeCategory: 1, managedNodeGroupNodeCategory: 2, fargateNodeCategory: 1},
+		map[string]eksNodeCount{
+			selfManagedNodeCategory:      {registered: 1, ready: 1},
+			managedNodeGroupNodeCategory: {registered: 2, ready: 2},
+			fargateNodeCategory:          {registered: 1, ready: 1},
+		},
+	))
+
+	// Only the categories that are short are reported, even if the total matches.
+	assert.Equal(t, []string{
+		"managed node group workers: expected 2, found 2 registered and 1 ready",
+		"Fargate pods: expected 2, found 1 registered and 1 ready",
+	}, describeEKSNodeCountMismatches(
+		map[string]int{selfManagedNodeCategory: 1, managedNodeGroupNodeCategory: 2, fargateNodeCategory: 2},
+		actual,
+	))
+}
+
+func TestSumEKSWorkerGroupMinSizes(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 0, sumEKSWorkerGroupMinSizes(map[string]eksWorkerGroupConfiguration{}))
+	assert.Equal(t, 3, sumEKSWorkerGroupMinSizes(map[string]eksWorkerGroupConfiguration{
+		"asg":         {MinSize: 1},
+		"another_asg": {MinSize: 2},
+	}))
+}
+
+func newTestNode(name string, nodeLabels map[string]string, ready bool) corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}},
+		},
+	}
+}
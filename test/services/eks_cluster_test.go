@@ -31,6 +31,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/gruntwork-io/aws-service-catalog/test"
+	"github.com/gruntwork-io/aws-service-catalog/test/localdns"
 )
 
 const (
@@ -52,15 +53,6 @@ var eksFargateRegions = []string{
 	"ap-northeast-1",
 }
 
-const (
-	// 2 worker + 2 fargate pods for coredns
-	expectedEksNodeCountWithoutAuthMerger = 4
-	// 2 worker + 2 fargate pods for coredns + 1 fargate pod for aws-auth-merger
-	expectedEksNodeCountWithAuthMerger = 5
-	// 2 fargate pods for coredns
-	expectedEksNodeCountFargateOnly = 2
-)
-
 func TestEksCluster(t *testing.T) {
 	t.Parallel()
 
@@ -72,15 +64,21 @@ func TestEksCluster(t *testing.T) {
 	//os.Setenv("SKIP_deploy_terraform", "true")
 	//os.Setenv("SKIP_check_perpetual_diff", "true")
 	//os.Setenv("SKIP_validate_cluster", "true")
+	//os.Setenv("SKIP_validate_aws_auth_merger_access", "true")
 	//os.Setenv("SKIP_validate_core_services_optionality", "true")
 	//os.Setenv("SKIP_deploy_core_services", "true")
 	//os.Setenv("SKIP_validate_core_services_fargate", "true")
+	//os.Setenv("SKIP_validate_cluster_autoscaler", "true")
+	//os.Setenv("SKIP_validate_core_services_irsa", "true")
+	//os.Setenv("SKIP_validate_core_services_logging", "true")
 	//os.Setenv("SKIP_validate_external_dns", "true")
 	//os.Setenv("SKIP_deploy_sampleapp", "true")
 	//os.Setenv("SKIP_validate_sampleapp", "true")
+	//os.Setenv("SKIP_upgrade_workers", "true")
 	//os.Setenv("SKIP_cleanup_sampleapp", "true")
 	//os.Setenv("SKIP_cleanup_core_services", "true")
 	//os.Setenv("SKIP_cleanup", "true")
+	//os.Setenv("SKIP_cleanup_upgrade_ami", "true")
 	//os.Setenv("SKIP_cleanup_aws_auth_merger_image", "true")
 	//os.Setenv("SKIP_cleanup_keypair", "true")
 	//os.Setenv("SKIP_cleanup_ami", "true")
@@ -142,16 +140,16 @@ func TestEksClusterFargateUsEast1(t *testing.T) {
 	defer test_structure.RunTestStage(t, "cleanup", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
 		terraform.Destroy(t, terraformOptions)
-
-		kubectlOptions := test_structure.LoadKubectlOptions(t, workingDir)
-		os.Remove(kubectlOptions.ConfigPath)
 	})
 
 	test_structure.RunTestStage(t, "setup_deploy_terraform", func() {
 		uniqueID := strings.ToLower(random.UniqueId())
 		test_structure.SaveString(t, workingDir, "uniqueID", uniqueID)
+		test_structure.SaveString(t, workingDir, "vpcCidrBlock", test.AllocateCIDRBlock(t, 16))
 	})
 	uniqueID := test_structure.LoadString(t, workingDir, "uniqueID")
+	// Reserve the block again in case the setup stage that allocated it was skipped, as the VPC still uses it.
+	test.ReserveCIDRBlock(t, test_structure.LoadString(t, workingDir, "vpcCidrBlock"))
 
 	test_structure.RunTestStage(t, "deploy_terraform", func() {
 		test_structure.SaveString(t, workingDir, "region", "us-east-1")
@@ -159,7 +157,7 @@ func TestEksClusterFargateUsEast1(t *testing.T) {
 	})
 
 	test_structure.RunTestStage(t, "validate_cluster", func() {
-		validateEKSCluster(t, workingDir, expectedEksNodeCountFargateOnly)
+		validateEKSCluster(t, workingDir)
 	})
 
 	defer test_structure.RunTestStage(t, "cleanup_core_services", func() {
@@ -191,16 +189,16 @@ func testEKSClusterWithoutAuthMerger(t *testing.T, parentWorkingDir string) {
 	defer test_structure.RunTestStage(t, "cleanup", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
 		terraform.Destroy(t, terraformOptions)
-
-		kubectlOptions := test_structure.LoadKubectlOptions(t, workingDir)
-		os.Remove(kubectlOptions.ConfigPath)
 	})
 
 	test_structure.RunTestStage(t, "setup_deploy_terraform", func() {
 		uniqueID := strings.ToLower(random.UniqueId())
 		test_structure.SaveString(t, workingDir, "uniqueID", uniqueID)
+		test_structure.SaveString(t, workingDir, "vpcCidrBlock", test.AllocateCIDRBlock(t, 16))
 	})
 	uniqueID := test_structure.LoadString(t, workingDir, "uniqueID")
+	// Reserve the block again in case the setup stage that allocated it was skipped, as the VPC still uses it.
+	test.ReserveCIDRBlock(t, test_structure.LoadString(t, workingDir, "vpcCidrBlock"))
 
 	test_structure.RunTestStage(t, "deploy_terraform", func() {
 		deployEKSCluster(t, parentWorkingDir, workingDir, uniqueID, eksClusterRoot, false, false)
@@ -211,7 +209,7 @@ func testEKSClusterWithoutAuthMerger(t *testing.T, parentWorkingDir string) {
 	})
 
 	test_structure.RunTestStage(t, "validate_cluster", func() {
-		validateEKSCluster(t, workingDir, expectedEksNodeCountWithoutAuthMerger)
+		validateEKSCluster(t, workingDir)
 		validateEKSClusterMaxPods(t, workingDir)
 	})
 }
@@ -243,10 +241,16 @@ func testEKSClusterWithCoreServicesAndAuthMerger(t *testing.T, parentWorkingDir
 		buildAWSAuthMergerImage(t, parentWorkingDir, workingDir)
 	})
 
-	defer test_structure.RunTestStage(t, "cleanup", func() {
-		kubectlOptions := test_structure.LoadKubectlOptions(t, workingDir)
-		os.Remove(kubectlOptions.ConfigPath)
+	// The upgrade_workers stage points the cluster at a copy of the worker AMI, so the copy can only be deleted after the
+	// cluster is destroyed.
+	defer test_structure.RunTestStage(t, "cleanup_upgrade_ami", func() {
+		region := test_structure.LoadString(t, parentWorkingDir, "region")
+		if test_structure.IsTestDataPresent(t, test_structure.FormatTestDataPath(workingDir, "upgradeAmiID.json")) {
+			aws.DeleteAmiAndAllSnapshots(t, region, test_structure.LoadString(t, workingDir, "upgradeAmiID"))
+		}
+	})
 
+	defer test_structure.RunTestStage(t, "cleanup", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
 		terraform.Destroy(t, terraformOptions)
 	})
@@ -254,8 +258,11 @@ func testEKSClusterWithCoreServicesAndAuthMerger(t *testing.T, parentWorkingDir
 	test_structure.RunTestStage(t, "setup_deploy_terraform", func() {
 		uniqueID := strings.ToLower(random.UniqueId())
 		test_structure.SaveString(t, workingDir, "uniqueID", uniqueID)
+		test_structure.SaveString(t, workingDir, "vpcCidrBlock", test.AllocateCIDRBlock(t, 16))
 	})
 	uniqueID := test_structure.LoadString(t, workingDir, "uniqueID")
+	// Reserve the block again in case the setup stage that allocated it was skipped, as the VPC still uses it.
+	test.ReserveCIDRBlock(t, test_structure.LoadString(t, workingDir, "vpcCidrBlock"))
 
 	test_structure.RunTestStage(t, "deploy_terraform", func() {
 		deployEKSCluster(t, parentWorkingDir, workingDir, uniqueID, eksClusterRoot, true, false)
@@ -266,7 +273,11 @@ func testEKSClusterWithCoreServicesAndAuthMerger(t *testing.T, parentWorkingDir
 	})
 
 	test_structure.RunTestStage(t, "validate_cluster", func() {
-		validateEKSCluster(t, workingDir, expectedEksNodeCountWithAuthMerger)
+		validateEKSCluster(t, workingDir)
+	})
+
+	test_structure.RunTestStage(t, "validate_aws_auth_merger_access", func() {
+		validateAWSAuthMergerAccessControl(t, parentWorkingDir, workingDir)
 	})
 
 	defer test_structure.RunTestStage(t, "cleanup_core_services", func() {
@@ -300,6 +311,18 @@ func testEKSClusterWithCoreServicesAndAuthMerger(t *testing.T, parentWorkingDir
 		validateCoreServicesOnFargate(t, workingDir)
 	})
 
+	test_structure.RunTestStage(t, "validate_cluster_autoscaler", func() {
+		validateClusterAutoscaler(t, workingDir)
+	})
+
+	test_structure.RunTestStage(t, "validate_core_services_irsa", func() {
+		validateCoreServicesIRSA(t, parentWorkingDir, workingDir)
+	})
+
+	test_structure.RunTestStage(t, "validate_core_services_logging", func() {
+		validateCoreServicesLogging(t, parentWorkingDir, workingDir, coreServicesRoot)
+	})
+
 	test_structure.RunTestStage(t, "validate_external_dns", func() {
 		validateExternalDNS(t, workingDir)
 	})
@@ -327,6 +350,10 @@ func testEKSClusterWithCoreServicesAndAuthMerger(t *testing.T, parentWorkingDir
 		validateSameALBDomain(t, workingDir, k8sServiceRoot, altK8sServiceRoot)
 	})
 
+	test_structure.RunTestStage(t, "upgrade_workers", func() {
+		upgradeSelfManagedWorkers(t, parentWorkingDir, workingDir, k8sServiceRoot)
+	})
+
 }
 
 func buildWorkerAmi(t *testing.T, testFolder string) {
@@ -379,6 +406,7 @@ func deployEKSCluster(
 	terraformOptions := test.CreateBaseTerraformOptions(t, modulePath, awsRegion)
 	terraformOptions.Vars["cluster_name"] = clusterName
 	terraformOptions.Vars["cluster_instance_ami_version_tag"] = branchName
+	terraformOptions.Vars["vpc_cidr_block"] = test_structure.LoadString(t, workingDir, "vpcCidrBlock")
 
 	// Pull in ECR image info and configure the vars to enable the aws-auth-merger if requested.
 	if enableAWSAuthMerger {
@@ -410,26 +438,9 @@ func checkEKSClusterPerpetualDiff(t *testing.T, workingDir string) {
 	assert.Equal(t, 0, counts.Destroy)
 }
 
-// Validate the deployed EKS cluster has the requisite number of workers.
-// workingDir should be the working dir of the subtest, and is where local options like the terraform options are
-// stored.
-func validateEKSCluster(t *testing.T, workingDir string, expectedEksNodeCount int) {
-	terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
-	eksClusterArn := terraform.OutputRequired(t, terraformOptions, "eks_cluster_arn")
-
-	tmpKubeConfigPath := configureKubectlForEKSCluster(t, eksClusterArn)
-	kubectlOptions := k8s.NewKubectlOptions("", tmpKubeConfigPath, "")
-	test_structure.SaveKubectlOptions(t, workingDir, kubectlOptions)
-
-	kubeWaitUntilNumNodes(t, kubectlOptions, expectedEksNodeCount, 30, 10*time.Second)
-	k8s.WaitUntilAllNodesReady(t, kubectlOptions, 30, 10*time.Second)
-	readyNodes := k8s.GetReadyNodes(t, kubectlOptions)
-	assert.Equal(t, len(readyNodes), expectedEksNodeCount)
-}
-
 // Validate that each physical worker node (non-Fargate) has the prefix delegation mode max pods setting set.
 func validateEKSClusterMaxPods(t *testing.T, workingDir string) {
-	kubectlOptions := test_structure.LoadKubectlOptions(t, workingDir)
+	kubectlOptions := loadEKSKubectlOptions(t, workingDir)
 
 	nonFargateNodes := []corev1.Node{}
 	readyNodes := k8s.GetReadyNodes(t, kubectlOptions)
@@ -472,6 +483,7 @@ func getCoreServicesTerraformOptions(t *testing.T, parentWorkingDir string, work
 	coreServicesOptions.Vars["eks_iam_role_for_service_accounts_config"] = eksClusterIRSAConfig
 	coreServicesOptions.Vars["external_dns_route53_hosted_zone_tag_filters"] = defaultDomainTagFilterForTest
 	coreServicesOptions.Vars["pod_execution_iam_role_arn"] = eksClusterFargateRole
+	coreServicesOptions.Vars["fargate_fluent_bit_execution_iam_role_arns"] = []string{eksClusterFargateRole}
 	coreServicesOptions.Vars["service_dns_mappings"] = map[string]interface{}{
 		"whatismyip": map[string]interface{}{
 			"target_dns":  "checkip.amazonaws.com",
@@ -490,7 +502,7 @@ func getCoreServicesTerraformOptions(t *testing.T, parentWorkingDir string, work
 // workingDir should be the working dir of the subtest, and is where local options like the terraform options are
 // stored.
 func validateCoreServicesOnFargate(t *testing.T, workingDir string) {
-	kubectlOptions := test_structure.LoadKubectlOptions(t, workingDir)
+	kubectlOptions := loadEKSKubectlOptions(t, workingDir)
 	kubectlOptions.Namespace = "kube-system"
 
 	ingressControllerLabelSelector := "app.kubernetes.io/instance=aws-alb-ingress-controller,app.kubernetes.io/name=aws-load-balancer-controller"
@@ -516,7 +528,7 @@ func assertFargate(t *testing.T, kubectlOptions *k8s.KubectlOptions, labelSelect
 // workingDir should be the working dir of the subtest, and is where local options like the terraform options are
 // stored.
 func validateExternalDNS(t *testing.T, workingDir string) {
-	kubectlOptions := test_structure.LoadKubectlOptions(t, workingDir)
+	kubectlOptions := loadEKSKubectlOptions(t, workingDir)
 
 	namespaceName := strings.ToLower(random.UniqueId())
 
@@ -615,64 +627,72 @@ func deploySampleApp(
 // workingDir should be the working dir of the subtest, and is where local options like the terraform options are
 // stored.
 func validateSampleApp(t *testing.T, workingDir string, k8sServiceModulePath string) {
-	terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
-	eksClusterArn := terraform.OutputRequired(t, terraformOptions, "eks_cluster_arn")
 	applicationName := test_structure.LoadString(t, k8sServiceModulePath, "applicationName")
 	expectedGreeting := test_structure.LoadString(t, k8sServiceModulePath, "expectedGreeting")
 
-	tmpKubeConfigPath := configureKubectlForEKSCluster(t, eksClusterArn)
-	defer os.Remove(tmpKubeConfigPath)
-	options := k8s.NewKubectlOptions("", tmpKubeConfigPath, "default")
+	options := loadEKSKubectlOptions(t, workingDir)
+	options.Namespace = "default"
 
 	sampleAppValidationFunction := sampleAppValidationWithGreetingFunctionGenerator(expectedGreeting)
 
 	verifyPodsCreatedSuccessfully(t, options, applicationName)
 	verifyAllPodsAvailable(t, options, applicationName, "/greeting", sampleAppValidationFunction)
 
-	// Wait until the DNS entry is resolvable before attempting to get the address. This ensures that we wait for the
-	// hostname to have propagated through DNS before making requests to it. Otherwise, if we make requests too early,
-	// before DNS has propagated, the missing DNS entry gets recorded in the local cache, and the test will keep
-	// failing, despite retries.
+	// Wait until the DNS entry is resolvable before attempting to get the address. By default, we read the record
+	// straight from Route 53 and make the requests through a local DNS server, so that we don't have to wait for the
+	// record to propagate through public DNS.
 	hostname := fmt.Sprintf("%s.%s", applicationName, test.BaseDomainForTest)
-	dns_helper.DNSLookupAuthoritativeWithRetry(
-		t,
-		dns_helper.DNSQuery{
-			Type: "A",
-			Name: hostname,
-		},
-		nil,
-		K8SServiceWaitTimerRetries,
-		K8SIngressWaitTimerSleep,
-	)
-
+	query := dns_helper.DNSQuery{
+		Type: "A",
+		Name: hostname,
+	}
 	ingressEndpoint := fmt.Sprintf("https://%s/greeting", hostname)
-	http_helper.HttpGetWithRetryWithCustomValidation(
+
+	dnsServer := startLocalDNSServerForBaseDomain(t)
+	dnsServer.LookupWithRetry(t, query, K8SServiceWaitTimerRetries, K8SIngressWaitTimerSleep)
+	dnsServer.HTTPGetWithRetryWithCustomValidation(
 		t,
 		ingressEndpoint,
-		nil,
 		K8SServiceWaitTimerRetries,
 		K8SIngressWaitTimerSleep,
 		sampleAppValidationFunction,
 	)
+
+	if test.IsPublicDNSCheckEnabled() {
+		// Wait for the hostname to have propagated through public DNS before making requests to it. Otherwise, if we
+		// make requests too early, before DNS has propagated, the missing DNS entry gets recorded in the local cache,
+		// and the test will keep failing, despite retries.
+		dns_helper.DNSLookupAuthoritativeWithRetry(
+			t,
+			query,
+			nil,
+			K8SServiceWaitTimerRetries,
+			K8SIngressWaitTimerSleep,
+		)
+		http_helper.HttpGetWithRetryWithCustomValidation(
+			t,
+			ingressEndpoint,
+			nil,
+			K8SServiceWaitTimerRetries,
+			K8SIngressWaitTimerSleep,
+			sampleAppValidationFunction,
+		)
+	}
 }
 
-func configureKubectlForEKSCluster(t *testing.T, eksClusterArn string) string {
-	tmpKubeConfigFile, err := ioutil.TempFile("", "")
-	require.NoError(t, err)
-	tmpKubeConfigFile.Close()
-	tmpKubeConfigPath := tmpKubeConfigFile.Name()
+// startLocalDNSServerForBaseDomain starts a local DNS server that serves the records of the hosted zone for
+// test.BaseDomainForTest, where the ingress controller creates the records of the sample apps.
+func startLocalDNSServerForBaseDomain(t *testing.T) *localdns.Server {
+	hostedZoneID := localdns.FindPublicHostedZoneID(t, test.BaseDomainForTest, test.DomainNameTagsForTest)
+	return localdns.StartServer(t, hostedZoneID)
+}
 
-	command := shell.Command{
-		Command: "kubergrunt",
-		Args: []string{
-			"eks",
-			"configure",
-			"--eks-cluster-arn", eksClusterArn,
-			"--kubeconfig", tmpKubeConfigPath,
-		},
-	}
-	shell.RunCommand(t, command)
-	return tmpKubeConfigPath
+// loadEKSKubectlOptions returns kubectl options for the EKS cluster deployed in workingDir. The kubeconfig embeds a
+// short lived token, so every stage loads fresh options instead of saving them alongside the terraform options.
+func loadEKSKubectlOptions(t *testing.T, workingDir string) *k8s.KubectlOptions {
+	terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
+	eksClusterArn := terraform.OutputRequired(t, terraformOptions, "eks_cluster_arn")
+	return test.ConfigureKubectlForEKSCluster(t, eksClusterArn)
 }
 
 // kubeWaitUntilNumNodes continuously polls the Kubernetes cluster until there are the expected number of nodes
@@ -755,29 +775,26 @@ func buildAWSAuthMergerImage(t *testing.T, parentWorkingDir string, workingDir s
 func validateSameALBDomain(t *testing.T, workingDir string, k8sServiceRoot string, altK8sServiceRoot string) {
 	applicationName := test_structure.LoadString(t, k8sServiceRoot, "applicationName")
 	hostname := fmt.Sprintf("%s.%s", applicationName, test.BaseDomainForTest)
-	answers := dns_helper.DNSLookupAuthoritativeWithRetry(
-		t,
-		dns_helper.DNSQuery{
-			Type: "A",
-			Name: hostname,
-		},
-		nil,
-		K8SServiceWaitTimerRetries,
-		K8SIngressWaitTimerSleep,
-	)
+	query := dns_helper.DNSQuery{
+		Type: "A",
+		Name: hostname,
+	}
 
 	altApplicationName := test_structure.LoadString(t, altK8sServiceRoot, "applicationName")
 	altHostname := fmt.Sprintf("%s.%s", altApplicationName, test.BaseDomainForTest)
-	altAnswers := dns_helper.DNSLookupAuthoritativeWithRetry(
-		t,
-		dns_helper.DNSQuery{
-			Type: "A",
-			Name: altHostname,
-		},
-		nil,
-		K8SServiceWaitTimerRetries,
-		K8SIngressWaitTimerSleep,
-	)
+	altQuery := dns_helper.DNSQuery{
+		Type: "A",
+		Name: altHostname,
+	}
 
+	dnsServer := startLocalDNSServerForBaseDomain(t)
+	answers := dnsServer.LookupWithRetry(t, query, K8SServiceWaitTimerRetries, K8SIngressWaitTimerSleep)
+	altAnswers := dnsServer.LookupWithRetry(t, altQuery, K8SServiceWaitTimerRetries, K8SIngressWaitTimerSleep)
 	assert.Equal(t, altAnswers, answers)
+
+	if test.IsPublicDNSCheckEnabled() {
+		publicAnswers := dns_helper.DNSLookupAuthoritativeWithRetry(t, query, nil, K8SServiceWaitTimerRetries, K8SIngressWaitTimerSleep)
+		publicAltAnswers := dns_helper.DNSLookupAuthoritativeWithRetry(t, altQuery, nil, K8SServiceWaitTimerRetries, K8SIngressWaitTimerSleep)
+		assert.Equal(t, publicAltAnswers, publicAnswers)
+	}
 }
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// The annotation the EKS pod identity webhook reads the IAM role of a service account from.
+	irsaRoleArnAnnotation = "eks.amazonaws.com/role-arn"
+
+	// The image of the pod we exec into to check the credentials that a service account yields.
+	awsCLIImage = "amazon/aws-cli:2.4.29"
+)
+
+// The core services that get an IAM role through IRSA (IAM Roles for Service Accounts), with the label selectors of
+// their pods in kube-system.
+var coreServicesWithIRSA = []struct {
+	name          string
+	labelSelector string
+}{
+	{
+		name:          "aws-load-balancer-controller",
+		labelSelector: "app.kubernetes.io/instance=aws-alb-ingress-controller,app.kubernetes.io/name=aws-load-balancer-controller",
+	},
+	{
+		name:          "external-dns",
+		labelSelector: "app.kubernetes.io/instance=external-dns,app.kubernetes.io/name=external-dns",
+	},
+	{
+		name:          "cluster-autoscaler",
+		labelSelector: "app.kubernetes.io/instance=cluster-autoscaler,app.kubernetes.io/name=aws-cluster-autoscaler",
+	},
+	{
+		name:          "fluent-bit",
+		labelSelector: "app.kubernetes.io/name=aws-for-fluent-bit",
+	},
+}
+
+// validateCoreServicesIRSA checks that each core service runs under its own service account, annotated with an IAM
+// role of the cluster that only that service account can assume through the OIDC provider of the cluster. It then
+// runs a pod under each service account and checks that the projected web identity token yields exactly that role
+// from STS, which catches a misconfigured OIDC provider that the annotations alone wouldn't.
+// parentWorkingDir should be the working dir of the overarching test, and is where the global options like region and
+// AMI are stored.
+// workingDir should be the working dir of the subtest, and is where local options like the terraform options are
+// stored.
+func validateCoreServicesIRSA(t *testing.T, parentWorkingDir string, workingDir string) {
+	awsRegion := test_structure.LoadString(t, parentWorkingDir, "region")
+	clusterName := test_structure.LoadString(t, workingDir, "clusterName")
+	terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
+	irsaConfig := terraform.OutputMap(t, terraformOptions, "eks_iam_role_for_service_accounts_config")
+	oidcProviderArn := irsaConfig["openid_connect_provider_arn"]
+	oidcProviderURL := irsaConfig["openid_connect_provider_url"]
+	require.NotEmpty(t, oidcProviderArn)
+	require.NotEmpty(t, oidcProviderURL)
+
+	sess, err := aws.NewAuthenticatedSession(awsRegion)
+	require.NoError(t, err)
+	iamClient := iam.New(sess)
+
+	kubectlOptions := loadEKSKubectlOptions(t, workingDir)
+	kubectlOptions.Namespace = "kube-system"
+
+	roleArns := map[string]string{}
+	for _, coreService := range coreServicesWithIRSA {
+		pods := k8s.ListPods(t, kubectlOptions, metav1.ListOptions{LabelSelector: coreService.labelSelector})
+		require.NotEmptyf(t, pods, "Found no pods of %s", coreService.name)
+		serviceAccountName := pods[0].Spec.ServiceAccountName
+		for _, pod := range pods {
+			assert.Equalf(t, serviceAccountName, pod.Spec.ServiceAccountName, "Pods of %s run under different service accounts", coreService.name)
+		}
+		require.NotEqualf(t, "default", serviceAccountName, "%s runs under the default service account", coreService.name)
+
+		serviceAccount := k8s.GetServiceAccount(t, kubectlOptions, serviceAccountName)
+		roleArn := serviceAccount.Annotations[irsaRoleArnAnnotation]
+		require.NotEmptyf(t, roleArn, "Service account %s of %s has no %s annotation", serviceAccountName, coreService.name, irsaRoleArnAnnotation)
+		if otherService, isShared := roleArns[roleArn]; isShared {
+			assert.Failf(t, "Core services share an IAM role", "%s and %s both use %s", otherService, coreService.name, roleArn)
+		}
+		roleArns[roleArn] = coreService.name
+
+		roleName := getIAMRoleNameFromArn(t, roleArn)
+		assert.Truef(t, strings.HasPrefix(roleName, clusterName), "IAM role %s of %s is not named after cluster %s", roleName, coreService.name, clusterName)
+		role, err := iamClient.GetRole(&iam.GetRoleInput{RoleName: awsgo.String(roleName)})
+		require.NoError(t, err)
+		trustPolicy, err := url.QueryUnescape(awsgo.StringValue(role.Role.AssumeRolePolicyDocument))
+		require.NoError(t, err)
+		subject := fmt.Sprintf("system:serviceaccount:%s:%s", kubectlOptions.Namespace, serviceAccountName)
+		allowed, err := irsaTrustPolicyAllowsServiceAccount(trustPolicy, oidcProviderArn, oidcProviderURL, subject)
+		require.NoError(t, err)
+		assert.Truef(t, allowed, "IAM role %s of %s can't be assumed by %s through %s:\n%s", roleName, coreService.name, subject, oidcProviderArn, trustPolicy)
+
+		validateServiceAccountCallerIdentity(t, kubectlOptions, serviceAccountName, roleArn, awsRegion)
+	}
+}
+
+// validateServiceAccountCallerIdentity runs a pod with the AWS CLI under the given service account, and checks that the
+// credentials the pod gets from its projected web identity token belong to the given IAM role.
+func validateServiceAccountCallerIdentity(t *testing.T, kubectlOptions *k8s.KubectlOptions, serviceAccountName string, roleArn string, awsRegion string) {
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, kubectlOptions)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	podName := fmt.Sprintf("irsa-check-%s", strings.ToLower(random.UniqueId()))
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: kubectlOptions.Namespace},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: serviceAccountName,
+			RestartPolicy:      corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:    "aws-cli",
+				Image:   awsCLIImage,
+				Command: []string{"sleep", "3600"},
+			}},
+		},
+	}
+	_, err = clientset.CoreV1().Pods(kubectlOptions.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	require.NoError(t, err)
+	defer clientset.CoreV1().Pods(kubectlOptions.Namespace).Delete(ctx, podName, metav1.DeleteOptions{})
+	// Fargate pods can take a couple of minutes to be scheduled.
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, podName, 30, 10*time.Second)
+
+	// The pod identity webhook injects the role of the service account into the pod, which the AWS SDKs and CLI use
+	// together with the projected token.
+	injectedRoleArn := ""
+	for _, env := range k8s.GetPod(t, kubectlOptions, podName).Spec.Containers[0].Env {
+		if env.Name == "AWS_ROLE_ARN" {
+			injectedRoleArn = env.Value
+		}
+	}
+	assert.Equalf(t, roleArn, injectedRoleArn, "Pod with service account %s did not get its role injected", serviceAccountName)
+
+	output, err := k8s.RunKubectlAndGetOutputE(
+		t, kubectlOptions,
+		"exec", podName, "--",
+		"aws", "sts", "get-caller-identity", "--query", "Arn", "--output", "text", "--region", awsRegion,
+	)
+	require.NoError(t, err)
+	// The output of kubectl includes stderr, so only the last line is the ARN.
+	outputLines := strings.Split(strings.TrimSpace(output), "\n")
+	callerArn := strings.TrimSpace(outputLines[len(outputLines)-1])
+
+	// The caller identity of an assumed role has the form arn:aws:sts::ACCOUNT_ID:assumed-role/ROLE_NAME/SESSION_NAME.
+	parsedRoleArn, err := arn.Parse(roleArn)
+	require.NoError(t, err)
+	expectedPrefix := fmt.Sprintf("arn:%s:sts::%s:assumed-role/%s/", parsedRoleArn.Partition, parsedRoleArn.AccountID, getIAMRoleNameFromArn(t, roleArn))
+	assert.Truef(t, strings.HasPrefix(callerArn, expectedPrefix), "Service account %s yields %s instead of %s", serviceAccountName, callerArn, roleArn)
+}
+
+// getIAMRoleNameFromArn returns the name of the IAM role with the given ARN, which is the last part of the resource, as
+// the resource includes the path of the role.
+func getIAMRoleNameFromArn(t *testing.T, roleArn string) string {
+	parsedArn, err := arn.Parse(roleArn)
+	require.NoError(t, err)
+	require.Truef(t, strings.HasPrefix(parsedArn.Resource, "role/"), "%s is not the ARN of an IAM role", roleArn)
+	parts := strings.Split(parsedArn.Resource, "/")
+	return parts[len(parts)-1]
+}
+
+// irsaTrustPolicy is the part of an IAM trust policy that IRSA relies on.
+type irsaTrustPolicy struct {
+	Statement []struct {
+		Effect    string
+		Action    interface{}
+		Principal struct {
+			Federated interface{}
+		}
+		Condition map[string]map[string]interface{}
+	}
+}
+
+// irsaTrustPolicyAllowsServiceAccount returns true if the given trust policy lets the given service account subject
+// (system:serviceaccount:NAMESPACE:NAME) assume the role with a web identity token of the given OIDC provider.
+func irsaTrustPolicyAllowsServiceAccount(trustPolicy string, oidcProviderArn string, oidcProviderURL string, subject string) (bool, error) {
+	var policy irsaTrustPolicy
+	if err := json.Unmarshal([]byte(trustPolicy), &policy); err != nil {
+		return false, err
+	}
+
+	// Condition keys of the OIDC provider are prefixed with its URL, without the scheme.
+	subjectKey := strings.TrimPrefix(oidcProviderURL, "https://") + ":sub"
+	for _, statement := range policy.Statement {
+		if statement.Effect != "Allow" ||
+			!stringOrListContains(statement.Action, "sts:AssumeRoleWithWebIdentity") ||
+			!stringOrListContains(statement.Principal.Federated, oidcProviderArn) {
+			continue
+		}
+		for operator, conditions := range statement.Condition {
+			if operator != "StringEquals" && operator != "StringLike" {
+				continue
+			}
+			if stringOrListContains(conditions[subjectKey], subject) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// stringOrListContains returns true if the given policy element, which can be a single string or a list of strings, is
+// or contains the given value.
+func stringOrListContains(element interface{}, value string) bool {
+	switch typed := element.(type) {
+	case string:
+		return typed == value
+	case []interface{}:
+		for _, item := range typed {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestIRSATrustPolicyAllowsServiceAccount(t *testing.T) {
+	t.Parallel()
+
+	providerArn := "arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/ABC"
+	providerURL := "https://oidc.eks.eu-west-1.amazonaws.com/id/ABC"
+	subject := "system:serviceaccount:kube-system:external-dns"
+	policyTemplate := `{
+  "Version": "2012-10-17",
+  "Statement": [{
+    "Effect": "Allow",
+    "Action": "sts:AssumeRoleWithWebIdentity",
+    "Principal": {"Federated": "%s"},
+    "Condition": {"StringEquals": {"oidc.eks.eu-west-1.amazonaws.com/id/ABC:sub": %s}}
+  }]
+}`
+
+	testCases := []struct {
+		name     string
+		policy   string
+		expected bool
+	}{
+		{"SingleSubject", fmt.Sprintf(policyTemplate, providerArn, `"`+subject+`"`), true},
+		{"ListOfSubjects", fmt.Sprintf(policyTemplate, providerArn, `["system:serviceaccount:kube-system:other", "`+subject+`"]`), true},
+		{"OtherSubject", fmt.Sprintf(policyTemplate, providerArn, `"system:serviceaccount:kube-system:other"`), false},
+		{"OtherProvider", fmt.Sprintf(policyTemplate, "arn:aws:iam::123456789012:oidc-provider/other", `"`+subject+`"`), false},
+		{"NoCondition", `{"Statement": [{"Effect": "Allow", "Action": ["sts:AssumeRoleWithWebIdentity"], "Principal": {"Federated": "` + providerArn + `"}}]}`, false},
+	}
+
+	for _, testCase := range testCases {
+		// Capture range variable to within for block scope so that it doesn't change as we switch go routines with the
+		// t.Parallel calls.
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			allowed, err := irsaTrustPolicyAllowsServiceAccount(testCase.policy, providerArn, providerURL, subject)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, allowed)
+		})
+	}
+}
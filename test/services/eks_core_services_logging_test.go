@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gruntwork-io/aws-service-catalog/test"
+)
+
+const (
+	// The prefixes of the log streams that fluent-bit creates for the pods on EC2 workers and on Fargate. These are the
+	// defaults of fluent_bit_log_stream_prefix and fargate_fluent_bit_log_stream_prefix in eks-core-services.
+	ec2FluentBitLogStreamPrefix     = "fluentbit"
+	fargateFluentBitLogStreamPrefix = "fargate"
+
+	// The image of the pods that print the marker line.
+	logMarkerImage = "busybox:1.34"
+
+	// The label that selects the marker pod that should run on Fargate into the Fargate profile of the test.
+	fargateLogMarkerLabel = "fargate-log-marker"
+
+	// A metric that the CloudWatch agent publishes to Container Insights for every cluster.
+	containerInsightsNamespace = "ContainerInsights"
+	containerInsightsMetric    = "node_cpu_utilization"
+
+	logDeliveryRetries = 20
+	logDeliverySleep   = 30 * time.Second
+)
+
+// validateCoreServicesLogging checks that the logs of pods on both EC2 workers and Fargate are shipped to CloudWatch
+// Logs by fluent-bit, and that the CloudWatch agent publishes Container Insights metrics for the cluster. The Fargate
+// pod runs in a Fargate profile that is created for the test, as the core services profile only selects the core
+// services.
+// parentWorkingDir should be the working dir of the overarching test, and is where the global options like region and
+// AMI are stored.
+// workingDir should be the working dir of the subtest, and is where local options like the terraform options are
+// stored.
+func validateCoreServicesLogging(t *testing.T, parentWorkingDir string, workingDir string, coreServicesModulePath string) {
+	awsRegion := test_structure.LoadString(t, parentWorkingDir, "region")
+	clusterName := test_structure.LoadString(t, workingDir, "clusterName")
+	coreServicesOptions := test_structure.LoadTerraformOptions(t, coreServicesModulePath)
+	logGroupName := terraform.OutputRequired(t, coreServicesOptions, "container_logs_cloudwatch_log_group_name")
+	sess, err := aws.NewAuthenticatedSession(awsRegion)
+	require.NoError(t, err)
+
+	uniqueID := strings.ToLower(random.UniqueId())
+	namespace := fmt.Sprintf("logging-%s", uniqueID)
+
+	// The kubectl options are loaded again for each step, as creating the Fargate profile and waiting for the logs
+	// can take longer than the token in the kubectl options is valid.
+	deleteFargateProfile := createLogMarkerFargateProfile(t, sess, workingDir, clusterName, namespace)
+	defer deleteFargateProfile()
+	k8s.CreateNamespace(t, loadEKSKubectlOptions(t, workingDir), namespace)
+	defer func() {
+		k8s.DeleteNamespace(t, loadEKSKubectlOptions(t, workingDir), namespace)
+	}()
+
+	kubectlOptions := loadEKSKubectlOptions(t, workingDir)
+	kubectlOptions.Namespace = namespace
+	ec2Marker := fmt.Sprintf("eks-log-delivery-ec2-%s", uniqueID)
+	fargateMarker := fmt.Sprintf("eks-log-delivery-fargate-%s", uniqueID)
+	ec2Pod := createLogMarkerPod(t, kubectlOptions, "ec2-log-marker", ec2Marker, false)
+	fargatePod := createLogMarkerPod(t, kubectlOptions, "fargate-log-marker", fargateMarker, true)
+
+	// Fargate pods can take a couple of minutes to be scheduled.
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, ec2Pod, 30, 10*time.Second)
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, fargatePod, 30, 10*time.Second)
+	assertFargate(t, kubectlOptions, fmt.Sprintf("%s=true", fargateLogMarkerLabel))
+	assert.NotContains(t, k8s.GetPod(t, kubectlOptions, ec2Pod).Spec.NodeName, "fargate")
+
+	test.WaitForCloudWatchLogEntry(t, awsRegion, logGroupName, ec2FluentBitLogStreamPrefix, ec2Marker, logDeliveryRetries, logDeliverySleep)
+	test.WaitForCloudWatchLogEntry(t, awsRegion, logGroupName, fargateFluentBitLogStreamPrefix, fargateMarker, logDeliveryRetries, logDeliverySleep)
+
+	validateContainerInsightsMetrics(t, sess, clusterName)
+}
+
+// createLogMarkerFargateProfile creates a Fargate profile that selects the pods with the fargateLogMarkerLabel in the
+// given namespace, and returns a function that deletes it again. EKS can only create or delete one Fargate profile
+// of a cluster at a time, so both wait until the profile is done.
+func createLogMarkerFargateProfile(t *testing.T, sess *session.Session, workingDir string, clusterName string, namespace string) func() {
+	eksClusterOptions := test_structure.LoadTerraformOptions(t, workingDir)
+	podExecutionRoleArn := terraform.OutputRequired(t, eksClusterOptions, "eks_default_fargate_execution_role_arn")
+	subnetIDs := terraform.OutputList(t, eksClusterOptions, "private_subnet_ids")
+
+	eksClient := eks.New(sess)
+	profileName := namespace
+	_, err := eksClient.CreateFargateProfile(&eks.CreateFargateProfileInput{
+		ClusterName:         awsgo.String(clusterName),
+		FargateProfileName:  awsgo.String(profileName),
+		PodExecutionRoleArn: awsgo.String(podExecutionRoleArn),
+		Subnets:             awsgo.StringSlice(subnetIDs),
+		Selectors: []*eks.FargateProfileSelector{{
+			Namespace: awsgo.String(namespace),
+			Labels:    awsgo.StringMap(map[string]string{fargateLogMarkerLabel: "true"}),
+		}},
+	})
+	require.NoError(t, err)
+
+	describeInput := &eks.DescribeFargateProfileInput{
+		ClusterName:        awsgo.String(clusterName),
+		FargateProfileName: awsgo.String(profileName),
+	}
+	deleteProfile := func() {
+		_, err := eksClient.DeleteFargateProfile(&eks.DeleteFargateProfileInput{
+			ClusterName:        awsgo.String(clusterName),
+			FargateProfileName: awsgo.String(profileName),
+		})
+		require.NoError(t, err)
+		require.NoError(t, eksClient.WaitUntilFargateProfileDeleted(describeInput))
+	}
+
+	logger.Logf(t, "Waiting for Fargate profile %s to be active", profileName)
+	if err := eksClient.WaitUntilFargateProfileActive(describeInput); err != nil {
+		deleteProfile()
+		require.NoError(t, err)
+	}
+	return deleteProfile
+}
+
+// createLogMarkerPod creates a pod that keeps printing the given marker, so that the marker is shipped no matter when
+// fluent-bit starts picking up the logs of the pod. Returns the name of the pod.
+func createLogMarkerPod(t *testing.T, kubectlOptions *k8s.KubectlOptions, name string, marker string, onFargate bool) string {
+	labels := map[string]string{}
+	if onFargate {
+		labels[fargateLogMarkerLabel] = "true"
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: kubectlOptions.Namespace, Labels: labels},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:    "log-marker",
+				Image:   logMarkerImage,
+				Command: []string{"sh", "-c", fmt.Sprintf("while true; do echo %s; sleep 5; done", marker)},
+			}},
+		},
+	}
+
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, kubectlOptions)
+	require.NoError(t, err)
+	_, err = clientset.CoreV1().Pods(kubectlOptions.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return name
+}
+
+// validateContainerInsightsMetrics waits until the CloudWatch agent has published Container Insights metrics for the
+// nodes of the cluster.
+func validateContainerInsightsMetrics(t *testing.T, sess *session.Session, clusterName string) {
+	cloudwatchClient := cloudwatch.New(sess)
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Wait for Container Insights metrics of cluster %s", clusterName),
+		logDeliveryRetries,
+		logDeliverySleep,
+		func() (string, error) {
+			output, err := cloudwatchClient.ListMetrics(&cloudwatch.ListMetricsInput{
+				Namespace:  awsgo.String(containerInsightsNamespace),
+				MetricName: awsgo.String(containerInsightsMetric),
+				Dimensions: []*cloudwatch.DimensionFilter{{
+					Name:  awsgo.String("ClusterName"),
+					Value: awsgo.String(clusterName),
+				}},
+			})
+			if err != nil {
+				return "", err
+			}
+			if len(output.Metrics) == 0 {
+				return "", fmt.Errorf("No %s metrics in %s yet", containerInsightsMetric, containerInsightsNamespace)
+			}
+			return "Found Container Insights metrics", nil
+		},
+	)
+}
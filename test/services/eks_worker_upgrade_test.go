@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/git"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gruntwork-io/aws-service-catalog/test"
+)
+
+const (
+	// The number of pods of the sample app while the workers are upgraded, and how many of them the PodDisruptionBudget
+	// keeps available while the old workers are drained.
+	upgradeSampleAppNumPods          = 2
+	upgradeSampleAppMinPodsAvailable = 1
+
+	// The label that tells the AWS Load Balancer Controller to stop routing traffic to a node, so that an old worker is
+	// taken out of the ALB target groups before it is terminated.
+	excludeFromLoadBalancersLabel = "node.kubernetes.io/exclude-from-external-load-balancers"
+
+	// The tag the AWS Load Balancer Controller adds to the target groups it manages for a cluster.
+	loadBalancerControllerClusterTag = "elbv2.k8s.aws/cluster"
+
+	workerUpgradeRetries = 60
+	workerUpgradeSleep   = 10 * time.Second
+)
+
+// upgradeSelfManagedWorkers rolls the self-managed workers of the EKS cluster to a new AMI version, the same way
+// kubergrunt eks deploy does: the cluster is re-applied to look up the new AMI, new workers are launched next to the
+// old ones, and the old workers are cordoned, drained and terminated once the new ones are ready. The sample app
+// deployed at k8sServiceModulePath must keep serving through its ingress endpoint the whole time.
+// parentWorkingDir should be the working dir of the overarching test, and is where the global options like region and
+// AMI are stored.
+// workingDir should be the working dir of the subtest, and is where local options like the terraform options are
+// stored.
+func upgradeSelfManagedWorkers(t *testing.T, parentWorkingDir string, workingDir string, k8sServiceModulePath string) {
+	awsRegion := test_structure.LoadString(t, parentWorkingDir, "region")
+	clusterName := test_structure.LoadString(t, workingDir, "clusterName")
+	sess, err := aws.NewAuthenticatedSession(awsRegion)
+	require.NoError(t, err)
+
+	upgradeAmiVersionTag := fmt.Sprintf("%s-upgrade", git.GetCurrentBranchName(t))
+	upgradeAmiID := createUpgradeWorkerAmi(t, sess, parentWorkingDir, workingDir, upgradeAmiVersionTag)
+
+	// Run two pods of the sample app, protected by a PodDisruptionBudget, so that draining a worker never takes down
+	// all the pods at once.
+	k8sServiceOptions := test_structure.LoadTerraformOptions(t, k8sServiceModulePath)
+	k8sServiceOptions.Vars["desired_number_of_pods"] = upgradeSampleAppNumPods
+	k8sServiceOptions.Vars["min_number_of_pods_available"] = upgradeSampleAppMinPodsAvailable
+	test_structure.SaveTerraformOptions(t, k8sServiceModulePath, k8sServiceOptions)
+	terraform.Apply(t, k8sServiceOptions)
+
+	applicationName := test_structure.LoadString(t, k8sServiceModulePath, "applicationName")
+	expectedGreeting := test_structure.LoadString(t, k8sServiceModulePath, "expectedGreeting")
+	sampleAppValidationFunction := sampleAppValidationWithGreetingFunctionGenerator(expectedGreeting)
+	dnsServer := startLocalDNSServerForBaseDomain(t)
+	ingressEndpoint := fmt.Sprintf("https://%s.%s/greeting", applicationName, test.BaseDomainForTest)
+	dnsServer.HTTPGetWithRetryWithCustomValidation(t, ingressEndpoint, K8SServiceWaitTimerRetries, K8SIngressWaitTimerSleep, sampleAppValidationFunction)
+
+	probe := startIngressProbe(dnsServer.HTTPClient(), ingressEndpoint, sampleAppValidationFunction)
+
+	// Point the workers at the new AMI. This only updates the launch template: the running workers are replaced below.
+	eksClusterOptions := test_structure.LoadTerraformOptions(t, workingDir)
+	eksClusterOptions.Vars["cluster_instance_ami_version_tag"] = upgradeAmiVersionTag
+	test_structure.SaveTerraformOptions(t, workingDir, eksClusterOptions)
+	terraform.Apply(t, eksClusterOptions)
+
+	asgNames := terraform.OutputList(t, eksClusterOptions, "eks_worker_asg_names")
+	require.NotEmpty(t, asgNames)
+	for _, asgName := range asgNames {
+		rollWorkerAsg(t, sess, workingDir, clusterName, asgName)
+	}
+
+	probe.stopAndAssertNoFailures(t)
+
+	for _, asgName := range asgNames {
+		for _, instanceID := range getAsgInstanceIDs(t, sess, asgName) {
+			instance := describeInstance(t, sess, instanceID)
+			assert.Equalf(t, upgradeAmiID, awsgo.StringValue(instance.ImageId), "Worker %s of %s is not running the upgraded AMI", instanceID, asgName)
+		}
+	}
+	validateSampleApp(t, workingDir, k8sServiceModulePath)
+}
+
+// createUpgradeWorkerAmi creates a new version of the worker AMI, by copying the AMI built at the start of the test and
+// tagging the copy with the given version tag, so that the eks-cluster example picks it up with its AMI filters.
+func createUpgradeWorkerAmi(t *testing.T, sess *session.Session, parentWorkingDir string, workingDir string, versionTag string) string {
+	sourceAmiID := test_structure.LoadArtifactID(t, parentWorkingDir)
+	uniqueID := test_structure.LoadString(t, workingDir, "uniqueID")
+
+	ec2Client := ec2.New(sess)
+	output, err := ec2Client.CopyImage(&ec2.CopyImageInput{
+		Name:          awsgo.String(fmt.Sprintf("eks-workers-upgrade-%s", uniqueID)),
+		SourceImageId: awsgo.String(sourceAmiID),
+		SourceRegion:  sess.Config.Region,
+	})
+	require.NoError(t, err)
+	amiID := awsgo.StringValue(output.ImageId)
+	test_structure.SaveString(t, workingDir, "upgradeAmiID", amiID)
+
+	_, err = ec2Client.CreateTags(&ec2.CreateTagsInput{
+		Resources: awsgo.StringSlice([]string{amiID}),
+		Tags: []*ec2.Tag{
+			{Key: awsgo.String("service"), Value: awsgo.String("eks-workers")},
+			{Key: awsgo.String("version"), Value: awsgo.String(versionTag)},
+		},
+	})
+	require.NoError(t, err)
+
+	logger.Logf(t, "Waiting for upgrade worker AMI %s to be available", amiID)
+	require.NoError(t, ec2Client.WaitUntilImageAvailable(&ec2.DescribeImagesInput{ImageIds: awsgo.StringSlice([]string{amiID})}))
+	return amiID
+}
+
+// rollWorkerAsg replaces all the workers of the given ASG with new workers launched from the current launch template.
+// The ASG is first scaled up to run a new worker next to each old one, then the old workers are drained, taken out of
+// the load balancers and terminated, and the ASG is scaled back down to its original size.
+func rollWorkerAsg(t *testing.T, sess *session.Session, workingDir string, clusterName string, asgName string) {
+	asgClient := autoscaling.New(sess)
+	asg := describeAsg(t, sess, asgName)
+	originalDesiredCapacity := awsgo.Int64Value(asg.DesiredCapacity)
+	originalMaxSize := awsgo.Int64Value(asg.MaxSize)
+	oldInstanceIDs := getAsgInstanceIDs(t, sess, asgName)
+	require.NotEmpty(t, oldInstanceIDs)
+
+	upgradeDesiredCapacity := originalDesiredCapacity + int64(len(oldInstanceIDs))
+	upgradeMaxSize := originalMaxSize
+	if upgradeMaxSize < upgradeDesiredCapacity {
+		upgradeMaxSize = upgradeDesiredCapacity
+	}
+	logger.Logf(t, "Scaling up %s from %d to %d workers", asgName, originalDesiredCapacity, upgradeDesiredCapacity)
+	_, err := asgClient.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: awsgo.String(asgName),
+		DesiredCapacity:      awsgo.Int64(upgradeDesiredCapacity),
+		MaxSize:              awsgo.Int64(upgradeMaxSize),
+	})
+	require.NoError(t, err)
+
+	newInstanceIDs := waitUntilAsgInstancesInService(t, sess, asgName, int(upgradeDesiredCapacity), oldInstanceIDs)
+	waitUntilWorkerNodesReady(t, loadEKSKubectlOptions(t, workingDir), newInstanceIDs)
+
+	oldNodes := getWorkerNodeNames(t, loadEKSKubectlOptions(t, workingDir), oldInstanceIDs)
+	for _, nodeName := range oldNodes {
+		cordonAndDrainNode(t, loadEKSKubectlOptions(t, workingDir), nodeName)
+	}
+	waitUntilInstancesDeregistered(t, sess, clusterName, oldInstanceIDs)
+
+	for _, instanceID := range oldInstanceIDs {
+		logger.Logf(t, "Terminating old worker %s", instanceID)
+		_, err := asgClient.TerminateInstanceInAutoScalingGroup(&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+			InstanceId:                     awsgo.String(instanceID),
+			ShouldDecrementDesiredCapacity: awsgo.Bool(true),
+		})
+		require.NoError(t, err)
+	}
+	_, err = asgClient.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: awsgo.String(asgName),
+		MaxSize:              awsgo.Int64(originalMaxSize),
+	})
+	require.NoError(t, err)
+
+	waitUntilAsgInstancesInService(t, sess, asgName, int(originalDesiredCapacity), oldInstanceIDs)
+	waitUntilNodesRemoved(t, loadEKSKubectlOptions(t, workingDir), oldNodes)
+}
+
+// startIngressProbe starts probing the given URL with the given client, which should resolve the host name of the
+// ingress through the local DNS server.
+func startIngressProbe(client *http.Client, url string, validationFunction func(int, string) bool) *requestProbe {
+	request := func(ctx context.Context) (int, string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return 0, "", err
+		}
+		response, err := client.Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		return response.StatusCode, string(body), err
+	}
+	return startRequestProbe(request, validationFunction)
+}
+
+func describeAsg(t *testing.T, sess *session.Session, asgName string) *autoscaling.Group {
+	output, err := autoscaling.New(sess).DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: awsgo.StringSlice([]string{asgName}),
+	})
+	require.NoError(t, err)
+	require.Len(t, output.AutoScalingGroups, 1)
+	return output.AutoScalingGroups[0]
+}
+
+func getAsgInstanceIDs(t *testing.T, sess *session.Session, asgName string) []string {
+	instanceIDs := []string{}
+	for _, instance := range describeAsg(t, sess, asgName).Instances {
+		instanceIDs = append(instanceIDs, awsgo.StringValue(instance.InstanceId))
+	}
+	return instanceIDs
+}
+
+func describeInstance(t *testing.T, sess *session.Session, instanceID string) *ec2.Instance {
+	output, err := ec2.New(sess).DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: awsgo.StringSlice([]string{instanceID}),
+	})
+	require.NoError(t, err)
+	require.Len(t, output.Reservations, 1)
+	require.Len(t, output.Reservations[0].Instances, 1)
+	return output.Reservations[0].Instances[0]
+}
+
+// waitUntilAsgInstancesInService waits until the ASG has exactly the given number of instances, all of them in service,
+// and returns the IDs of the instances that are not in excludedInstanceIDs.
+func waitUntilAsgInstancesInService(t *testing.T, sess *session.Session, asgName string, numInstances int, excludedInstanceIDs []string) []string {
+	excluded := map[string]bool{}
+	for _, instanceID := range excludedInstanceIDs {
+		excluded[instanceID] = true
+	}
+
+	newInstanceIDs := []string{}
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Wait for %d workers in service in %s", numInstances, asgName),
+		workerUpgradeRetries,
+		workerUpgradeSleep,
+		func() (string, error) {
+			instances := describeAsg(t, sess, asgName).Instances
+			if len(instances) != numInstances {
+				return "", fmt.Errorf("Expected %d workers, but found %d", numInstances, len(instances))
+			}
+			newInstanceIDs = []string{}
+			for _, instance := range instances {
+				if awsgo.StringValue(instance.LifecycleState) != autoscaling.LifecycleStateInService {
+					return "", fmt.Errorf("Worker %s is %s", awsgo.StringValue(instance.InstanceId), awsgo.StringValue(instance.LifecycleState))
+				}
+				if !excluded[awsgo.StringValue(instance.InstanceId)] {
+					newInstanceIDs = append(newInstanceIDs, awsgo.StringValue(instance.InstanceId))
+				}
+			}
+			return "All workers in service", nil
+		},
+	)
+	return newInstanceIDs
+}
+
+// waitUntilWorkerNodesReady waits until the given instances have joined the cluster and their nodes are ready.
+func waitUntilWorkerNodesReady(t *testing.T, kubectlOptions *k8s.KubectlOptions, instanceIDs []string) {
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Wait for the nodes of workers %v to be ready", instanceIDs),
+		workerUpgradeRetries,
+		workerUpgradeSleep,
+		func() (string, error) {
+			nodes, err := k8s.GetNodesE(t, kubectlOptions)
+			if err != nil {
+				return "", err
+			}
+			for _, instanceID := range instanceIDs {
+				node := findWorkerNode(nodes, instanceID)
+				if node == nil {
+					return "", fmt.Errorf("Worker %s has not joined the cluster yet", instanceID)
+				}
+				if !k8s.IsNodeReady(*node) {
+					return "", fmt.Errorf("Node %s of worker %s is not ready yet", node.Name, instanceID)
+				}
+			}
+			return "All nodes ready", nil
+		},
+	)
+}
+
+// getWorkerNodeNames returns the names of the nodes of the given instances.
+func getWorkerNodeNames(t *testing.T, kubectlOptions *k8s.KubectlOptions, instanceIDs []string) []string {
+	nodes := k8s.GetNodes(t, kubectlOptions)
+	names := []string{}
+	for _, instanceID := range instanceIDs {
+		node := findWorkerNode(nodes, instanceID)
+		require.NotNilf(t, node, "Could not find the node of worker %s", instanceID)
+		names = append(names, node.Name)
+	}
+	return names
+}
+
+// findWorkerNode returns the node of the given EC2 instance, or nil if the instance hasn't joined the cluster. The
+// provider ID of a node of an EC2 instance has the form aws:///AVAILABILITY_ZONE/INSTANCE_ID.
+func findWorkerNode(nodes []corev1.Node, instanceID string) *corev1.Node {
+	for i := range nodes {
+		if strings.HasSuffix(nodes[i].Spec.ProviderID, "/"+instanceID) {
+			return &nodes[i]
+		}
+	}
+	return nil
+}
+
+// cordonAndDrainNode marks the node as unschedulable and as excluded from the load balancers, and evicts all the pods
+// that aren't managed by a DaemonSet, like kubectl drain --ignore-daemonsets --delete-local-data does. Evictions respect
+// PodDisruptionBudgets, so an eviction that would take down too many pods of an app is retried until the evicted pods
+// have been rescheduled elsewhere.
+func cordonAndDrainNode(t *testing.T, kubectlOptions *k8s.KubectlOptions, nodeName string) {
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, kubectlOptions)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	logger.Logf(t, "Cordoning node %s", nodeName)
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	require.NoError(t, err)
+	node.Spec.Unschedulable = true
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	node.Labels[excludeFromLoadBalancersLabel] = "true"
+	_, err = clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	logger.Logf(t, "Draining node %s", nodeName)
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Evict all pods from node %s", nodeName),
+		workerUpgradeRetries,
+		workerUpgradeSleep,
+		func() (string, error) {
+			pods, err := clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + nodeName})
+			if err != nil {
+				return "", err
+			}
+			remaining := []string{}
+			for _, pod := range pods.Items {
+				if isDaemonSetOrMirrorPod(pod) || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+					continue
+				}
+				remaining = append(remaining, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+				if pod.DeletionTimestamp != nil {
+					continue
+				}
+				eviction := &policyv1beta1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+				err := clientset.PolicyV1beta1().Evictions(pod.Namespace).Evict(ctx, eviction)
+				// The API returns 429 Too Many Requests if the eviction would violate a PodDisruptionBudget, and 404 if
+				// the pod is already gone. Both are resolved by trying again.
+				if err != nil && !apierrors.IsTooManyRequests(err) && !apierrors.IsNotFound(err) {
+					return "", err
+				}
+			}
+			if len(remaining) > 0 {
+				return "", fmt.Errorf("Pods still running on node %s: %v", nodeName, remaining)
+			}
+			return "Node drained", nil
+		},
+	)
+}
+
+func isDaemonSetOrMirrorPod(pod corev1.Pod) bool {
+	if _, isMirror := pod.Annotations[corev1.MirrorPodAnnotationKey]; isMirror {
+		return true
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
+
+// waitUntilInstancesDeregistered waits until none of the given instances are registered in the target groups the AWS
+// Load Balancer Controller manages for the cluster, so that terminating the instances doesn't drop requests.
+func waitUntilInstancesDeregistered(t *testing.T, sess *session.Session, clusterName string, instanceIDs []string) {
+	targetGroupArns := getClusterTargetGroupArns(t, sess, clusterName)
+	elbClient := elbv2.New(sess)
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Wait for workers %v to be deregistered from the load balancers", instanceIDs),
+		workerUpgradeRetries,
+		workerUpgradeSleep,
+		func() (string, error) {
+			for _, targetGroupArn := range targetGroupArns {
+				output, err := elbClient.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{TargetGroupArn: awsgo.String(targetGroupArn)})
+				if err != nil {
+					return "", err
+				}
+				for _, target := range output.TargetHealthDescriptions {
+					targetID := awsgo.StringValue(target.Target.Id)
+					for _, instanceID := range instanceIDs {
+						if targetID == instanceID {
+							return "", fmt.Errorf("Worker %s is still registered in %s (%s)", instanceID, targetGroupArn, awsgo.StringValue(target.TargetHealth.State))
+						}
+					}
+				}
+			}
+			return "All workers deregistered", nil
+		},
+	)
+}
+
+func getClusterTargetGroupArns(t *testing.T, sess *session.Session, clusterName string) []string {
+	targetGroupArns := []string{}
+	err := resourcegroupstaggingapi.New(sess).GetResourcesPages(
+		&resourcegroupstaggingapi.GetResourcesInput{
+			ResourceTypeFilters: awsgo.StringSlice([]string{"elasticloadbalancing:targetgroup"}),
+			TagFilters: []*resourcegroupstaggingapi.TagFilter{
+				{Key: awsgo.String(loadBalancerControllerClusterTag), Values: awsgo.StringSlice([]string{clusterName})},
+			},
+		},
+		func(page *resourcegroupstaggingapi.GetResourcesOutput, lastPage bool) bool {
+			for _, resource := range page.ResourceTagMappingList {
+				targetGroupArns = append(targetGroupArns, awsgo.StringValue(resource.ResourceARN))
+			}
+			return true
+		},
+	)
+	require.NoError(t, err)
+	require.NotEmpty(t, targetGroupArns, "Found no target groups of the AWS Load Balancer Controller")
+	return targetGroupArns
+}
+
+// waitUntilNodesRemoved waits until the given nodes have left the cluster.
+func waitUntilNodesRemoved(t *testing.T, kubectlOptions *k8s.KubectlOptions, nodeNames []string) {
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Wait for nodes %v to leave the cluster", nodeNames),
+		workerUpgradeRetries,
+		workerUpgradeSleep,
+		func() (string, error) {
+			nodes, err := k8s.GetNodesE(t, kubectlOptions)
+			if err != nil {
+				return "", err
+			}
+			for _, node := range nodes {
+				for _, nodeName := range nodeNames {
+					if node.Name == nodeName {
+						return "", fmt.Errorf("Node %s is still in the cluster", nodeName)
+					}
+				}
+			}
+			return "All nodes removed", nil
+		},
+	)
+}
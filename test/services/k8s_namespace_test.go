@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/gruntwork-io/aws-service-catalog/test"
+	"github.com/gruntwork-io/aws-service-catalog/test/localk8s"
 
 	"github.com/gruntwork-io/terratest/modules/k8s"
 	"github.com/gruntwork-io/terratest/modules/random"
@@ -16,16 +17,20 @@ func TestK8SNamespace(t *testing.T) {
 	t.Parallel()
 
 	testFolder := "../../examples/for-learning-and-testing/services/k8s-namespace"
+	cluster := localk8s.GetCluster(t)
 
 	uniqueID := random.UniqueId()
 	namespaceName := fmt.Sprintf("applications-%s", strings.ToLower(uniqueID))
 	terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, "")
 	terraformOptions.Vars["name"] = namespaceName
+	for key, val := range cluster.TerraformVars() {
+		terraformOptions.Vars[key] = val
+	}
 
 	defer terraform.Destroy(t, terraformOptions)
 	terraform.InitAndApply(t, terraformOptions)
 
-	options := k8s.NewKubectlOptions("", "", namespaceName)
+	options := cluster.KubectlOptions(namespaceName)
 
 	// If this returns without error, then the namespace exists
 	k8s.GetNamespace(t, options, namespaceName)
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gruntwork-io/aws-service-catalog/test"
+)
+
+// The tests in this file only run terraform plan against the k8s-service example, and render the helm chart with the
+// values in the plan using `helm template`, so they don't need a Kubernetes cluster.
+
+// The address of the helm release in the plan of the k8s-service example.
+const k8sServiceHelmReleaseAddress = "module.application.helm_release.application"
+
+// renderedK8SService holds the Kubernetes objects that the k8s-service helm chart renders for a set of inputs.
+type renderedK8SService struct {
+	Deployments []appsv1.Deployment
+	Services    []corev1.Service
+	// The chart renders the Ingress with a different API version depending on the Kubernetes version, so we only
+	// decode the metadata, which is where the ALB configuration lives.
+	Ingresses []metav1.PartialObjectMetadata
+}
+
+var k8sServiceRenderTestCases = []struct {
+	name     string
+	vars     map[string]interface{}
+	validate func(t *testing.T, applicationName string, rendered renderedK8SService)
+}{
+	{
+		"ClusterInternal",
+		map[string]interface{}{},
+		func(t *testing.T, applicationName string, rendered renderedK8SService) {
+			service := requireSingleService(t, rendered)
+			assert.Equal(t, corev1.ServiceTypeClusterIP, service.Spec.Type)
+			assert.Empty(t, rendered.Ingresses)
+		},
+	},
+	{
+		"ConfigMapAsEnvVars",
+		map[string]interface{}{
+			"configmaps_as_env_vars": map[string]interface{}{
+				"greeting": map[string]interface{}{"greeting": "CONFIG_APP_GREETING"},
+			},
+		},
+		func(t *testing.T, applicationName string, rendered renderedK8SService) {
+			env := requireContainerEnvVar(t, rendered, "CONFIG_APP_GREETING")
+			require.NotNil(t, env.ValueFrom)
+			require.NotNil(t, env.ValueFrom.ConfigMapKeyRef)
+			assert.Nil(t, env.ValueFrom.SecretKeyRef)
+			assert.Equal(t, "greeting", env.ValueFrom.ConfigMapKeyRef.Name)
+			assert.Equal(t, "greeting", env.ValueFrom.ConfigMapKeyRef.Key)
+		},
+	},
+	{
+		"SecretAsEnvVars",
+		map[string]interface{}{
+			"secrets_as_env_vars": map[string]interface{}{
+				"greeting": map[string]interface{}{"greeting": "CONFIG_APP_GREETING"},
+			},
+		},
+		func(t *testing.T, applicationName string, rendered renderedK8SService) {
+			env := requireContainerEnvVar(t, rendered, "CONFIG_APP_GREETING")
+			require.NotNil(t, env.ValueFrom)
+			require.NotNil(t, env.ValueFrom.SecretKeyRef)
+			assert.Nil(t, env.ValueFrom.ConfigMapKeyRef)
+			assert.Equal(t, "greeting", env.ValueFrom.SecretKeyRef.Name)
+			assert.Equal(t, "greeting", env.ValueFrom.SecretKeyRef.Key)
+		},
+	},
+	{
+		"InternalIngress",
+		map[string]interface{}{
+			"expose_type": "internal",
+			"domain_name": "sampleapp-render.internal." + test.BaseDomainForTest,
+		},
+		func(t *testing.T, applicationName string, rendered renderedK8SService) {
+			service := requireSingleService(t, rendered)
+			assert.Equal(t, corev1.ServiceTypeNodePort, service.Spec.Type)
+
+			annotations := requireSingleIngress(t, rendered).Annotations
+			assert.Equal(t, "alb", annotations["kubernetes.io/ingress.class"])
+			assert.Equal(t, "internal", annotations["alb.ingress.kubernetes.io/scheme"])
+			assert.NotContains(t, annotations, "alb.ingress.kubernetes.io/group.name")
+			assert.NotContains(t, annotations, "alb.ingress.kubernetes.io/group.order")
+			assert.Contains(t, annotations["alb.ingress.kubernetes.io/load-balancer-attributes"], fmt.Sprintf("access_logs.s3.prefix=%s", applicationName))
+		},
+	},
+	{
+		"ExternalIngressInGroup",
+		map[string]interface{}{
+			"expose_type": "external",
+			"domain_name": "sampleapp-render." + test.BaseDomainForTest,
+			"ingress_group": map[string]interface{}{
+				"name":     "render-test",
+				"priority": 2,
+			},
+			"ingress_access_logs_s3_bucket_name": "render-test-alb-access-logs",
+			"ingress_access_logs_s3_prefix":      "render-test-prefix",
+		},
+		func(t *testing.T, applicationName string, rendered renderedK8SService) {
+			service := requireSingleService(t, rendered)
+			assert.Equal(t, corev1.ServiceTypeNodePort, service.Spec.Type)
+
+			annotations := requireSingleIngress(t, rendered).Annotations
+			assert.Equal(t, "internet-facing", annotations["alb.ingress.kubernetes.io/scheme"])
+			assert.Equal(t, "render-test", annotations["alb.ingress.kubernetes.io/group.name"])
+			assert.Equal(t, "2", annotations["alb.ingress.kubernetes.io/group.order"])
+			assert.Equal(
+				t,
+				"access_logs.s3.enabled=true,access_logs.s3.bucket=render-test-alb-access-logs,access_logs.s3.prefix=render-test-prefix",
+				annotations["alb.ingress.kubernetes.io/load-balancer-attributes"],
+			)
+			// The example sets a short TTL so that the records are available quickly during testing.
+			assert.Equal(t, "10", annotations["external-dns.alpha.kubernetes.io/ttl"])
+		},
+	},
+}
+
+func TestK8SServiceRender(t *testing.T) {
+	t.Parallel()
+
+	for _, testCase := range k8sServiceRenderTestCases {
+		// Capture range variable to within for block scope so that it doesn't change as we switch go routines with the
+		// t.Parallel calls.
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			testFolder := test_structure.CopyTerraformFolderToTemp(
+				t,
+				"../../",
+				"examples/for-learning-and-testing/services/k8s-service",
+			)
+			applicationName := "sampleapp-" + strings.ToLower(testCase.name)
+
+			terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, "us-west-2")
+			terraformOptions.Vars["application_name"] = applicationName
+			terraformOptions.Vars["namespace"] = "render-test"
+			// Don't plan the access logs bucket, as we only care about the Kubernetes objects here.
+			terraformOptions.Vars["ingress_access_logs_s3_bucket_already_exists"] = true
+			for key, val := range testCase.vars {
+				terraformOptions.Vars[key] = val
+			}
+
+			rendered := renderK8SServiceFromPlan(t, terraformOptions)
+
+			// These hold for every combination of inputs: the other k8s-service tests find the pods and service of the
+			// app by these labels.
+			require.Len(t, rendered.Deployments, 1)
+			deployment := rendered.Deployments[0]
+			expectedLabels := map[string]string{
+				"app.kubernetes.io/name":     applicationName,
+				"app.kubernetes.io/instance": applicationName,
+			}
+			for key, value := range expectedLabels {
+				assert.Equal(t, value, deployment.Spec.Template.Labels[key], "Label %s of the pod template", key)
+				assert.Equal(t, value, deployment.Spec.Selector.MatchLabels[key], "Label %s of the deployment selector", key)
+			}
+			service := requireSingleService(t, rendered)
+			for key, value := range service.Spec.Selector {
+				assert.Equal(t, value, deployment.Spec.Template.Labels[key], "The service selector %s does not match the pods", key)
+			}
+
+			testCase.validate(t, applicationName, rendered)
+		})
+	}
+}
+
+// TestK8SServiceRenderIngressGroupOrder checks that apps sharing an ingress group each get the group order of their own
+// priority, which is what determines the order in which the ALB evaluates their rules.
+func TestK8SServiceRenderIngressGroupOrder(t *testing.T) {
+	t.Parallel()
+
+	priorities := map[string]int{"first": 1, "second": 20}
+	orders := map[string]string{}
+	for name, priority := range priorities {
+		testFolder := test_structure.CopyTerraformFolderToTemp(
+			t,
+			"../../",
+			"examples/for-learning-and-testing/services/k8s-service",
+		)
+		terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, "us-west-2")
+		terraformOptions.Vars["application_name"] = "sampleapp-" + name
+		terraformOptions.Vars["expose_type"] = "external"
+		terraformOptions.Vars["domain_name"] = fmt.Sprintf("sampleapp-%s.%s", name, test.BaseDomainForTest)
+		terraformOptions.Vars["ingress_access_logs_s3_bucket_already_exists"] = true
+		terraformOptions.Vars["ingress_group"] = map[string]interface{}{
+			"name":     "render-order-test",
+			"priority": priority,
+		}
+
+		annotations := requireSingleIngress(t, renderK8SServiceFromPlan(t, terraformOptions)).Annotations
+		assert.Equal(t, "render-order-test", annotations["alb.ingress.kubernetes.io/group.name"])
+		orders[name] = annotations["alb.ingress.kubernetes.io/group.order"]
+	}
+
+	assert.Equal(t, map[string]string{"first": "1", "second": "20"}, orders)
+}
+
+// renderK8SServiceFromPlan runs terraform plan on the k8s-service example with the given options, and renders the helm
+// release in the plan with `helm template`, using the chart, version and values from the plan. The example is pointed
+// at a kubeconfig for a cluster that doesn't exist, which works as long as nothing needs to talk to the cluster.
+func renderK8SServiceFromPlan(t *testing.T, terraformOptions *terraform.Options) renderedK8SService {
+	tmpDir, err := ioutil.TempDir("", "k8s-service-render-")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	kubeConfigPath := filepath.Join(tmpDir, "kubeconfig")
+	require.NoError(t, ioutil.WriteFile(kubeConfigPath, []byte(offlineKubeConfig), 0600))
+	terraformOptions.Vars["kubeconfig_auth_type"] = "context"
+	terraformOptions.Vars["kubeconfig_path"] = kubeConfigPath
+	terraformOptions.Vars["kubeconfig_context"] = "offline"
+	terraformOptions.PlanFilePath = filepath.Join(tmpDir, "tfplan")
+
+	plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+	release, hasRelease := plan.ResourcePlannedValuesMap[k8sServiceHelmReleaseAddress]
+	require.Truef(t, hasRelease, "%s is not in the plan", k8sServiceHelmReleaseAddress)
+
+	values, hasValues := release.AttributeValues["values"].([]interface{})
+	require.True(t, hasValues, "The values of the helm release are not known at plan time")
+	args := []string{
+		release.AttributeValues["name"].(string),
+		release.AttributeValues["chart"].(string),
+		"--repo", release.AttributeValues["repository"].(string),
+		"--version", release.AttributeValues["version"].(string),
+		"--namespace", release.AttributeValues["namespace"].(string),
+	}
+	for i, value := range values {
+		valuesPath := filepath.Join(tmpDir, fmt.Sprintf("values-%d.yaml", i))
+		require.NoError(t, ioutil.WriteFile(valuesPath, []byte(value.(string)), 0600))
+		args = append(args, "--values", valuesPath)
+	}
+
+	out, err := helm.RunHelmCommandAndGetStdOutE(t, &helm.Options{}, "template", args...)
+	require.NoError(t, err)
+	return decodeRenderedK8SService(t, out)
+}
+
+// decodeRenderedK8SService splits the output of `helm template` into documents, and decodes the ones we assert on.
+func decodeRenderedK8SService(t *testing.T, out string) renderedK8SService {
+	rendered := renderedK8SService{}
+	for _, document := range strings.Split(out, "\n---") {
+		var typeMeta metav1.TypeMeta
+		helm.UnmarshalK8SYaml(t, document, &typeMeta)
+
+		switch typeMeta.Kind {
+		case "Deployment":
+			var deployment appsv1.Deployment
+			helm.UnmarshalK8SYaml(t, document, &deployment)
+			rendered.Deployments = append(rendered.Deployments, deployment)
+		case "Service":
+			var service corev1.Service
+			helm.UnmarshalK8SYaml(t, document, &service)
+			rendered.Services = append(rendered.Services, service)
+		case "Ingress":
+			var ingress metav1.PartialObjectMetadata
+			helm.UnmarshalK8SYaml(t, document, &ingress)
+			rendered.Ingresses = append(rendered.Ingresses, ingress)
+		}
+	}
+	return rendered
+}
+
+func requireSingleService(t *testing.T, rendered renderedK8SService) corev1.Service {
+	require.Len(t, rendered.Services, 1)
+	return rendered.Services[0]
+}
+
+func requireSingleIngress(t *testing.T, rendered renderedK8SService) metav1.PartialObjectMetadata {
+	require.Len(t, rendered.Ingresses, 1)
+	return rendered.Ingresses[0]
+}
+
+// requireContainerEnvVar returns the environment variable with the given name of the app container.
+func requireContainerEnvVar(t *testing.T, rendered renderedK8SService, name string) corev1.EnvVar {
+	require.Len(t, rendered.Deployments, 1)
+	containers := rendered.Deployments[0].Spec.Template.Spec.Containers
+	require.NotEmpty(t, containers)
+	for _, env := range containers[0].Env {
+		if env.Name == name {
+			return env
+		}
+	}
+	require.FailNowf(t, "Missing environment variable", "The app container has no environment variable %s", name)
+	return corev1.EnvVar{}
+}
+
+// A kubeconfig for a cluster that doesn't exist, so that the providers of the example can be configured without a real
+// cluster. Port 1 on localhost refuses connections, so anything that does try to talk to the cluster fails fast.
+const offlineKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: offline
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: offline
+  context:
+    cluster: offline
+    user: offline
+current-context: offline
+users:
+- name: offline
+  user:
+    token: offline
+`
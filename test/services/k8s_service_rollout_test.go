@@ -0,0 +1,239 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gruntwork-io/aws-service-catalog/test"
+	"github.com/gruntwork-io/aws-service-catalog/test/localk8s"
+)
+
+const (
+	initialGreeting = "Hello from the initial rollout"
+	updatedGreeting = "Hello from the rolling update"
+
+	// An image tag that doesn't exist, so that the pods of the rollout never start.
+	brokenImageVersion = "this-tag-does-not-exist"
+	// How long terraform waits for the broken rollout before giving up. There is no point in waiting for the default 5
+	// minutes, as the pods will never become healthy.
+	brokenRolloutWaitTimeout = 60
+)
+
+// TestK8SServiceRollingUpdate checks the day two behavior of k8s-service: re-applying with a new config rolls out new
+// pods without dropping any requests, and a rollout of a broken image doesn't take the service down and can be rolled
+// back.
+func TestK8SServiceRollingUpdate(t *testing.T) {
+	t.Parallel()
+
+	// Uncomment any of the following to skip that section during the test
+	//os.Setenv("SKIP_create_namespace", "true")
+	//os.Setenv("SKIP_deploy", "true")
+	//os.Setenv("SKIP_validate", "true")
+	//os.Setenv("SKIP_rolling_update", "true")
+	//os.Setenv("SKIP_broken_rollout", "true")
+	//os.Setenv("SKIP_rollback", "true")
+	//os.Setenv("SKIP_destroy", "true")
+	//os.Setenv("SKIP_delete_namespace", "true")
+
+	workingDir := filepath.Join(".", "stages", t.Name())
+	testFolder := test_structure.CopyTerraformFolderToTemp(
+		t,
+		"../../",
+		"examples/for-learning-and-testing/services/k8s-service",
+	)
+	applicationName := "sampleapp"
+
+	cluster := localk8s.GetCluster(t)
+	cluster.LoadImage(t, sampleAppImageTag())
+	rootOptions := cluster.KubectlOptions("")
+
+	defer test_structure.RunTestStage(t, "delete_namespace", func() {
+		namespaceOptions := test_structure.LoadKubectlOptions(t, workingDir)
+		k8s.DeleteNamespace(t, rootOptions, namespaceOptions.Namespace)
+	})
+	test_structure.RunTestStage(t, "create_namespace", func() {
+		namespace := strings.ToLower(random.UniqueId())
+		k8s.CreateNamespace(t, rootOptions, namespace)
+		test_structure.SaveKubectlOptions(t, workingDir, cluster.KubectlOptions(namespace))
+	})
+
+	defer test_structure.RunTestStage(t, "destroy", func() {
+		terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
+		terraform.Destroy(t, terraformOptions)
+	})
+	test_structure.RunTestStage(t, "deploy", func() {
+		namespaceOptions := test_structure.LoadKubectlOptions(t, workingDir)
+
+		terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, "us-west-2")
+		terraformOptions.Vars["application_name"] = applicationName
+		terraformOptions.Vars["namespace"] = namespaceOptions.Namespace
+		terraformOptions.Vars["image"] = sampleAppImage
+		terraformOptions.Vars["image_version"] = sampleAppImageVersion
+		terraformOptions.Vars["server_greeting"] = initialGreeting
+		for key, val := range cluster.TerraformVars() {
+			terraformOptions.Vars[key] = val
+		}
+		test_structure.SaveTerraformOptions(t, workingDir, terraformOptions)
+
+		terraform.InitAndApply(t, terraformOptions)
+	})
+
+	test_structure.RunTestStage(t, "validate", func() {
+		namespaceOptions := test_structure.LoadKubectlOptions(t, workingDir)
+		verifyPodsCreatedSuccessfully(t, namespaceOptions, applicationName)
+		verifyAllPodsAvailable(t, namespaceOptions, applicationName, "/greeting", sampleAppValidationWithGreetingFunctionGenerator(initialGreeting))
+		verifyServiceAvailable(t, namespaceOptions, applicationName)
+	})
+
+	test_structure.RunTestStage(t, "rolling_update", func() {
+		namespaceOptions := test_structure.LoadKubectlOptions(t, workingDir)
+		terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
+		oldPods := listAppPodNames(t, namespaceOptions, applicationName)
+
+		// While the rollout is in progress, the service can serve either greeting, but it must never fail.
+		probe := startServiceProbe(t, namespaceOptions, applicationName, "/greeting", sampleAppValidationWithAnyGreeting(initialGreeting, updatedGreeting))
+		terraformOptions.Vars["server_greeting"] = updatedGreeting
+		test_structure.SaveTerraformOptions(t, workingDir, terraformOptions)
+		terraform.Apply(t, terraformOptions)
+		waitUntilOnlyNewAppPodsAvailable(t, namespaceOptions, applicationName, oldPods)
+		probe.stopAndAssertNoFailures(t)
+
+		verifyAllPodsAvailable(t, namespaceOptions, applicationName, "/greeting", sampleAppValidationWithGreetingFunctionGenerator(updatedGreeting))
+	})
+
+	test_structure.RunTestStage(t, "broken_rollout", func() {
+		namespaceOptions := test_structure.LoadKubectlOptions(t, workingDir)
+		terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
+		workingPods := listAppPodNames(t, namespaceOptions, applicationName)
+
+		// We don't save these options, so that the destroy and rollback stages use the last working config.
+		brokenOptions, err := terraformOptions.Clone()
+		require.NoError(t, err)
+		brokenOptions.Vars["image_version"] = brokenImageVersion
+		brokenOptions.Vars["wait_timeout"] = brokenRolloutWaitTimeout
+
+		probe := startServiceProbe(t, namespaceOptions, applicationName, "/greeting", sampleAppValidationWithGreetingFunctionGenerator(updatedGreeting))
+		_, err = terraform.ApplyE(t, brokenOptions)
+		assert.Error(t, err, "Rolling out an image that doesn't exist should fail")
+		probe.stopAndAssertNoFailures(t)
+
+		// The pods of the last working rollout should keep serving, next to the pods of the broken rollout that never
+		// become available.
+		pods := listAppPods(t, namespaceOptions, applicationName)
+		brokenPods := 0
+		for _, pod := range pods {
+			if workingPods[pod.Name] {
+				assert.Truef(t, k8s.IsPodAvailable(&pod), "Pod %s of the last working rollout is no longer available", pod.Name)
+			} else {
+				brokenPods++
+				assert.Falsef(t, k8s.IsPodAvailable(&pod), "Pod %s of the broken rollout is available", pod.Name)
+			}
+		}
+		assert.NotZero(t, brokenPods, "The broken rollout did not create any pods")
+	})
+
+	test_structure.RunTestStage(t, "rollback", func() {
+		namespaceOptions := test_structure.LoadKubectlOptions(t, workingDir)
+		terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
+
+		probe := startServiceProbe(t, namespaceOptions, applicationName, "/greeting", sampleAppValidationWithGreetingFunctionGenerator(updatedGreeting))
+		terraform.Apply(t, terraformOptions)
+		waitUntilNoUnavailableAppPods(t, namespaceOptions, applicationName)
+		probe.stopAndAssertNoFailures(t)
+
+		for _, pod := range listAppPods(t, namespaceOptions, applicationName) {
+			for _, container := range pod.Spec.Containers {
+				assert.NotContains(t, container.Image, brokenImageVersion)
+			}
+		}
+	})
+}
+
+// sampleAppValidationWithAnyGreeting checks that we get a 200 response with any of the given greetings.
+func sampleAppValidationWithAnyGreeting(greetings ...string) func(statusCode int, body string) bool {
+	return func(statusCode int, body string) bool {
+		for _, greeting := range greetings {
+			if sampleAppValidationWithGreetingFunctionGenerator(greeting)(statusCode, body) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func listAppPods(t *testing.T, kubectlOptions *k8s.KubectlOptions, appName string) []corev1.Pod {
+	filters := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app.kubernetes.io/name=%s,app.kubernetes.io/instance=%s", appName, appName),
+	}
+	return k8s.ListPods(t, kubectlOptions, filters)
+}
+
+func listAppPodNames(t *testing.T, kubectlOptions *k8s.KubectlOptions, appName string) map[string]bool {
+	names := map[string]bool{}
+	for _, pod := range listAppPods(t, kubectlOptions, appName) {
+		names[pod.Name] = true
+	}
+	require.NotEmpty(t, names)
+	return names
+}
+
+// waitUntilOnlyNewAppPodsAvailable waits until all the pods of the given app from before a rollout are gone, and the
+// expected number of new pods are available.
+func waitUntilOnlyNewAppPodsAvailable(t *testing.T, kubectlOptions *k8s.KubectlOptions, appName string, oldPods map[string]bool) {
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Wait for the old pods of %s to be replaced", appName),
+		K8SServiceWaitTimerRetries,
+		K8SServiceWaitTimerSleep,
+		func() (string, error) {
+			pods := listAppPods(t, kubectlOptions, appName)
+			for _, pod := range pods {
+				if oldPods[pod.Name] {
+					return "", fmt.Errorf("Old pod %s still exists", pod.Name)
+				}
+				if !k8s.IsPodAvailable(&pod) {
+					return "", fmt.Errorf("New pod %s is not available yet", pod.Name)
+				}
+			}
+			if len(pods) != K8SServiceNumPodsExpected {
+				return "", fmt.Errorf("Expected %d pods, but found %d", K8SServiceNumPodsExpected, len(pods))
+			}
+			return "All old pods replaced", nil
+		},
+	)
+}
+
+// waitUntilNoUnavailableAppPods waits until the given app has the expected number of pods, and all of them are
+// available.
+func waitUntilNoUnavailableAppPods(t *testing.T, kubectlOptions *k8s.KubectlOptions, appName string) {
+	retry.DoWithRetry(
+		t,
+		fmt.Sprintf("Wait for all the pods of %s to be available", appName),
+		K8SServiceWaitTimerRetries,
+		K8SServiceWaitTimerSleep,
+		func() (string, error) {
+			pods := listAppPods(t, kubectlOptions, appName)
+			for _, pod := range pods {
+				if !k8s.IsPodAvailable(&pod) {
+					return "", fmt.Errorf("Pod %s is not available", pod.Name)
+				}
+			}
+			if len(pods) != K8SServiceNumPodsExpected {
+				return "", fmt.Errorf("Expected %d pods, but found %d", K8SServiceNumPodsExpected, len(pods))
+			}
+			return "All pods available", nil
+		},
+	)
+}
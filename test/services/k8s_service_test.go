@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/gruntwork-io/aws-service-catalog/test"
+	"github.com/gruntwork-io/aws-service-catalog/test/localk8s"
 
 	"github.com/gruntwork-io/terratest/modules/k8s"
 	"github.com/gruntwork-io/terratest/modules/random"
@@ -51,6 +52,10 @@ func TestK8SService(t *testing.T) {
 	//os.Setenv("SKIP_destroy", "true")
 	//os.Setenv("SKIP_delete_namespace", "true")
 
+	// All the test cases share one cluster, which is only cleaned up after they have all finished.
+	cluster := localk8s.GetCluster(t)
+	cluster.LoadImage(t, sampleAppImageTag())
+
 	for _, testCase := range k8sServiceTestCases {
 		// Capture range variable to within for block scope so that it doesn't change as we switch go routines with the
 		// t.Parallel calls.
@@ -70,7 +75,7 @@ func TestK8SService(t *testing.T) {
 				"examples/for-learning-and-testing/services/k8s-service",
 			)
 			applicationName := "sampleapp"
-			rootOptions := k8s.NewKubectlOptions("", "", "")
+			rootOptions := cluster.KubectlOptions("")
 
 			defer test_structure.RunTestStage(t, "delete_namespace", func() {
 				namespaceOptions := test_structure.LoadKubectlOptions(t, workingDir)
@@ -79,7 +84,7 @@ func TestK8SService(t *testing.T) {
 			test_structure.RunTestStage(t, "create_namespace", func() {
 				namespace := strings.ToLower(random.UniqueId())
 				k8s.CreateNamespace(t, rootOptions, namespace)
-				namespaceOptions := k8s.NewKubectlOptions("", "", namespace)
+				namespaceOptions := cluster.KubectlOptions(namespace)
 				test_structure.SaveKubectlOptions(t, workingDir, namespaceOptions)
 			})
 
@@ -93,6 +98,11 @@ func TestK8SService(t *testing.T) {
 				terraformOptions := test.CreateBaseTerraformOptions(t, testFolder, "us-west-2")
 				terraformOptions.Vars["application_name"] = applicationName
 				terraformOptions.Vars["namespace"] = namespaceOptions.Namespace
+				terraformOptions.Vars["image"] = sampleAppImage
+				terraformOptions.Vars["image_version"] = sampleAppImageVersion
+				for key, val := range cluster.TerraformVars() {
+					terraformOptions.Vars[key] = val
+				}
 				for key, val := range testCase.extraVarsFunc(t, namespaceOptions) {
 					terraformOptions.Vars[key] = val
 				}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -19,6 +21,18 @@ const (
 	K8SServiceNumPodsExpected  = 1
 )
 
+// The Gruntwork AWS Sample App image that the k8s-service tests deploy. The version should match the default of the
+// image_version variable of the k8s-service example.
+const (
+	sampleAppImage = "gruntwork/aws-sample-app"
+	// patcher auto-update-variable: aws-sample-app
+	sampleAppImageVersion = "v0.0.5"
+)
+
+func sampleAppImageTag() string {
+	return fmt.Sprintf("%s:%s", sampleAppImage, sampleAppImageVersion)
+}
+
 // verifyPodsCreatedSuccessfully waits until the pods for the given helm release are created.
 func verifyPodsCreatedSuccessfully(t *testing.T, kubectlOptions *k8s.KubectlOptions, appName string) {
 	// Get the pods and wait until they are all ready
@@ -89,3 +103,98 @@ func verifyServiceAvailable(t *testing.T, kubectlOptions *k8s.KubectlOptions, ap
 	service := services[0]
 	k8s.WaitUntilServiceAvailable(t, kubectlOptions, service.Name, K8SServiceWaitTimerRetries, K8SServiceWaitTimerSleep)
 }
+
+// How often a requestProbe sends a request.
+const requestProbeInterval = 250 * time.Millisecond
+
+// requestProbe continuously sends requests to an app while it is being changed (e.g., during a rollout), and records the
+// ones that fail.
+type requestProbe struct {
+	stop chan struct{}
+	done sync.WaitGroup
+
+	mutex    sync.Mutex
+	total    int
+	failures []string
+}
+
+// startRequestProbe starts calling request every requestProbeInterval. A request fails if request returns an error or
+// if validationFunction rejects the status code and body it returns. Call stopAndAssertNoFailures to end the probe.
+func startRequestProbe(
+	request func(ctx context.Context) (int, string, error),
+	validationFunction func(int, string) bool,
+) *requestProbe {
+	probe := &requestProbe{stop: make(chan struct{})}
+	probe.done.Add(1)
+	go func() {
+		defer probe.done.Done()
+		ticker := time.NewTicker(requestProbeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-probe.stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				statusCode, body, err := request(ctx)
+				cancel()
+
+				failure := ""
+				if err != nil {
+					failure = err.Error()
+				} else if !validationFunction(statusCode, body) {
+					failure = fmt.Sprintf("unexpected response (%d): %s", statusCode, body)
+				}
+				probe.record(failure)
+			}
+		}
+	}()
+	return probe
+}
+
+// startServiceProbe starts probing the given path on the app port of the service of the given app, through the
+// Kubernetes API server proxy. The API server proxy picks a ready endpoint of the service for each request, just like
+// kube-proxy, so this sees the same endpoints that clients inside the cluster would see during a rollout. A request
+// fails if the proxy returns an error or if validationFunction rejects the response.
+func startServiceProbe(
+	t *testing.T,
+	kubectlOptions *k8s.KubectlOptions,
+	appName string,
+	path string,
+	validationFunction func(int, string) bool,
+) *requestProbe {
+	clientset, err := k8s.GetKubernetesClientFromOptionsE(t, kubectlOptions)
+	require.NoError(t, err)
+	filters := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app.kubernetes.io/name=%s,app.kubernetes.io/instance=%s", appName, appName),
+	}
+	services := k8s.ListServices(t, kubectlOptions, filters)
+	require.Equal(t, len(services), 1)
+	proxy := clientset.CoreV1().Services(kubectlOptions.Namespace).ProxyGet("http", services[0].Name, "app", path, nil)
+
+	request := func(ctx context.Context) (int, string, error) {
+		body, err := proxy.DoRaw(ctx)
+		return 200, string(body), err
+	}
+	return startRequestProbe(request, validationFunction)
+}
+
+func (probe *requestProbe) record(failure string) {
+	probe.mutex.Lock()
+	defer probe.mutex.Unlock()
+	probe.total++
+	if failure != "" {
+		probe.failures = append(probe.failures, fmt.Sprintf("%s: %s", time.Now().Format(time.RFC3339), failure))
+	}
+}
+
+// stopAndAssertNoFailures stops the probe, and checks that it sent requests and that none of them failed.
+func (probe *requestProbe) stopAndAssertNoFailures(t *testing.T) {
+	close(probe.stop)
+	probe.done.Wait()
+
+	probe.mutex.Lock()
+	defer probe.mutex.Unlock()
+	require.NotZero(t, probe.total, "The probe did not send any requests")
+	require.Emptyf(t, probe.failures, "%d of %d requests of the probe failed", len(probe.failures), probe.total)
+}
@@ -1,12 +1,16 @@
 package test
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	awsgo "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	"github.com/gruntwork-io/terratest/modules/docker"
@@ -80,6 +84,33 @@ func GetExternalAccountId() string {
 	return os.Getenv("TEST_EXTERNAL_ACCOUNT_ID")
 }
 
+// The name of the IAM role in the external account that the tests assume when verifying cross account access. This
+// can be overridden with the TEST_EXTERNAL_ACCOUNT_ROLE_NAME environment variable.
+const defaultExternalAccountRoleName = "allow-full-access-from-other-accounts"
+
+// GetExternalAccountRoleArn returns the ARN of the IAM role to assume in the external account (TEST_EXTERNAL_ACCOUNT_ID)
+// when testing cross account access.
+func GetExternalAccountRoleArn() string {
+	roleName := os.Getenv("TEST_EXTERNAL_ACCOUNT_ROLE_NAME")
+	if roleName == "" {
+		roleName = defaultExternalAccountRoleName
+	}
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s", GetExternalAccountId(), roleName)
+}
+
+// IsLongModeEnabled returns true if the TEST_LONG_MODE environment variable is set to true. Long mode enables additional
+// validations that take too long to run on every PR (e.g., waiting for cross region replication to finish).
+func IsLongModeEnabled() bool {
+	return os.Getenv("TEST_LONG_MODE") == "true"
+}
+
+// IsPublicDNSCheckEnabled returns true if the TEST_PUBLIC_DNS environment variable is set to true. By default, tests
+// check Route 53 records through a local DNS server (see the localdns package), as waiting on public DNS propagation is
+// slow and flaky. Enable this to also check that the records resolve through public DNS.
+func IsPublicDNSCheckEnabled() bool {
+	return os.Getenv("TEST_PUBLIC_DNS") == "true"
+}
+
 func CreateBaseTerraformOptions(t *testing.T, terraformDir string, awsRegion string) *terraform.Options {
 	return &terraform.Options{
 		TerraformDir: terraformDir,
@@ -108,19 +139,6 @@ func AuthECRAndPushImages(t *testing.T, awsRegion string, imgTags []string) {
 }
 
 func AuthECRAndCallFn(t *testing.T, awsRegion string, fn func()) {
-	// We've seen issues where multiple tests doing 'docker login' concurrently leads to conflicts, so we use a lock
-	// to ensure they don't do it simultaneously.
-	defer ecrAuthMutex.Unlock()
-	ecrAuthMutex.Lock()
-
-	defer func() {
-		cmd := shell.Command{
-			Command: "docker",
-			Args:    []string{"logout"},
-		}
-		shell.RunCommand(t, cmd)
-	}()
-
 	ecrURI := fmt.Sprintf(
 		"%s.dkr.ecr.%s.amazonaws.com",
 		aws.GetAccountId(t), awsRegion,
@@ -137,7 +155,54 @@ func AuthECRAndCallFn(t *testing.T, awsRegion string, fn func()) {
 			),
 		},
 	}
-	shell.RunCommand(t, cmd)
+	dockerLoginAndCallFn(t, cmd, fn)
+}
+
+// AuthECRWithRoleAndCallFn authenticates docker to the ECR registry of the given account using credentials from the
+// given IAM role, and then calls fn. This is useful for verifying that an external account can (or can not) access the
+// repos of the current account.
+func AuthECRWithRoleAndCallFn(t *testing.T, awsRegion string, registryAccountID string, roleArn string, fn func()) {
+	sess, err := aws.NewAuthenticatedSessionFromRole(awsRegion, roleArn)
+	require.NoError(t, err)
+
+	// The authorization token returned for the assumed role is valid for any registry that the role has access to, so
+	// we can use it to log in to the registry in the other account.
+	tokenOutput, err := ecr.New(sess).GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(tokenOutput.AuthorizationData))
+	decodedToken, err := base64.StdEncoding.DecodeString(awsgo.StringValue(tokenOutput.AuthorizationData[0].AuthorizationToken))
+	require.NoError(t, err)
+	password := strings.TrimPrefix(string(decodedToken), "AWS:")
+
+	ecrURI := fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", registryAccountID, awsRegion)
+
+	// Pass the password in through the environment so that it doesn't show up in the logs.
+	cmd := shell.Command{
+		Command: "bash",
+		Args: []string{
+			"-c",
+			fmt.Sprintf(`echo "$ECR_PASSWORD" | docker login -u AWS --password-stdin %s`, ecrURI),
+		},
+		Env: map[string]string{"ECR_PASSWORD": password},
+	}
+	dockerLoginAndCallFn(t, cmd, fn)
+}
+
+func dockerLoginAndCallFn(t *testing.T, loginCmd shell.Command, fn func()) {
+	// We've seen issues where multiple tests doing 'docker login' concurrently leads to conflicts, so we use a lock
+	// to ensure they don't do it simultaneously.
+	defer ecrAuthMutex.Unlock()
+	ecrAuthMutex.Lock()
+
+	defer func() {
+		cmd := shell.Command{
+			Command: "docker",
+			Args:    []string{"logout"},
+		}
+		shell.RunCommand(t, cmd)
+	}()
+
+	shell.RunCommand(t, loginCmd)
 
 	fn()
 }